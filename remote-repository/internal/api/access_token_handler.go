@@ -0,0 +1,96 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type AccessTokenHandler struct {
+	Authentication     *middleware.AuthenticationMiddleware
+	AccessTokenService *services.AccessTokenService
+	Logger             *slog.Logger
+}
+
+func (th *AccessTokenHandler) HandleCreateAccessToken(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	var req models.CreateAccessTokenRequest
+	if err = c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	token, plaintext, err := th.AccessTokenService.Create(username, req.Name, req.Scopes, req.ExpiresInDays, c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case services.ErrInvalidScope, services.ErrInvalidTokenName, services.ErrInvalidTokenExpiry:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case services.ErrTokenNameAlreadyUsed:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			th.Logger.Error("Error creating access token", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"token": plaintext, "access_token": token})
+}
+
+func (th *AccessTokenHandler) HandleGetAllAccessTokens(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	tokens, err := th.AccessTokenService.GetAll(username)
+
+	if err != nil {
+		th.Logger.Error("Error listing access tokens", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (th *AccessTokenHandler) HandleRevokeAccessToken(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+		return
+	}
+
+	err = th.AccessTokenService.Revoke(username, id, c.ClientIP())
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+			return
+		}
+		th.Logger.Error("Error revoking access token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type AdminHandler struct {
+	Authentication *middleware.AuthenticationMiddleware
+	AdminService   *services.AdminService
+	Logger         *slog.Logger
+}
+
+// HandleGetUsers lists accounts matching ?q= by username, name or email, with ?limit= and ?offset=
+func (ah *AdminHandler) HandleGetUsers(c *gin.Context) {
+	admin, _ := ah.Authentication.ExtractUserFromContext(c)
+
+	page, ok := bindAdminPage(c)
+	if !ok {
+		return
+	}
+
+	users, err := ah.AdminService.Users(admin, c.ClientIP(), page)
+
+	if err != nil {
+		ah.Logger.Error("Error listing users", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (ah *AdminHandler) HandleSuspendUser(c *gin.Context) {
+	admin, _ := ah.Authentication.ExtractUserFromContext(c)
+
+	var req models.AdminSuspendRequest
+	if err := c.BindJSON(&req); err != nil || req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required"})
+		return
+	}
+
+	err := ah.AdminService.Suspend(admin, c.Param("username"), req.Reason, c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		case services.ErrSuspendSelf:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			ah.Logger.Error("Error suspending user", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "user suspended"})
+}
+
+func (ah *AdminHandler) HandleUnsuspendUser(c *gin.Context) {
+	admin, _ := ah.Authentication.ExtractUserFromContext(c)
+
+	err := ah.AdminService.Unsuspend(admin, c.Param("username"), c.ClientIP())
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		ah.Logger.Error("Error lifting suspension", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "suspension lifted"})
+}
+
+func (ah *AdminHandler) HandleForcePasswordReset(c *gin.Context) {
+	admin, _ := ah.Authentication.ExtractUserFromContext(c)
+
+	err := ah.AdminService.ForcePasswordReset(admin, c.Param("username"), c.ClientIP())
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		ah.Logger.Error("Error forcing password reset", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password cleared, access tokens revoked and SSH keys removed, a reset link was mailed to the user"})
+}
+
+// HandleGetRepos lists every repository matching ?q= against owner/name with its size, with ?limit= and ?offset=
+func (ah *AdminHandler) HandleGetRepos(c *gin.Context) {
+	admin, _ := ah.Authentication.ExtractUserFromContext(c)
+
+	page, ok := bindAdminPage(c)
+	if !ok {
+		return
+	}
+
+	repos, err := ah.AdminService.Repos(admin, c.ClientIP(), page)
+
+	if err != nil {
+		ah.Logger.Error("Error listing repositories", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, repos)
+}
+
+func (ah *AdminHandler) HandleDeleteRepo(c *gin.Context) {
+	admin, _ := ah.Authentication.ExtractUserFromContext(c)
+
+	var req models.AdminDeleteRepoRequest
+	if err := c.BindJSON(&req); err != nil || req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required"})
+		return
+	}
+
+	err := ah.AdminService.DeleteRepo(admin, c.Param("owner"), c.Param("reponame"), req.Reason, c.ClientIP())
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+			return
+		}
+		ah.Logger.Error("Error deleting repository", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "repository deleted"})
+}
+
+func bindAdminPage(c *gin.Context) (services.AdminPage, bool) {
+	page := services.AdminPage{Query: c.Query("q")}
+	var err error
+
+	if limit := c.Query("limit"); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+			return page, false
+		}
+	}
+
+	if offset := c.Query("offset"); offset != "" {
+		if page.Offset, err = strconv.Atoi(offset); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a number"})
+			return page, false
+		}
+	}
+
+	return page, true
+}
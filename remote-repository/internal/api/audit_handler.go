@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type AuditHandler struct {
+	Authentication *middleware.AuthenticationMiddleware
+	AuditService   *services.AuditService
+	Logger         *slog.Logger
+}
+
+// HandleGetUserAudit lists what the current user did and what was done to their account
+func (ah *AuditHandler) HandleGetUserAudit(c *gin.Context) {
+	username, err := ah.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	filter, ok := bindAuditFilter(c)
+	if !ok {
+		return
+	}
+	filter.User = username
+
+	ah.respond(c, filter)
+}
+
+// HandleGetRepoAudit lists the events of a repository for its owner
+func (ah *AuditHandler) HandleGetRepoAudit(c *gin.Context) {
+	filter, ok := bindAuditFilter(c)
+	if !ok {
+		return
+	}
+	filter.RepoOwner = c.GetString("REPOOWNER")
+	filter.RepoName = c.GetString("REPONAME")
+
+	ah.respond(c, filter)
+}
+
+// HandleGetAllAudit lists every event for admins, optionally by ?actor= and ?repo=owner/name
+func (ah *AuditHandler) HandleGetAllAudit(c *gin.Context) {
+	filter, ok := bindAuditFilter(c)
+	if !ok {
+		return
+	}
+	filter.Actor = c.Query("actor")
+
+	if repo := c.Query("repo"); repo != "" {
+		owner, name, found := strings.Cut(repo, "/")
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "repo must be owner/name"})
+			return
+		}
+		filter.RepoOwner, filter.RepoName = owner, name
+	}
+
+	event := auditEvent(c, ah.Authentication, services.AuditAdminAuditRead)
+	event.Details = map[string]string{"query": c.Request.URL.RawQuery}
+	ah.AuditService.Record(event)
+
+	ah.respond(c, filter)
+}
+
+// HandleVerifyAudit recomputes the hash chain and reports the first entry that was tampered with
+func (ah *AuditHandler) HandleVerifyAudit(c *gin.Context) {
+	result, err := ah.AuditService.Verify()
+
+	if err != nil {
+		ah.Logger.Error("Error verifying audit log", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !result.Valid {
+		ah.Logger.Warn("SECURITY: audit log hash chain is broken", "entry", result.BrokenAt)
+	}
+
+	event := auditEvent(c, ah.Authentication, services.AuditAdminAuditRead)
+	event.Details = map[string]string{"verify": strconv.FormatBool(result.Valid)}
+	ah.AuditService.Record(event)
+
+	c.JSON(http.StatusOK, result)
+}
+
+// respond sends one page as JSON, or with ?format=jsonl every matching event as JSON lines
+func (ah *AuditHandler) respond(c *gin.Context, filter database.AuditFilter) {
+	if c.Query("format") == "jsonl" {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		err := ah.AuditService.Export(filter, func(event database.AuditEvent) error {
+			return encoder.Encode(event)
+		})
+
+		// Headers are already sent, a failure here can only cut the export short
+		if err != nil {
+			ah.Logger.Error("Error exporting audit log", "error", err)
+		}
+		return
+	}
+
+	events, err := ah.AuditService.Events(filter)
+
+	if err != nil {
+		ah.Logger.Error("Error listing audit events", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// bindAuditFilter reads ?action=, ?since= and ?until= as RFC 3339 times, ?before= as an event id and ?limit=
+func bindAuditFilter(c *gin.Context) (database.AuditFilter, bool) {
+	filter := database.AuditFilter{Action: c.Query("action")}
+	var err error
+
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 time"})
+			return filter, false
+		}
+	}
+
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be an RFC 3339 time"})
+			return filter, false
+		}
+	}
+
+	if before := c.Query("before"); before != "" {
+		if filter.BeforeID, err = strconv.ParseInt(before, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before must be an event id"})
+			return filter, false
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+			return filter, false
+		}
+	}
+
+	return filter, true
+}
+
+// auditEvent starts an event done by the current user from the client's address, on the repository in context if any
+func auditEvent(c *gin.Context, authentication *middleware.AuthenticationMiddleware, action string) database.AuditEvent {
+	actor, _ := authentication.ExtractUserFromContext(c)
+
+	return database.AuditEvent{
+		Action:    action,
+		Actor:     actor,
+		RepoOwner: c.GetString("REPOOWNER"),
+		RepoName:  c.GetString("REPONAME"),
+		IPAddress: c.ClientIP(),
+	}
+}
@@ -2,19 +2,23 @@ package api
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
 )
 
 type AuthHandler struct {
-	AuthenticatonService services.AuthService
+	AuthenticatonService *services.AuthService
 	Logger               *slog.Logger
 }
 
@@ -34,7 +38,7 @@ func (ah *AuthHandler) HandleRegister(c *gin.Context) {
 		ah.Logger.Error("Invalid Request Body")
 		return
 	}
-	tokenRes, err := ah.AuthenticatonService.Register(req.Username, req.Password, req.FullName, req.EmailAddress)
+	tokenRes, err := ah.AuthenticatonService.Register(req.Username, req.Password, req.FullName, req.EmailAddress, deviceOf(c))
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -61,30 +65,89 @@ func (ah *AuthHandler) HandleLogin(c *gin.Context) {
 		return
 	}
 
-	tokenRes, err := ah.AuthenticatonService.Login(req.Username, req.Password)
+	tokenRes, challenge, err := ah.AuthenticatonService.Login(req.Username, req.Password, deviceOf(c))
 
 	if err != nil {
-		if err == services.ErrIncorrectPassword {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		} else {
+		var locked *services.LoginLockedError
+		switch {
+		case err == services.ErrInvalidCredentials:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case err == middleware.ErrAccountSuspended:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.As(err, &locked):
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to Login"})
+			ah.Logger.Error(fmt.Sprintf("Error Login User, %v", err))
+		}
+		return
+	}
+
+	if challenge != nil {
+		c.JSON(http.StatusAccepted, challenge)
+		return
+	}
+
+	c.JSON(http.StatusCreated, tokenRes)
+}
+
+func (ah *AuthHandler) HandleLoginTwoFactor(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, "Invalid JSON Format")
+		return
+	}
+
+	if req.ChallengeToken == "" || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing Challenge or Code"})
+		return
+	}
+
+	tokenRes, err := ah.AuthenticatonService.CompleteLogin(req.ChallengeToken, req.Code, deviceOf(c))
+
+	if err != nil {
+		var locked *services.LoginLockedError
+		switch {
+		case err == services.ErrInvalidChallenge:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "login challenge expired. Please log in again"})
+		case err == services.ErrInvalidTwoFactorCode:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case err == middleware.ErrAccountSuspended:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.As(err, &locked):
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			ah.Logger.Error(fmt.Sprintf("Error Completing Two Factor Login, %v", err))
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to Login"})
 		}
-		ah.Logger.Error(fmt.Sprintf("Error Login User, %v", err))
 		return
 	}
 
 	c.JSON(http.StatusCreated, tokenRes)
 }
 
+// HandleLogout signs the current session out, other devices stay signed in
 func (ah *AuthHandler) HandleLogout(c *gin.Context) {
 	username, err := ah.AuthenticatonService.Authentication.ExtractUserFromContext(c)
 
 	if err != nil {
 		ah.Logger.Error(fmt.Sprintf("Username does not exist in Context, %v", err))
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err})
+		return
+	}
+
+	session := ah.AuthenticatonService.Authentication.ExtractSessionFromContext(c)
+
+	if session == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "personal access tokens are revoked under /settings/tokens"})
+		return
 	}
 
-	err = ah.AuthenticatonService.TokenStore.RevokeAllTokens(username)
+	tokenID, expires := ah.AuthenticatonService.Authentication.ExtractAccessTokenFromContext(c)
+	err = ah.AuthenticatonService.Logout(username, session, tokenID, expires, c.ClientIP())
 
 	if err == sql.ErrNoRows {
 		c.JSON(http.StatusNotFound, gin.H{"message": "you are already logged out"})
@@ -109,37 +172,85 @@ func (ah *AuthHandler) HandleRefresh(c *gin.Context) {
 		return
 	}
 
-	token, err := ah.AuthenticatonService.TokenStore.GetRefreshToken(req.RefreshToken)
+	newTokenPair, err := ah.AuthenticatonService.Refresh(req.RefreshToken, deviceOf(c))
 
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token does not exist. Please log in again"})
+		switch err {
+		case services.ErrInvalidToken:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token does not exist. Please log in again"})
+		case services.ErrRevokedToken, services.ErrRefreshTokenReused:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token revoked. Please log in again"})
+		case services.ErrExpiredToken:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token expired. Please log in again"})
+		case services.ErrSessionExpired:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case middleware.ErrAccountSuspended:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			ah.Logger.Error(fmt.Sprintf("Error Refreshing Token, %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
 		return
 	}
 
-	if token.Revoked {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token revoked. Please log in again"})
+	c.JSON(http.StatusAccepted, gin.H{
+		"refresh":       "ok",
+		"access_token":  newTokenPair.AccessToken,
+		"refresh_token": newTokenPair.RefreshToken,
+	})
+}
+
+// HandleChangePassword needs the current password, every session but this one is signed out and personal access tokens are revoked
+func (ah *AuthHandler) HandleChangePassword(c *gin.Context) {
+	username, err := ah.AuthenticatonService.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
 		return
 	}
 
-	expired := token.CreatedAt.Add(ah.AuthenticatonService.Authentication.MaxRefresh).After(time.Now())
-	if expired {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token expired. Please log in again"})
+	var req models.ChangePasswordRequest
+	if err = c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
 		return
 	}
 
-	// User
-	username, err := ah.AuthenticatonService.UserStore.GetUserByToken(token.Token)
-
-	newTokenPair, err := ah.AuthenticatonService.GenerateAccessTokens(username)
+	session := ah.AuthenticatonService.Authentication.ExtractSessionFromContext(c)
+	err = ah.AuthenticatonService.ChangePassword(username, req.CurrentPassword, req.NewPassword, session, c.ClientIP())
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		var locked *services.LoginLockedError
+		switch {
+		case err == services.ErrIncorrectPassword:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case err == services.ErrInvalidPassword, err == services.ErrNoPasswordSet:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.As(err, &locked):
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			ah.Logger.Error("Error changing password", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"refresh":       "ok",
-		"access_token":  newTokenPair.AccessToken,
-		"refresh_token": newTokenPair.RefreshToken,
-	})
+	c.JSON(http.StatusOK, gin.H{"message": "password changed, other sessions were signed out and personal access tokens revoked"})
+}
+
+// HandleJWKS publishes the public keys access tokens are verified with so other services can check them offline
+func (ah *AuthHandler) HandleJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ah.AuthenticatonService.Authentication.Keys.JWKS())
+}
+
+// Longest user agent kept with a session
+const maxUserAgentLength = 255
+
+func deviceOf(c *gin.Context) database.Device {
+	userAgent := strings.ToValidUTF8(c.Request.UserAgent(), "")
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+
+	return database.Device{UserAgent: userAgent, IPAddress: c.ClientIP()}
 }
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type EmailHandler struct {
+	Authentication *middleware.AuthenticationMiddleware
+	EmailService   *services.EmailService
+	Logger         *slog.Logger
+}
+
+func (eh *EmailHandler) HandleVerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no token was specified in request"})
+		return
+	}
+
+	err := eh.EmailService.VerifyEmail(token)
+
+	if err != nil {
+		if err == services.ErrInvalidEmailToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		eh.Logger.Error("Error verifying email", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email address verified"})
+}
+
+func (eh *EmailHandler) HandleResendVerification(c *gin.Context) {
+	username, err := eh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	err = eh.EmailService.SendVerification(username)
+
+	if err != nil {
+		if err == services.ErrEmailAlreadyVerified {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		eh.Logger.Error("Error sending verification email", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+}
+
+func (eh *EmailHandler) HandleForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.BindJSON(&req); err != nil || req.EmailAddress == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no email was specified in request"})
+		return
+	}
+
+	if err := eh.EmailService.ForgotPassword(req.EmailAddress); err != nil {
+		eh.Logger.Error("Error sending password reset email", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "if an account uses this address, a reset token was sent to it"})
+}
+
+func (eh *EmailHandler) HandleResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.BindJSON(&req); err != nil || req.Token == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token and password are required"})
+		return
+	}
+
+	err := eh.EmailService.ResetPassword(req.Token, req.Password)
+
+	if err != nil {
+		switch err {
+		case services.ErrInvalidPassword, services.ErrInvalidEmailToken:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			eh.Logger.Error("Error resetting password", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password changed, please log in again"})
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type InvitationHandler struct {
+	Authentication    *middleware.AuthenticationMiddleware
+	InvitationService *services.InvitationService
+	Logger            *slog.Logger
+}
+
+// HandleGetRepoInvitations lists the pending invitations to the repository
+func (ih *InvitationHandler) HandleGetRepoInvitations(c *gin.Context) {
+	invitations, err := ih.InvitationService.RepoInvitations(c.GetString("REPOOWNER"), c.GetString("REPONAME"))
+
+	if err != nil {
+		ih.Logger.Error("Error listing repository invitations", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+func (ih *InvitationHandler) HandleCancelInvitation(c *gin.Context) {
+	actor, _ := ih.Authentication.ExtractUserFromContext(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invitation id"})
+		return
+	}
+
+	err = ih.InvitationService.Cancel(actor, c.GetString("REPOOWNER"), c.GetString("REPONAME"), id, c.ClientIP())
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+			return
+		}
+		ih.Logger.Error("Error cancelling invitation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invitation cancelled"})
+}
+
+// HandleGetInvitations lists the invitations of the current user that did not expire
+func (ih *InvitationHandler) HandleGetInvitations(c *gin.Context) {
+	username, err := ih.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	invitations, err := ih.InvitationService.Invitations(username)
+
+	if err != nil {
+		ih.Logger.Error("Error listing invitations", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+func (ih *InvitationHandler) HandleAcceptInvitation(c *gin.Context) {
+	username, err := ih.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invitation id"})
+		return
+	}
+
+	invitation, err := ih.InvitationService.Accept(username, id, c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found or expired"})
+		case services.ErrTwoFactorRequired:
+			c.JSON(http.StatusForbidden, gin.H{"error": "this repository requires two factor authentication, enable it under /settings/2fa first"})
+		default:
+			ih.Logger.Error("Error accepting invitation", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "invitation accepted",
+		"repository": invitation.RepoOwner + "/" + invitation.RepoName,
+		"role":       invitation.Role,
+	})
+}
+
+func (ih *InvitationHandler) HandleDeclineInvitation(c *gin.Context) {
+	username, err := ih.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invitation id"})
+		return
+	}
+
+	if err = ih.InvitationService.Decline(username, id, c.ClientIP()); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found or expired"})
+			return
+		}
+		ih.Logger.Error("Error declining invitation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invitation declined"})
+}
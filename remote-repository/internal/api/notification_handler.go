@@ -0,0 +1,87 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type NotificationHandler struct {
+	Authentication      *middleware.AuthenticationMiddleware
+	NotificationService *services.NotificationService
+	Logger              *slog.Logger
+}
+
+// HandleGetNotifications lists the latest notifications, only unread ones with ?unread=true, up to ?limit=
+func (nh *NotificationHandler) HandleGetNotifications(c *gin.Context) {
+	username, err := nh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	var limit int
+	if l := c.Query("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+			return
+		}
+	}
+
+	notifications, err := nh.NotificationService.Notifications(username, c.Query("unread") == "true", limit)
+
+	if err != nil {
+		nh.Logger.Error("Error listing notifications", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+func (nh *NotificationHandler) HandleMarkNotificationRead(c *gin.Context) {
+	username, err := nh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification id"})
+		return
+	}
+
+	if err = nh.NotificationService.MarkRead(username, id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+			return
+		}
+		nh.Logger.Error("Error marking notification read", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "notification marked read"})
+}
+
+func (nh *NotificationHandler) HandleMarkAllNotificationsRead(c *gin.Context) {
+	username, err := nh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	if err = nh.NotificationService.MarkAllRead(username); err != nil {
+		nh.Logger.Error("Error marking notifications read", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "every notification marked read"})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type OAuthHandler struct {
+	OAuthService *services.OAuthService
+	Logger       *slog.Logger
+}
+
+// Ties the state to the browser that began the flow, scoped to the OAuth routes
+const (
+	oauthStateCookie     = "jit_oauth_state"
+	oauthStateCookiePath = "/auth/oauth"
+	oauthStateCookieAge  = 10 * 60
+)
+
+func (oh *OAuthHandler) HandleOAuthBegin(c *gin.Context) {
+	authURL, binding, err := oh.OAuthService.Begin(c.Param("provider"))
+
+	if err != nil {
+		if err == services.ErrUnknownProvider {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		oh.Logger.Error(fmt.Sprintf("Error starting OAuth flow, %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	oh.setStateCookie(c, binding, oauthStateCookieAge)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (oh *OAuthHandler) HandleOAuthCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider denied the login: " + providerErr})
+		return
+	}
+
+	binding, _ := c.Cookie(oauthStateCookie)
+	oh.setStateCookie(c, "", -1)
+
+	result, err := oh.OAuthService.Complete(c.Param("provider"), c.Query("state"), binding, c.Query("code"), deviceOf(c))
+
+	if err != nil {
+		switch {
+		case err == services.ErrUnknownProvider:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case err == services.ErrInvalidOAuthState, err == services.ErrUnverifiedEmail:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err == services.ErrEmailAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{"error": "an unverified account uses this email address"})
+		case err == middleware.ErrAccountSuspended:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOAuthExchange):
+			oh.Logger.Error(fmt.Sprintf("Error completing OAuth flow, %v", err))
+			c.JSON(http.StatusBadGateway, gin.H{"error": services.ErrOAuthExchange.Error()})
+		default:
+			oh.Logger.Error(fmt.Sprintf("Error completing OAuth flow, %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	if result.Tokens == nil {
+		c.JSON(http.StatusAccepted, result)
+		return
+	}
+
+	c.JSON(http.StatusCreated, result.Tokens)
+}
+
+func (oh *OAuthHandler) HandleOAuthSignup(c *gin.Context) {
+	var req models.OAuthSignupRequest
+
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, "Invalid JSON Format")
+		return
+	}
+
+	tokens, err := oh.OAuthService.Signup(req.SignupToken, req.Username, deviceOf(c))
+
+	if err != nil {
+		switch err {
+		case services.ErrInvalidSignupToken:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case services.ErrInvalidUsername, services.ErrInvalidEmailAddress:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case services.ErrUserAlreadyExists, services.ErrEmailAlreadyExists:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			oh.Logger.Error(fmt.Sprintf("Error Registering OAuth User, %v", err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, tokens)
+}
+
+// setStateCookie is HttpOnly so scripts cannot read the binding, and Lax so it survives the redirect back from the provider
+func (oh *OAuthHandler) setStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := false
+	if provider, ok := oh.OAuthService.Providers[c.Param("provider")]; ok {
+		secure = strings.HasPrefix(provider.RedirectURL, "https://")
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oauthStateCookie, value, maxAge, oauthStateCookiePath, "", secure, true)
+}
@@ -0,0 +1,623 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/cache"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/signing"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+// Account signed in at the stand-in provider, what its userinfo endpoints return
+type providerAccount struct {
+	Subject       string
+	ID            int64
+	Login         string
+	Email         string
+	EmailVerified bool
+}
+
+// standInProvider is a local OAuth provider with Google style userinfo and GitHub style emails,
+// it checks the client, the redirect URI and the PKCE verifier like the real ones do
+type standInProvider struct {
+	*httptest.Server
+	Account providerAccount
+
+	mu     sync.Mutex
+	codes  map[string]issuedCode
+	tokens map[string]providerAccount
+}
+
+type issuedCode struct {
+	challenge   string
+	redirectURI string
+}
+
+const (
+	standInClientID     = "jit-client"
+	standInClientSecret = "jit-secret"
+)
+
+func newStandInProvider(t *testing.T) *standInProvider {
+	p := &standInProvider{codes: map[string]issuedCode{}, tokens: map[string]providerAccount{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /userinfo", p.userinfo)
+	mux.HandleFunc("GET /emails", p.emails)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize signs Account in at once and sends the browser back with a code bound to the PKCE challenge
+func (p *standInProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != standInClientID || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := "code-" + query.Get("state")
+	p.mu.Lock()
+	p.codes[code] = issuedCode{challenge: query.Get("code_challenge"), redirectURI: query.Get("redirect_uri")}
+	p.mu.Unlock()
+
+	back := url.Values{}
+	back.Set("code", code)
+	back.Set("state", query.Get("state"))
+	http.Redirect(w, r, query.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (p *standInProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	issued, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code",
+		r.PostForm.Get("client_id") != standInClientID,
+		r.PostForm.Get("client_secret") != standInClientSecret:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	case !ok,
+		issued.redirectURI != r.PostForm.Get("redirect_uri"),
+		issued.challenge != base64.RawURLEncoding.EncodeToString(verifier[:]):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	accessToken := "access-" + r.PostForm.Get("code")
+	p.mu.Lock()
+	p.tokens[accessToken] = p.Account
+	p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": accessToken, "token_type": "bearer"})
+}
+
+func (p *standInProvider) userinfo(w http.ResponseWriter, r *http.Request) {
+	account, ok := p.bearer(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            account.Subject,
+		"id":             account.ID,
+		"login":          account.Login,
+		"email":          account.Email,
+		"email_verified": account.EmailVerified,
+	})
+}
+
+func (p *standInProvider) emails(w http.ResponseWriter, r *http.Request) {
+	account, ok := p.bearer(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, []map[string]any{{"email": account.Email, "primary": true, "verified": account.EmailVerified}})
+}
+
+func (p *standInProvider) bearer(r *http.Request) (providerAccount, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	account, ok := p.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	return account, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Users and linked identities kept in memory
+type memoryUserStore struct {
+	database.UserStore
+
+	mu         sync.Mutex
+	users      map[string]database.User
+	identities map[string]string
+}
+
+func (s *memoryUserStore) CreateUser(user *database.User) (*database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.Username] = *user
+	return user, nil
+}
+
+func (s *memoryUserStore) GetUserbyUsername(username string) (*database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &user, nil
+}
+
+func (s *memoryUserStore) GetUserbyEmailAddress(email string) (*database.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.EmailAddress == email {
+			return &user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *memoryUserStore) GetUsernameByIdentity(provider, subject string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	username, ok := s.identities[provider+"/"+subject]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return username, nil
+}
+
+func (s *memoryUserStore) LinkIdentity(provider, subject, username, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities[provider+"/"+subject] = username
+	return nil
+}
+
+type memoryTokenStore struct {
+	database.TokenStore
+	stored []string
+}
+
+func (s *memoryTokenStore) StoreRefreshToken(username, token, family string, device database.Device) error {
+	s.stored = append(s.stored, username)
+	return nil
+}
+
+type disabledTwoFactorStore struct {
+	database.TwoFactorStore
+}
+
+func (disabledTwoFactorStore) GetTwoFactor(username string) (*database.TwoFactor, error) {
+	return &database.TwoFactor{}, nil
+}
+
+type discardAuditStore struct {
+	database.AuditStore
+}
+
+func (discardAuditStore) AppendAuditEvent(event *database.AuditEvent) error {
+	return nil
+}
+
+type oauthFixture struct {
+	provider *standInProvider
+	users    *memoryUserStore
+	tokens   *memoryTokenStore
+	router   *gin.Engine
+	// State cookie of the browser, set by begin and sent by callback
+	cookie *http.Cookie
+}
+
+// newOAuthFixture serves the OAuth routes against a stand-in provider registered as google,
+// and as github with an emails endpoint
+func newOAuthFixture(t *testing.T) *oauthFixture {
+	gin.SetMode(gin.TestMode)
+
+	keyDir := t.TempDir()
+	if _, err := signing.Generate(keyDir); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := signing.Load(keyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	users := &memoryUserStore{users: map[string]database.User{}, identities: map[string]string{}}
+	tokens := &memoryTokenStore{}
+
+	authentication := &middleware.AuthenticationMiddleware{
+		UserStore:   users,
+		TokenStore:  tokens,
+		Keys:        keys,
+		Issuer:      "http://jit.test",
+		Timeout:     15 * time.Minute,
+		MaxRefresh:  24 * 7 * time.Hour,
+		MaxSession:  24 * 30 * time.Hour,
+		Logger:      logger,
+		IdentityKey: "username",
+	}
+	twoFactor := &services.TwoFactorService{TwoFactorStore: disabledTwoFactorStore{}}
+	audit := &services.AuditService{AuditStore: discardAuditStore{}, Logger: logger}
+	auth := services.NewAuthService(users, tokens, twoFactor, nil, audit, cache.NewMemoryCache(), authentication)
+
+	provider := newStandInProvider(t)
+	oauth := services.NewOAuthService(users, auth, cache.NewMemoryCache(), []byte("oauth-state-key"))
+	oauth.Providers = map[string]*services.OAuthProvider{}
+	for _, name := range []string{"google", "github"} {
+		oauth.Providers[name] = &services.OAuthProvider{
+			Name:         name,
+			ClientID:     standInClientID,
+			ClientSecret: standInClientSecret,
+			AuthURL:      provider.URL + "/authorize",
+			TokenURL:     provider.URL + "/token",
+			UserInfoURL:  provider.URL + "/userinfo",
+			RedirectURL:  "http://jit.test/auth/oauth/" + name + "/callback",
+			Scopes:       []string{"email"},
+		}
+	}
+	oauth.Providers["github"].EmailsURL = provider.URL + "/emails"
+
+	handler := &OAuthHandler{OAuthService: oauth, Logger: logger}
+	router := gin.New()
+	router.GET("/auth/oauth/:provider", handler.HandleOAuthBegin)
+	router.GET("/auth/oauth/:provider/callback", handler.HandleOAuthCallback)
+	router.POST("/auth/oauth/signup", handler.HandleOAuthSignup)
+
+	return &oauthFixture{provider: provider, users: users, tokens: tokens, router: router}
+}
+
+func (f *oauthFixture) serve(method, target string, body []byte) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if f.cookie != nil {
+		req.AddCookie(f.cookie)
+	}
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// begin starts a login and returns the provider authorize URL it redirected to
+func (f *oauthFixture) begin(t *testing.T, provider string) *url.URL {
+	rec := f.serve(http.MethodGet, "/auth/oauth/"+provider, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("begin: status %d, %s", rec.Code, rec.Body)
+	}
+
+	f.cookie = nil
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oauthStateCookie {
+			f.cookie = cookie
+		}
+	}
+	if f.cookie == nil {
+		t.Fatal("begin set no state cookie")
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), f.provider.URL+"/authorize") {
+		t.Fatalf("begin redirected to %s", location)
+	}
+	return location
+}
+
+// authorize signs in at the provider and returns the callback URL it sends the browser back to
+func (f *oauthFixture) authorize(t *testing.T, authorizeURL *url.URL) *url.URL {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	res, err := client.Get(authorizeURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", res.StatusCode)
+	}
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback
+}
+
+func (f *oauthFixture) callback(callback *url.URL) *httptest.ResponseRecorder {
+	return f.serve(http.MethodGet, callback.RequestURI(), nil)
+}
+
+// login runs the whole flow for provider and returns the callback response
+func (f *oauthFixture) login(t *testing.T, provider string) *httptest.ResponseRecorder {
+	return f.callback(f.authorize(t, f.begin(t, provider)))
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	return v
+}
+
+func TestOAuthBeginSendsPKCEChallenge(t *testing.T) {
+	f := newOAuthFixture(t)
+
+	query := f.begin(t, "google").Query()
+
+	for _, param := range []string{"state", "code_challenge"} {
+		if query.Get(param) == "" {
+			t.Errorf("authorize URL has no %s", param)
+		}
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+	if query.Get("redirect_uri") != "http://jit.test/auth/oauth/google/callback" {
+		t.Errorf("redirect_uri = %q", query.Get("redirect_uri"))
+	}
+}
+
+func TestOAuthBeginUnknownProvider(t *testing.T) {
+	f := newOAuthFixture(t)
+
+	if rec := f.serve(http.MethodGet, "/auth/oauth/gitlab", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestOAuthCallbackStateMismatch(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.provider.Account = providerAccount{Subject: "g-1", Email: "ada@example.com", EmailVerified: true}
+
+	callback := f.authorize(t, f.begin(t, "google"))
+	query := callback.Query()
+	query.Set("state", "forged")
+	callback.RawQuery = query.Encode()
+
+	rec := f.callback(callback)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if len(f.tokens.stored) != 0 {
+		t.Fatal("a session was started for a forged state")
+	}
+}
+
+func TestOAuthStateCookieIsHttpOnly(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.begin(t, "google")
+
+	if !f.cookie.HttpOnly || f.cookie.SameSite != http.SameSiteLaxMode || f.cookie.Path != oauthStateCookiePath {
+		t.Fatalf("state cookie %+v, want HttpOnly and Lax on %s", f.cookie, oauthStateCookiePath)
+	}
+}
+
+func TestOAuthCallbackFromAnotherBrowser(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.users.users["ada"] = database.User{Username: "ada", EmailAddress: "ada@example.com", EmailVerified: true}
+	f.provider.Account = providerAccount{Subject: "g-1", Email: "ada@example.com", EmailVerified: true}
+
+	// The attacker begins a flow and gets the victim's browser to open its callback
+	callback := f.authorize(t, f.begin(t, "google"))
+	attackerCookie := f.cookie
+
+	f.cookie = nil
+	if rec := f.callback(callback); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback without cookie: status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+
+	f.begin(t, "google")
+	if rec := f.callback(callback); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback with the cookie of another flow: status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if len(f.tokens.stored) != 0 {
+		t.Fatal("a session was started for a browser that did not begin the flow")
+	}
+
+	// A refused callback does not use the state up
+	f.cookie = attackerCookie
+	if rec := f.callback(callback); rec.Code != http.StatusCreated {
+		t.Fatalf("callback with its own cookie: status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+}
+
+func TestOAuthCallbackStateIsSingleUse(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.provider.Account = providerAccount{Subject: "g-1", Email: "ada@example.com", EmailVerified: true}
+
+	callback := f.authorize(t, f.begin(t, "google"))
+	if rec := f.callback(callback); rec.Code != http.StatusAccepted {
+		t.Fatalf("first callback: status %d, %s", rec.Code, rec.Body)
+	}
+
+	if rec := f.callback(callback); rec.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOAuthCallbackStateOfOtherProvider(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.provider.Account = providerAccount{Subject: "g-1", ID: 1, Email: "ada@example.com", EmailVerified: true}
+
+	callback := f.authorize(t, f.begin(t, "google"))
+	callback.Path = "/auth/oauth/github/callback"
+
+	if rec := f.callback(callback); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+}
+
+func TestOAuthCallbackPKCEVerifierMismatch(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.provider.Account = providerAccount{Subject: "g-1", Email: "ada@example.com", EmailVerified: true}
+
+	// A code issued to one login is injected into another, its verifier does not match the challenge
+	victim := f.authorize(t, f.begin(t, "google"))
+	attacker := f.authorize(t, f.begin(t, "google"))
+
+	query := attacker.Query()
+	query.Set("code", victim.Query().Get("code"))
+	attacker.RawQuery = query.Encode()
+
+	rec := f.callback(attacker)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusBadGateway, rec.Body)
+	}
+	if len(f.tokens.stored) != 0 {
+		t.Fatal("a session was started with a mismatched verifier")
+	}
+}
+
+func TestOAuthLinksAccountByVerifiedEmail(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.users.users["ada"] = database.User{Username: "ada", EmailAddress: "ada@example.com", EmailVerified: true}
+	f.provider.Account = providerAccount{Subject: "g-1", Email: "ada@example.com", EmailVerified: true}
+
+	rec := f.login(t, "google")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	tokens := decode[map[string]string](t, rec)
+	if tokens["access_token"] == "" || tokens["refresh_token"] == "" {
+		t.Fatalf("no tokens in %s", rec.Body)
+	}
+	if username := f.users.identities["google/g-1"]; username != "ada" {
+		t.Fatalf("identity linked to %q, want ada", username)
+	}
+
+	// Signing in again goes through the linked identity, whatever email the provider reports now
+	f.provider.Account.Email = "ada@elsewhere.example"
+	if rec = f.login(t, "google"); rec.Code != http.StatusCreated {
+		t.Fatalf("second login: status %d, %s", rec.Code, rec.Body)
+	}
+	if len(f.tokens.stored) != 2 || f.tokens.stored[1] != "ada" {
+		t.Fatalf("sessions started for %v, want ada twice", f.tokens.stored)
+	}
+}
+
+func TestOAuthDoesNotLinkUnverifiedAccount(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.users.users["mallory"] = database.User{Username: "mallory", EmailAddress: "ada@example.com"}
+	f.provider.Account = providerAccount{Subject: "g-1", Email: "ada@example.com", EmailVerified: true}
+
+	if rec := f.login(t, "google"); rec.Code != http.StatusConflict {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if len(f.users.identities) != 0 {
+		t.Fatal("identity linked to an account that never verified the address")
+	}
+}
+
+func TestOAuthRefusesUnverifiedEmail(t *testing.T) {
+	for _, provider := range []string{"google", "github"} {
+		t.Run(provider, func(t *testing.T) {
+			f := newOAuthFixture(t)
+			f.users.users["ada"] = database.User{Username: "ada", EmailAddress: "ada@example.com", EmailVerified: true}
+			f.provider.Account = providerAccount{Subject: "g-1", ID: 1, Login: "ada", Email: "ada@example.com"}
+
+			rec := f.login(t, provider)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			if len(f.users.identities) != 0 || len(f.tokens.stored) != 0 {
+				t.Fatal("an unverified email was linked or signed in")
+			}
+		})
+	}
+}
+
+func TestOAuthSignupWithChosenUsername(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.provider.Account = providerAccount{ID: 42, Login: "Grace", Email: "grace@example.com", EmailVerified: true}
+
+	rec := f.login(t, "github")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+
+	result := decode[services.OAuthResult](t, rec)
+	if result.SignupToken == "" || result.Email != "grace@example.com" || result.SuggestedUsername != "grace" {
+		t.Fatalf("unexpected signup result %+v", result)
+	}
+
+	signup := func(token, username string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"signup_token": token, "username": username})
+		return f.serve(http.MethodPost, "/auth/oauth/signup", body)
+	}
+
+	if rec = signup("not-a-token", "hopper"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown signup token: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	f.users.users["taken"] = database.User{Username: "taken", EmailAddress: "taken@example.com", EmailVerified: true}
+	if rec = signup(result.SignupToken, "taken"); rec.Code != http.StatusConflict {
+		t.Fatalf("taken username: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec = signup(result.SignupToken, "settings"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reserved username: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	if rec = signup(result.SignupToken, "hopper"); rec.Code != http.StatusCreated {
+		t.Fatalf("signup: status %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	user, ok := f.users.users["hopper"]
+	if !ok {
+		t.Fatal("account hopper was not created")
+	}
+	if user.EmailAddress != "grace@example.com" || !user.EmailVerified || user.PasswordHash != "" {
+		t.Fatalf("unexpected account %+v", user)
+	}
+	if username := f.users.identities["github/42"]; username != "hopper" {
+		t.Fatalf("identity linked to %q, want hopper", username)
+	}
+
+	if rec = signup(result.SignupToken, "hopper2"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused signup token: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type OrgHandler struct {
+	Authentication *middleware.AuthenticationMiddleware
+	OrgService     *services.OrgService
+	Logger         *slog.Logger
+}
+
+// HandleCreateOrg creates an organization with the current user as its owner
+func (oh *OrgHandler) HandleCreateOrg(c *gin.Context) {
+	username, err := oh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	var req models.CreateOrgRequest
+	if err = c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	if err = oh.OrgService.Create(username, req.Name, req.FullName, req.Bio, c.ClientIP()); err != nil {
+		oh.fail(c, err, "Error creating organization")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "organization created", "name": req.Name})
+}
+
+func (oh *OrgHandler) HandleGetMembers(c *gin.Context) {
+	members, err := oh.OrgService.Members(oh.Authentication.ExtractOrgFromContext(c))
+
+	if err != nil {
+		oh.fail(c, err, "Error listing organization members")
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// HandleSetMember adds :username to the organization or changes their role
+func (oh *OrgHandler) HandleSetMember(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	var req models.OrgMemberRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	err := oh.OrgService.SetMember(actor, oh.Authentication.ExtractOrgFromContext(c), c.Param("username"), database.OrgRole(req.Role), c.ClientIP())
+
+	if err != nil {
+		oh.fail(c, err, "Error setting organization member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member updated"})
+}
+
+// HandleRemoveMember takes :username out of the organization and its teams
+func (oh *OrgHandler) HandleRemoveMember(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	err := oh.OrgService.RemoveMember(actor, oh.Authentication.ExtractOrgFromContext(c), c.Param("username"), c.ClientIP())
+
+	if err != nil {
+		oh.fail(c, err, "Error removing organization member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member removed"})
+}
+
+func (oh *OrgHandler) HandleGetTeams(c *gin.Context) {
+	teams, err := oh.OrgService.Teams(oh.Authentication.ExtractOrgFromContext(c))
+
+	if err != nil {
+		oh.fail(c, err, "Error listing teams")
+		return
+	}
+
+	c.JSON(http.StatusOK, teams)
+}
+
+func (oh *OrgHandler) HandleCreateTeam(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	var req models.CreateTeamRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	team := &database.Team{Org: oh.Authentication.ExtractOrgFromContext(c), Name: req.Name, Description: req.Description}
+
+	if err := oh.OrgService.CreateTeam(actor, team, c.ClientIP()); err != nil {
+		oh.fail(c, err, "Error creating team")
+		return
+	}
+
+	c.JSON(http.StatusCreated, team)
+}
+
+// HandleDeleteTeam removes the team and the roles it granted its members
+func (oh *OrgHandler) HandleDeleteTeam(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	err := oh.OrgService.DeleteTeam(actor, oh.Authentication.ExtractOrgFromContext(c), c.Param("team"), c.ClientIP())
+
+	if err != nil {
+		oh.fail(c, err, "Error deleting team")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "team deleted"})
+}
+
+func (oh *OrgHandler) HandleGetTeamMembers(c *gin.Context) {
+	members, err := oh.OrgService.TeamMembers(oh.Authentication.ExtractOrgFromContext(c), c.Param("team"))
+
+	if err != nil {
+		oh.fail(c, err, "Error listing team members")
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+func (oh *OrgHandler) HandleAddTeamMember(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	err := oh.OrgService.AddTeamMember(actor, oh.Authentication.ExtractOrgFromContext(c), c.Param("team"), c.Param("username"), c.ClientIP())
+
+	if err != nil {
+		oh.fail(c, err, "Error adding team member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member added to team"})
+}
+
+func (oh *OrgHandler) HandleRemoveTeamMember(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	err := oh.OrgService.RemoveTeamMember(actor, oh.Authentication.ExtractOrgFromContext(c), c.Param("team"), c.Param("username"), c.ClientIP())
+
+	if err != nil {
+		oh.fail(c, err, "Error removing team member")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member removed from team"})
+}
+
+func (oh *OrgHandler) HandleGetTeamRepos(c *gin.Context) {
+	repos, err := oh.OrgService.TeamRepos(oh.Authentication.ExtractOrgFromContext(c), c.Param("team"))
+
+	if err != nil {
+		oh.fail(c, err, "Error listing team repositories")
+		return
+	}
+
+	c.JSON(http.StatusOK, repos)
+}
+
+// HandleSetTeamRepo grants the team a role on :reponame of the organization, or changes it
+func (oh *OrgHandler) HandleSetTeamRepo(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	var req models.TeamRepoRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	err := oh.OrgService.SetTeamRepo(actor, oh.Authentication.ExtractOrgFromContext(c), c.Param("team"), c.Param("reponame"),
+		database.Role(req.Role), c.ClientIP())
+
+	if err != nil {
+		oh.fail(c, err, "Error granting team repository")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "team role on repository set"})
+}
+
+func (oh *OrgHandler) HandleRemoveTeamRepo(c *gin.Context) {
+	actor, _ := oh.Authentication.ExtractUserFromContext(c)
+
+	err := oh.OrgService.RemoveTeamRepo(actor, oh.Authentication.ExtractOrgFromContext(c), c.Param("team"), c.Param("reponame"), c.ClientIP())
+
+	if err != nil {
+		oh.fail(c, err, "Error revoking team repository")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "team no longer has a role on the repository"})
+}
+
+// fail answers with the status matching an OrgService error
+func (oh *OrgHandler) fail(c *gin.Context, err error, message string) {
+	switch err {
+	case sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case services.ErrTeamOrRepoNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case services.ErrOrgNameAlreadyTaken, services.ErrTeamAlreadyExists, services.ErrLastOrgOwner:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case services.ErrInvalidOrgName, services.ErrInvalidTeamName, services.ErrInvalidOrgRole, services.ErrInvalidRepoRole,
+		services.ErrNotOrgMember, services.ErrOrgMemberNotUser:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		oh.Logger.Error(message, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
@@ -2,8 +2,12 @@ package api
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
@@ -13,32 +17,23 @@ import (
 
 type RepoHandler struct {
 	RepoStore   *database.PostgresRepoStore
+	OrgStore    database.OrgStore
 	Authorizer  *middleware.AuthenticationMiddleware
 	Logger      *slog.Logger
 	PushService *services.PushService
 	PullService *services.PullService
-}
-
-func (rh *RepoHandler) HandleGetRepo(c *gin.Context) {
-	privacy, ok := c.Get("PRIVACY")
-
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "privacy was not found in context"})
-		return
-	}
 
-	contributor, ok := c.Get("CONTRIBUTOR")
+	BlameService   *services.BlameService
+	ArchiveService *services.ArchiveService
+	CompareService *services.CompareService
+	SearchService  *services.SearchService
 
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "contributor state was not found in context"})
-		return
-	}
-
-	if privacy == "PRIVATE" && contributor == false {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you do not have access to this reposoitory"})
-		return
-	}
+	TwoFactorService  *services.TwoFactorService
+	AuditService      *services.AuditService
+	InvitationService *services.InvitationService
+}
 
+func (rh *RepoHandler) HandleGetRepo(c *gin.Context) {
 	repository := &database.Repository{}
 
 	c.JSON(http.StatusFound, repository)
@@ -53,6 +48,41 @@ func (rh *RepoHandler) HandleCreateRepo(c *gin.Context) {
 		return
 	}
 
+	currentUser, err := rh.Authorizer.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+	repo.RepoOwner = currentUser
+
+	// Owners of an organization create repositories under its name
+	if owner := c.Param("username"); owner != currentUser {
+		role, err := rh.OrgStore.GetOrgRole(owner, currentUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if role != database.OrgOwner {
+			c.JSON(http.StatusForbidden, gin.H{"error": "repositories can only be created for yourself or organizations you own"})
+			return
+		}
+		repo.RepoOwner = owner
+	}
+
+	if repo.Privacy == "PRIVATE" {
+		enabled, err := rh.TwoFactorService.IsEnabled(currentUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if !enabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "enable two factor authentication before creating private repositories"})
+			return
+		}
+	}
+
 	_, err = rh.RepoStore.CreateRepo(repo)
 
 	if err != nil {
@@ -60,6 +90,11 @@ func (rh *RepoHandler) HandleCreateRepo(c *gin.Context) {
 		return
 	}
 
+	event := auditEvent(c, rh.Authorizer, services.AuditRepoCreate)
+	event.RepoOwner, event.RepoName = repo.RepoOwner, repo.RepoName
+	event.Details = map[string]string{"privacy": repo.Privacy}
+	rh.AuditService.Record(event)
+
 	c.JSON(http.StatusCreated, gin.H{"message": "repository created succecssfully"})
 }
 
@@ -67,28 +102,57 @@ func (rh *RepoHandler) HandleAddRemoteRepo(c *gin.Context) {
 	repoOwner := c.GetString("REPOOWNER")
 	repoName := c.GetString("REPONAME")
 
-	contributor, ok := c.Get("CONTRIBUTOR")
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "contributor state was not found in context"})
+	secret, err := rh.RepoStore.GetRepoSecret(repoOwner, repoName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
+	rh.AuditService.Record(auditEvent(c, rh.Authorizer, services.AuditRepoSecretFetch))
+	c.JSON(http.StatusAccepted, gin.H{"secret": secret})
+}
 
-	if contributor == true {
-		secret, err := rh.RepoStore.GetRepoSecret(repoOwner, repoName)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+// HandleRotateRepoSecret replaces the secret remote requests are signed with, contributors fetch the new one from /remote
+func (rh *RepoHandler) HandleRotateRepoSecret(c *gin.Context) {
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	secret, err := rh.RepoStore.RotateRepoSecret(repoOwner, repoName)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "repository not found"})
 			return
 		}
-		c.JSON(http.StatusAccepted, gin.H{"secret": secret})
-	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you can not add this repository as remote"})
+		rh.Logger.Error("Error rotating repository secret", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
 	}
+
+	rh.AuditService.Record(auditEvent(c, rh.Authorizer, services.AuditRepoSecretRotate))
+
+	c.JSON(http.StatusOK, gin.H{"secret": secret})
 }
 
 type GrantOrRevokeRequest struct {
 	TargetUsername string `json:"target"`
+	// Only read when granting, one of read, triage, write, maintain or admin
+	Role database.Role `json:"role"`
 }
 
+// HandleGetCollaborators lists who has access and with which role, the owner first
+func (rh *RepoHandler) HandleGetCollaborators(c *gin.Context) {
+	collaborators, err := rh.RepoStore.GetAllContributors(c.GetString("REPOOWNER"), c.GetString("REPONAME"))
+
+	if err != nil {
+		rh.Logger.Error("Error listing collaborators", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, collaborators)
+}
+
+// HandleGrantAccessOnRepo changes the role of a collaborator at once, anyone else is invited
 func (rh *RepoHandler) HandleGrantAccessOnRepo(c *gin.Context) {
 	repoOwner := c.GetString("REPOOWNER")
 	repoName := c.GetString("REPONAME")
@@ -102,18 +166,40 @@ func (rh *RepoHandler) HandleGrantAccessOnRepo(c *gin.Context) {
 		return
 	}
 
-	err = rh.RepoStore.GrantAccessOnRepo(repoOwner, repoName, req.TargetUsername)
+	if !database.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be one of read, triage, write, maintain or admin"})
+		return
+	}
+
+	if req.TargetUsername == repoOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the owner always has every permission"})
+		return
+	}
+
+	actor, _ := rh.Authorizer.ExtractUserFromContext(c)
+	invitation, err := rh.InvitationService.Grant(actor, repoOwner, repoName, req.TargetUsername, req.Role, c.ClientIP())
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "this user already does not have access already"})
-			return
+		switch err {
+		case services.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "user " + req.TargetUsername + " does not exist"})
+		case services.ErrInviteeNotUser:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case services.ErrTwoFactorRequired:
+			c.JSON(http.StatusConflict, gin.H{"error": "this repository requires contributors to enable two factor authentication"})
+		default:
+			rh.Logger.Error("Error granting access", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{"message": "success granting access"})
+	if invitation == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "role of collaborator changed"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "invitation sent, the role applies once it is accepted", "invitation": invitation})
 }
 
 func (rh *RepoHandler) HandleRevokeAccessOnRepo(c *gin.Context) {
@@ -133,13 +219,17 @@ func (rh *RepoHandler) HandleRevokeAccessOnRepo(c *gin.Context) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "this user already does not have access already"})
+			c.JSON(http.StatusNotFound, gin.H{"error": req.TargetUsername + " is not a collaborator, pending invitations are cancelled under /invitations"})
 			return
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
+	event := auditEvent(c, rh.Authorizer, services.AuditRepoAccessRevoke)
+	event.Target = req.TargetUsername
+	rh.AuditService.Record(event)
+
 	c.JSON(http.StatusAccepted, gin.H{"message": "success revoking access"})
 }
 
@@ -167,27 +257,186 @@ func (rh *RepoHandler) HandleGetAllRepos(c *gin.Context) {
 }
 
 func (rh *RepoHandler) HandlePush(c *gin.Context) {
-	contributor, ok := c.Get("CONTRIBUTOR")
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "contributor state was not found in context"})
+	currentUser, err := rh.Authorizer.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
 		return
 	}
 
-	if contributor == false {
-		c.JSON(http.StatusUnauthorized,gin.H{"error":"you are not authorized to pull to this repo"})
-		return 
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	// Tokens without repo:admin push with no more than write, like any other route needing maintain
+	role := rh.Authorizer.ExtractRoleFromContext(c)
+	if role.AtLeast(database.RoleMaintain) && !rh.Authorizer.HasScope(c, middleware.ScopeRepoAdmin) {
+		role = database.RoleWrite
 	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxPushSize)
+	result, err := rh.PushService.Push(currentUser, role, c.ClientIP(), repoOwner, repoName, body)
+
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, services.ErrPushTooLarge), errors.As(err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrPushTooLarge.Error()})
+		case errors.Is(err, services.ErrInvalidPush), errors.Is(err, services.ErrHashMismatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err == services.ErrRepoMoved:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			rh.Logger.Error("Error receiving push", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
 }
 
+// HandlePull streams ?branch= heads, every branch when none is given, leaving out what the ?have= commits already cover
 func (rh *RepoHandler) HandlePull(c *gin.Context) {
-	contributor, ok := c.Get("CONTRIBUTOR")
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "contributor state was not found in context"})
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	pull, err := rh.PullService.Prepare(repoOwner, repoName, c.QueryArray("branch"), c.QueryArray("have"))
+
+	if err != nil {
+		if err == services.ErrRefNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		rh.Logger.Error("Error preparing pull", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, a failure here can only cut the stream short
+	if err = pull.Write(c.Writer); err != nil {
+		rh.Logger.Error("Error streaming pull", "error", err)
+	}
+}
+
+func (rh *RepoHandler) HandleBlame(c *gin.Context) {
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	filePath := strings.TrimPrefix(c.Param("path"), "/")
+	if filePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file path was specified in url"})
+		return
+	}
+
+	start, err := strconv.Atoi(c.DefaultQuery("start", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be a line number"})
+		return
+	}
+
+	end, err := strconv.Atoi(c.DefaultQuery("end", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end must be a line number"})
+		return
+	}
+
+	ranges, err := rh.BlameService.Blame(repoOwner, repoName, c.Query("rev"), filePath, start, end)
+
+	if err != nil {
+		switch err {
+		case services.ErrRefNotFound, services.ErrFileNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case services.ErrInvalidLineRange:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			rh.Logger.Error("Error computing blame", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"path": filePath, "ranges": ranges})
+}
+
+func (rh *RepoHandler) HandleArchive(c *gin.Context) {
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	ref, format, err := services.SplitArchiveName(strings.TrimPrefix(c.Param("ref"), "/"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "archive must be requested as <ref>.tar.gz or <ref>.zip"})
+		return
+	}
+
+	archive, err := rh.ArchiveService.Prepare(repoOwner, repoName, ref, format, c.Query("prefix"), c.Query("path"))
+
+	if err != nil {
+		switch err {
+		case services.ErrRefNotFound, services.ErrDirectoryNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case services.ErrInvalidArchivePrefix, services.ErrUnsupportedArchiveFormat:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			rh.Logger.Error("Error preparing archive", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	contentType := "application/gzip"
+	if format == services.ArchiveZip {
+		contentType = "application/zip"
+	}
+
+	filename := repoName + "-" + strings.ReplaceAll(ref, "/", "-") + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, a failure here can only cut the stream short
+	if err = archive.Write(c.Writer); err != nil {
+		rh.Logger.Error("Error streaming archive", "error", err)
+	}
+}
+
+func (rh *RepoHandler) HandleGetBranches(c *gin.Context) {
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	branches, err := rh.CompareService.CompareBranches(repoOwner, repoName)
+
+	if err != nil {
+		rh.Logger.Error("Error comparing branches", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
-	
-	if contributor == false {
-		c.JSON(http.StatusUnauthorized,gin.H{"error":"you are not authorized to pull to this repo"})
-		return 
+
+	c.JSON(http.StatusOK, branches)
+}
+
+func (rh *RepoHandler) HandleCompare(c *gin.Context) {
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	base, head := c.Query("base"), c.Query("head")
+	if head == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no head ref was specified in request"})
+		return
+	}
+
+	comparison, err := rh.CompareService.Compare(repoOwner, repoName, base, head)
+
+	if err != nil {
+		if err == services.ErrRefNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		rh.Logger.Error("Error comparing refs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
 	}
+
+	c.JSON(http.StatusOK, comparison)
 }
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type SearchHandler struct {
+	Authorizer    *middleware.AuthenticationMiddleware
+	SearchService *services.SearchService
+	Logger        *slog.Logger
+}
+
+func (sh *SearchHandler) HandleSearch(c *gin.Context) {
+	currentUser, err := sh.Authorizer.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	query := services.SearchQuery{
+		Query:         c.Query("q"),
+		Regex:         c.Query("regex") == "true",
+		CaseSensitive: c.Query("case_sensitive") == "true",
+		Repo:          c.Query("repo"),
+		Path:          c.Query("path"),
+		Extension:     c.Query("ext"),
+	}
+
+	results, indexing, err := sh.SearchService.Search(currentUser, query)
+
+	if err != nil {
+		if err == services.ErrInvalidSearchQuery {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sh.Logger.Error("Error searching code", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Repositories still being indexed are left out, searching again later covers them
+	c.JSON(http.StatusOK, gin.H{"results": results, "indexing": indexing})
+}
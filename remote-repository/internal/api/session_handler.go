@@ -0,0 +1,75 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type SessionHandler struct {
+	Authentication *middleware.AuthenticationMiddleware
+	AuthService    *services.AuthService
+	Logger         *slog.Logger
+}
+
+func (sh *SessionHandler) HandleGetSessions(c *gin.Context) {
+	username, err := sh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	sessions, err := sh.AuthService.Sessions(username, sh.Authentication.ExtractSessionFromContext(c))
+
+	if err != nil {
+		sh.Logger.Error("Error listing sessions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (sh *SessionHandler) HandleRevokeSession(c *gin.Context) {
+	username, err := sh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	err = sh.AuthService.RevokeSession(username, c.Param("id"), c.ClientIP())
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		sh.Logger.Error("Error revoking session", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+func (sh *SessionHandler) HandleRevokeOtherSessions(c *gin.Context) {
+	username, err := sh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	err = sh.AuthService.RevokeOtherSessions(username, sh.Authentication.ExtractSessionFromContext(c), c.ClientIP())
+
+	if err != nil {
+		sh.Logger.Error("Error revoking sessions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "signed out of every other session"})
+}
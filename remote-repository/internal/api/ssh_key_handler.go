@@ -0,0 +1,96 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type SSHKeyHandler struct {
+	Authentication *middleware.AuthenticationMiddleware
+	SSHKeyService  *services.SSHKeyService
+	Logger         *slog.Logger
+}
+
+func (sh *SSHKeyHandler) HandleAddSSHKey(c *gin.Context) {
+	username, err := sh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	var req models.AddSSHKeyRequest
+	if err = c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	key, err := sh.SSHKeyService.Add(username, req.Name, req.PublicKey, c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case services.ErrInvalidSSHKey, services.ErrWeakSSHKey, services.ErrInvalidSSHKeyName:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case services.ErrSSHKeyAlreadyInUse:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			sh.Logger.Error("Error adding ssh key", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+func (sh *SSHKeyHandler) HandleGetSSHKeys(c *gin.Context) {
+	username, err := sh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	keys, err := sh.SSHKeyService.GetAll(username)
+
+	if err != nil {
+		sh.Logger.Error("Error listing ssh keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func (sh *SSHKeyHandler) HandleDeleteSSHKey(c *gin.Context) {
+	username, err := sh.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+
+	err = sh.SSHKeyService.Delete(username, id, c.ClientIP())
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+			return
+		}
+		sh.Logger.Error("Error deleting ssh key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "key deleted"})
+}
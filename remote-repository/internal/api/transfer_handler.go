@@ -0,0 +1,180 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type TransferHandler struct {
+	Authentication  *middleware.AuthenticationMiddleware
+	TransferService *services.TransferService
+	Logger          *slog.Logger
+}
+
+// HandleRequestTransfer offers the repository to another user or organization, nothing moves until they accept
+func (th *TransferHandler) HandleRequestTransfer(c *gin.Context) {
+	actor, _ := th.Authentication.ExtractUserFromContext(c)
+
+	var req models.TransferRepoRequest
+	if err := c.BindJSON(&req); err != nil || req.NewOwner == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "new_owner is required"})
+		return
+	}
+
+	transfer, err := th.TransferService.Request(actor, c.GetString("REPOOWNER"), c.GetString("REPONAME"), req.NewOwner, c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case services.ErrNotRepoOwner:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case services.ErrUserNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "user or organization " + req.NewOwner + " does not exist"})
+		case services.ErrTransferToOwner:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case database.ErrRepoNameTaken:
+			c.JSON(http.StatusConflict, gin.H{"error": req.NewOwner + " already has a repository with this name"})
+		default:
+			th.Logger.Error("Error requesting transfer", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "transfer requested, the repository moves once it is accepted", "transfer": transfer})
+}
+
+func (th *TransferHandler) HandleCancelTransfer(c *gin.Context) {
+	actor, _ := th.Authentication.ExtractUserFromContext(c)
+
+	err := th.TransferService.Cancel(actor, c.GetString("REPOOWNER"), c.GetString("REPONAME"), c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case services.ErrNotRepoOwner:
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "no transfer of this repository is pending"})
+		default:
+			th.Logger.Error("Error cancelling transfer", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "transfer cancelled"})
+}
+
+// HandleGetTransfers lists the transfers the current user can accept
+func (th *TransferHandler) HandleGetTransfers(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	transfers, err := th.TransferService.Incoming(username)
+
+	if err != nil {
+		th.Logger.Error("Error listing transfers", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transfers)
+}
+
+func (th *TransferHandler) HandleAcceptTransfer(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transfer id"})
+		return
+	}
+
+	transfer, err := th.TransferService.Accept(username, id, c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "transfer not found or expired"})
+		case database.ErrRepoNameTaken:
+			c.JSON(http.StatusConflict, gin.H{"error": "a repository with this name already exists at the destination"})
+		case services.ErrTransferNeedsTwoFactor:
+			c.JSON(http.StatusForbidden, gin.H{"error": "enable two factor authentication before accepting private repositories"})
+		default:
+			th.Logger.Error("Error accepting transfer", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "transfer accepted", "repository": transfer.Recipient + "/" + transfer.RepoName})
+}
+
+func (th *TransferHandler) HandleDeclineTransfer(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transfer id"})
+		return
+	}
+
+	if err = th.TransferService.Decline(username, id, c.ClientIP()); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "transfer not found or expired"})
+			return
+		}
+		th.Logger.Error("Error declining transfer", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "transfer declined"})
+}
+
+// HandleRenameRepo gives the repository a new name, the old address, push and pull included, keeps working until the name is reused
+func (th *TransferHandler) HandleRenameRepo(c *gin.Context) {
+	actor, _ := th.Authentication.ExtractUserFromContext(c)
+
+	var req models.RenameRepoRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	username := c.GetString("REPOOWNER")
+	err := th.TransferService.Rename(actor, username, c.GetString("REPONAME"), req.Name, c.ClientIP())
+
+	if err != nil {
+		switch err {
+		case services.ErrInvalidRepoName, services.ErrSameRepoName:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case database.ErrRepoNameTaken:
+			c.JSON(http.StatusConflict, gin.H{"error": username + " already has a repository named " + req.Name})
+		default:
+			th.Logger.Error("Error renaming repository", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "repository renamed, the old name redirects to it", "repository": username + "/" + req.Name})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+)
+
+type TwoFactorHandler struct {
+	Authentication   *middleware.AuthenticationMiddleware
+	TwoFactorService *services.TwoFactorService
+	AuditService     *services.AuditService
+	Logger           *slog.Logger
+}
+
+func (th *TwoFactorHandler) HandleGetTwoFactorStatus(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	status, err := th.TwoFactorService.Status(username)
+
+	if err != nil {
+		th.Logger.Error("Error reading two factor status", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (th *TwoFactorHandler) HandleEnrollTwoFactor(c *gin.Context) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return
+	}
+
+	enrollment, err := th.TwoFactorService.Enroll(username)
+
+	if err != nil {
+		th.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, enrollment)
+}
+
+func (th *TwoFactorHandler) HandleEnableTwoFactor(c *gin.Context) {
+	username, code, ok := th.bindCode(c)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := th.TwoFactorService.Enable(username, code, c.ClientIP())
+
+	if err != nil {
+		th.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+func (th *TwoFactorHandler) HandleDisableTwoFactor(c *gin.Context) {
+	username, code, ok := th.bindCode(c)
+	if !ok {
+		return
+	}
+
+	if err := th.TwoFactorService.Disable(username, code, c.ClientIP()); err != nil {
+		th.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two factor authentication disabled"})
+}
+
+func (th *TwoFactorHandler) HandleRegenerateRecoveryCodes(c *gin.Context) {
+	username, code, ok := th.bindCode(c)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := th.TwoFactorService.RegenerateRecoveryCodes(username, code, c.ClientIP())
+
+	if err != nil {
+		th.respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+func (th *TwoFactorHandler) HandleRequireTwoFactor(c *gin.Context) {
+	repoOwner := c.GetString("REPOOWNER")
+	repoName := c.GetString("REPONAME")
+
+	var req models.RequireTwoFactorRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Format"})
+		return
+	}
+
+	missing, err := th.TwoFactorService.SetRepoRequirement(repoOwner, repoName, req.Required)
+
+	if err != nil {
+		switch err {
+		case services.ErrContributorsWithoutTwoFactor:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "contributors": missing})
+		case services.ErrTwoFactorRequired:
+			c.JSON(http.StatusForbidden, gin.H{"error": "enable two factor authentication on your account first"})
+		case sql.ErrNoRows:
+			c.JSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+		default:
+			th.Logger.Error("Error updating two factor requirement", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	event := auditEvent(c, th.Authentication, services.AuditRepoTwoFactorRule)
+	event.Details = map[string]string{"required": strconv.FormatBool(req.Required)}
+	th.AuditService.Record(event)
+
+	c.JSON(http.StatusOK, gin.H{"require_two_factor": req.Required})
+}
+
+func (th *TwoFactorHandler) bindCode(c *gin.Context) (string, string, bool) {
+	username, err := th.Authentication.ExtractUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "please log in"})
+		return "", "", false
+	}
+
+	var req models.TwoFactorCodeRequest
+	if err = c.BindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no code was specified in request"})
+		return "", "", false
+	}
+
+	return username, req.Code, true
+}
+
+func (th *TwoFactorHandler) respondError(c *gin.Context, err error) {
+	switch err {
+	case services.ErrInvalidTwoFactorCode:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case services.ErrTwoFactorAlreadyEnabled:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case services.ErrTwoFactorNotEnrolled, services.ErrTwoFactorNotEnabled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case services.ErrPrivateReposNeedTwoFactor:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		th.Logger.Error("Error updating two factor authentication", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	}
+}
@@ -12,7 +12,7 @@ import (
 )
 
 type UserHandler struct {
-	Authentication middleware.AuthenticationMiddleware
+	Authentication *middleware.AuthenticationMiddleware
 	UserStore      database.UserStore
 	Logger         *slog.Logger
 }
@@ -3,11 +3,19 @@ package app
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/api"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/cache"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/mailer"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/signing"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/sshserver"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/utils"
 	"github.com/ziad-eliwa/jit-version-control-system/migrations"
 	"log/slog"
 	"net/http"
@@ -18,10 +26,26 @@ import (
 type Application struct {
 	Logger *slog.Logger
 	DB     *sql.DB
+	Cache  cache.Cache
 
-	AuthHandler *api.AuthHandler
-	UserHandler *api.UserHandler
-	RepoHandler *api.RepoHandler
+	AuthHandler         *api.AuthHandler
+	OAuthHandler        *api.OAuthHandler
+	UserHandler         *api.UserHandler
+	RepoHandler         *api.RepoHandler
+	SearchHandler       *api.SearchHandler
+	AccessTokenHandler  *api.AccessTokenHandler
+	TwoFactorHandler    *api.TwoFactorHandler
+	EmailHandler        *api.EmailHandler
+	SSHKeyHandler       *api.SSHKeyHandler
+	SessionHandler      *api.SessionHandler
+	AuditHandler        *api.AuditHandler
+	AdminHandler        *api.AdminHandler
+	OrgHandler          *api.OrgHandler
+	InvitationHandler   *api.InvitationHandler
+	NotificationHandler *api.NotificationHandler
+	TransferHandler     *api.TransferHandler
+
+	SSHServer *sshserver.Server
 
 	AuthMiddleware *middleware.AuthenticationMiddleware
 }
@@ -37,12 +61,55 @@ func NewApplication(logger *slog.Logger) (*Application, error) {
 	if err != nil {
 		panic(err)
 	}
+
+	refreshTokenKey := utils.GetRefreshTokenKey()
+	if err = utils.CheckSecret("REFRESH_TOKEN_KEY", refreshTokenKey); err != nil {
+		return nil, err
+	}
+	if os.Getenv("JWT_SECRET") != "" {
+		logger.Warn("JWT_SECRET is no longer used, access tokens are signed with the keys in JWT_KEY_DIR")
+	}
+	if err = hashing.Configure(passwordParams()); err != nil {
+		return nil, fmt.Errorf("PASSWORD_HASH settings: %w", err)
+	}
+	jwtKeys, err := signing.Load(utils.GetJWTKeyDir())
+	if err != nil {
+		return nil, fmt.Errorf("loading JWT signing keys, create one with -generate-jwt-key: %w", err)
+	}
+	sharedCache, err := newCache(logger)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %w", err)
+	}
 	// Stores
 	userStore := &database.PostgresUserStore{
+		DB:       pgDB,
+		Logger:   logger,
+		TokenKey: []byte(refreshTokenKey),
+	}
+	tokenStore := &database.PostgresTokenStore{
 		DB:     pgDB,
 		Logger: logger,
+		Key:    []byte(refreshTokenKey),
 	}
-	tokenStore := &database.PostgresTokenStore{
+	accessTokenStore := &database.PostgresAccessTokenStore{
+		DB:     pgDB,
+		Logger: logger,
+		Key:    []byte(refreshTokenKey),
+	}
+	twoFactorStore := &database.PostgresTwoFactorStore{
+		DB:     pgDB,
+		Logger: logger,
+		Key:    []byte(refreshTokenKey),
+	}
+	if err = twoFactorStore.SealSecrets(); err != nil {
+		return nil, fmt.Errorf("sealing TOTP secrets: %w", err)
+	}
+	emailTokenStore := &database.PostgresEmailTokenStore{
+		DB:     pgDB,
+		Logger: logger,
+		Key:    []byte(refreshTokenKey),
+	}
+	sshKeyStore := &database.PostgresSSHKeyStore{
 		DB:     pgDB,
 		Logger: logger,
 	}
@@ -50,42 +117,313 @@ func NewApplication(logger *slog.Logger) (*Application, error) {
 		DB:     pgDB,
 		Logger: logger,
 	}
+	orgStore := &database.PostgresOrgStore{
+		DB:     pgDB,
+		Logger: logger,
+	}
+	invitationStore := &database.PostgresInvitationStore{
+		DB:     pgDB,
+		Logger: logger,
+	}
+	notificationStore := &database.PostgresNotificationStore{
+		DB:     pgDB,
+		Logger: logger,
+	}
+	transferStore := &database.PostgresTransferStore{
+		DB:     pgDB,
+		Logger: logger,
+	}
+	auditStore := &database.PostgresAuditStore{
+		DB:     pgDB,
+		Logger: logger,
+	}
+	objectStore := &objects.FileStore{
+		Root: utils.GetObjectStorePath(),
+	}
 	// Middleware
 	authMiddleware := &middleware.AuthenticationMiddleware{
-		TokenStore:  tokenStore,
-		RepoStore:   repoStore,
-		JWTSecret:   os.Getenv("JWT_SECRET"),
-		Timeout:     15 * time.Minute,
-		MaxRefresh:  24 * 7 * time.Hour,
-		Logger:      logger,
-		IdentityKey: "username",
+		UserStore:        userStore,
+		TokenStore:       tokenStore,
+		RepoStore:        repoStore,
+		AccessTokenStore: accessTokenStore,
+		TwoFactorStore:   twoFactorStore,
+		OrgStore:         orgStore,
+		Nonces:           sharedCache,
+		Denylist:         sharedCache,
+		Keys:             jwtKeys,
+		Issuer:           utils.GetBaseURL(),
+		Timeout:          15 * time.Minute,
+		MaxRefresh:       24 * 7 * time.Hour,
+		MaxSession:       24 * 30 * time.Hour,
+		Logger:           logger,
+		IdentityKey:      "username",
 	}
 	// Services
-	authService := services.NewAuthService(userStore, tokenStore, authMiddleware)
-	pushService := &services.PushService{}
-	pullService := &services.PullService{}
+	repoLocks := &services.RepoLocks{}
+	auditService := &services.AuditService{
+		AuditStore: auditStore,
+		Logger:     logger,
+	}
+	twoFactorService := &services.TwoFactorService{
+		TwoFactorStore: twoFactorStore,
+		RepoStore:      repoStore,
+		Audit:          auditService,
+	}
+	emailService := &services.EmailService{
+		UserStore:       userStore,
+		EmailTokenStore: emailTokenStore,
+		TokenStore:      tokenStore,
+		Mailer:          newMailer(),
+		BaseURL:         utils.GetBaseURL(),
+		Audit:           auditService,
+		Authentication:  authMiddleware,
+	}
+	authService := services.NewAuthService(userStore, tokenStore, twoFactorService, emailService, auditService, sharedCache, authMiddleware)
+	oauthService := services.NewOAuthService(userStore, authService, sharedCache, []byte(refreshTokenKey))
+	blameService := &services.BlameService{
+		RepoStore: repoStore,
+		Objects:   objectStore,
+	}
+	archiveService := &services.ArchiveService{
+		RepoStore: repoStore,
+		Objects:   objectStore,
+	}
+	compareService := &services.CompareService{
+		RepoStore: repoStore,
+		Objects:   objectStore,
+	}
+	searchService := services.NewSearchService(repoStore, objectStore, logger)
+	pushService := &services.PushService{
+		RepoStore:     repoStore,
+		Objects:       objectStore,
+		Logger:        logger,
+		SearchService: searchService,
+		Audit:         auditService,
+		Locks:         repoLocks,
+	}
+	pullService := &services.PullService{
+		RepoStore: repoStore,
+		Objects:   objectStore,
+	}
+	sshKeyService := &services.SSHKeyService{
+		SSHKeyStore: sshKeyStore,
+		Audit:       auditService,
+	}
+	adminService := &services.AdminService{
+		UserStore:      userStore,
+		RepoStore:      repoStore,
+		TokenStore:     tokenStore,
+		SSHKeyStore:    sshKeyStore,
+		Objects:        objectStore,
+		Email:          emailService,
+		Audit:          auditService,
+		SearchService:  searchService,
+		Authentication: authMiddleware,
+		Logger:         logger,
+		Locks:          repoLocks,
+	}
+	orgService := &services.OrgService{
+		OrgStore:  orgStore,
+		UserStore: userStore,
+		Audit:     auditService,
+	}
+	notificationService := &services.NotificationService{
+		NotificationStore: notificationStore,
+		Email:             emailService,
+		Logger:            logger,
+	}
+	invitationService := &services.InvitationService{
+		InvitationStore: invitationStore,
+		RepoStore:       repoStore,
+		UserStore:       userStore,
+		TwoFactor:       twoFactorService,
+		Notifications:   notificationService,
+		Audit:           auditService,
+	}
+	transferService := &services.TransferService{
+		RepoStore:     repoStore,
+		TransferStore: transferStore,
+		OrgStore:      orgStore,
+		UserStore:     userStore,
+		Objects:       objectStore,
+		SearchService: searchService,
+		Notifications: notificationService,
+		TwoFactor:     twoFactorService,
+		Audit:         auditService,
+		Logger:        logger,
+		Locks:         repoLocks,
+	}
+	accessTokenService := &services.AccessTokenService{
+		AccessTokenStore: accessTokenStore,
+		Authentication:   authMiddleware,
+		Audit:            auditService,
+	}
 	// Handlers
 	authHandler := &api.AuthHandler{
 		Logger:               logger,
-		AuthenticatonService: *authService,
+		AuthenticatonService: authService,
+	}
+	oauthHandler := &api.OAuthHandler{
+		OAuthService: oauthService,
+		Logger:       logger,
+	}
+	userHandler := &api.UserHandler{
+		Authentication: authMiddleware,
+		UserStore:      userStore,
+		Logger:         logger,
 	}
 	repoHandler := &api.RepoHandler{
 		Logger:      logger,
 		RepoStore:   repoStore,
+		OrgStore:    orgStore,
 		Authorizer:  authMiddleware,
 		PushService: pushService,
 		PullService: pullService,
+
+		BlameService:   blameService,
+		ArchiveService: archiveService,
+		CompareService: compareService,
+		SearchService:  searchService,
+
+		TwoFactorService:  twoFactorService,
+		AuditService:      auditService,
+		InvitationService: invitationService,
+	}
+	searchHandler := &api.SearchHandler{
+		Authorizer:    authMiddleware,
+		SearchService: searchService,
+		Logger:        logger,
+	}
+	accessTokenHandler := &api.AccessTokenHandler{
+		Authentication:     authMiddleware,
+		AccessTokenService: accessTokenService,
+		Logger:             logger,
+	}
+	twoFactorHandler := &api.TwoFactorHandler{
+		Authentication:   authMiddleware,
+		TwoFactorService: twoFactorService,
+		AuditService:     auditService,
+		Logger:           logger,
+	}
+	emailHandler := &api.EmailHandler{
+		Authentication: authMiddleware,
+		EmailService:   emailService,
+		Logger:         logger,
+	}
+	sshKeyHandler := &api.SSHKeyHandler{
+		Authentication: authMiddleware,
+		SSHKeyService:  sshKeyService,
+		Logger:         logger,
+	}
+	sessionHandler := &api.SessionHandler{
+		Authentication: authMiddleware,
+		AuthService:    authService,
+		Logger:         logger,
+	}
+	auditHandler := &api.AuditHandler{
+		Authentication: authMiddleware,
+		AuditService:   auditService,
+		Logger:         logger,
+	}
+	adminHandler := &api.AdminHandler{
+		Authentication: authMiddleware,
+		AdminService:   adminService,
+		Logger:         logger,
+	}
+	orgHandler := &api.OrgHandler{
+		Authentication: authMiddleware,
+		OrgService:     orgService,
+		Logger:         logger,
+	}
+	invitationHandler := &api.InvitationHandler{
+		Authentication:    authMiddleware,
+		InvitationService: invitationService,
+		Logger:            logger,
+	}
+	notificationHandler := &api.NotificationHandler{
+		Authentication:      authMiddleware,
+		NotificationService: notificationService,
+		Logger:              logger,
+	}
+	transferHandler := &api.TransferHandler{
+		Authentication:  authMiddleware,
+		TransferService: transferService,
+		Logger:          logger,
+	}
+	sshServer := &sshserver.Server{
+		HostKeyPath:   utils.GetEnv("SSH_HOST_KEY_PATH", "ssh_host_ed25519_key"),
+		SSHKeyService: sshKeyService,
+		Authorizer:    authMiddleware,
+		PushService:   pushService,
+		PullService:   pullService,
+		Logger:        logger,
 	}
 
 	return &Application{
-		Logger:         logger,
-		DB:             pgDB,
-		AuthHandler:    authHandler,
-		RepoHandler:    repoHandler,
-		AuthMiddleware: authMiddleware,
+		Logger:              logger,
+		DB:                  pgDB,
+		Cache:               sharedCache,
+		AuthHandler:         authHandler,
+		OAuthHandler:        oauthHandler,
+		UserHandler:         userHandler,
+		RepoHandler:         repoHandler,
+		SearchHandler:       searchHandler,
+		AccessTokenHandler:  accessTokenHandler,
+		TwoFactorHandler:    twoFactorHandler,
+		EmailHandler:        emailHandler,
+		SSHKeyHandler:       sshKeyHandler,
+		SessionHandler:      sessionHandler,
+		AuditHandler:        auditHandler,
+		AdminHandler:        adminHandler,
+		OrgHandler:          orgHandler,
+		InvitationHandler:   invitationHandler,
+		NotificationHandler: notificationHandler,
+		TransferHandler:     transferHandler,
+		SSHServer:           sshServer,
+		AuthMiddleware:      authMiddleware,
 	}, nil
 }
 
+// New password hashes use PASSWORD_HASH, bcrypt or argon2id, with the cost settings below.
+// Older hashes are upgraded when their owner logs in.
+func passwordParams() hashing.Params {
+	params := hashing.DefaultParams
+	params.Algorithm = utils.GetEnv("PASSWORD_HASH", params.Algorithm)
+	params.BcryptCost = utils.GetEnvInt("BCRYPT_COST", params.BcryptCost)
+	params.Argon2Memory = uint32(utils.GetEnvInt("ARGON2_MEMORY_KIB", int(params.Argon2Memory)))
+	params.Argon2Time = uint32(utils.GetEnvInt("ARGON2_TIME", int(params.Argon2Time)))
+	params.Argon2Threads = uint8(utils.GetEnvInt("ARGON2_THREADS", int(params.Argon2Threads)))
+	return params
+}
+
+// Without REDIS_URL signed out tokens are only denied by the instance that signed them out, and again accepted after a restart.
+// Nonces of signed push and pull requests are then remembered per instance too, behind a load balancer a request can be replayed to another one.
+func newCache(logger *slog.Logger) (cache.Cache, error) {
+	if url := utils.GetEnv("REDIS_URL", ""); url != "" {
+		return cache.NewRedisCache(url)
+	}
+
+	logger.Warn("REDIS_URL is not set, signed out access tokens and used request nonces are kept in memory only")
+	return cache.NewMemoryCache(), nil
+}
+
+// Mail goes through SMTP when SMTP_HOST is set, otherwise it is written to MAIL_DIR
+func newMailer() mailer.Mailer {
+	from := utils.GetEnv("MAIL_FROM", "JitHub <no-reply@localhost>")
+
+	if host := utils.GetEnv("SMTP_HOST", ""); host != "" {
+		return &mailer.SMTPMailer{
+			Host:     host,
+			Port:     utils.GetEnv("SMTP_PORT", "587"),
+			Username: utils.GetEnv("SMTP_USERNAME", ""),
+			Password: utils.GetEnv("SMTP_PASSWORD", ""),
+			From:     from,
+		}
+	}
+
+	return &mailer.DirMailer{Dir: utils.GetEnv("MAIL_DIR", "mail"), From: from}
+}
+
 func (app *Application) CheckHealth(c *gin.Context) {
 	app.Logger.Info("CHECK HEALTH: Kolo Zay El Fol")
 }
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
+)
+
+type AccessTokenStore interface {
+	CreateAccessToken(token *AccessToken, plaintext string) (*AccessToken, error)
+	GetAccessToken(plaintext string) (*AccessToken, error)
+	GetAllAccessTokens(username string) ([]AccessToken, error)
+	RevokeAccessToken(username string, id int) error
+	RevokeAllAccessTokens(username string) error
+	TouchAccessToken(id int) error
+}
+
+// Personal access token, only its keyed hash is stored
+type AccessToken struct {
+	ID         int        `json:"id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Revoked    bool       `json:"revoked"`
+}
+
+type PostgresAccessTokenStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+	// HMAC key for tokens at rest
+	Key []byte
+}
+
+func (pg *PostgresAccessTokenStore) CreateAccessToken(token *AccessToken, plaintext string) (*AccessToken, error) {
+	query :=
+		`INSERT INTO PersonalAccessTokens (username,name,token_hash,scopes,created_at,expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`
+
+	err := pg.DB.QueryRow(query, token.Username, token.Name, hashing.HashToken(pg.Key, plaintext),
+		strings.Join(token.Scopes, ","), token.CreatedAt, token.ExpiresAt).Scan(&token.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (pg *PostgresAccessTokenStore) GetAccessToken(plaintext string) (*AccessToken, error) {
+	query :=
+		`SELECT id, username, name, scopes, created_at, expires_at, last_used_at, revoked
+		FROM PersonalAccessTokens WHERE token_hash = $1`
+
+	return scanAccessToken(pg.DB.QueryRow(query, hashing.HashToken(pg.Key, plaintext)))
+}
+
+func (pg *PostgresAccessTokenStore) GetAllAccessTokens(username string) ([]AccessToken, error) {
+	query :=
+		`SELECT id, username, name, scopes, created_at, expires_at, last_used_at, revoked
+		FROM PersonalAccessTokens WHERE username = $1 ORDER BY created_at DESC`
+
+	rows, err := pg.DB.Query(query, username)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, *token)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return tokens, nil
+}
+
+func (pg *PostgresAccessTokenStore) RevokeAccessToken(username string, id int) error {
+	query :=
+		`UPDATE PersonalAccessTokens SET revoked = true WHERE id = $1 AND username = $2 AND revoked = false`
+
+	result, err := pg.DB.Exec(query, id, username)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RevokeAllAccessTokens revokes every token of username, having none is not an error
+func (pg *PostgresAccessTokenStore) RevokeAllAccessTokens(username string) error {
+	query :=
+		`UPDATE PersonalAccessTokens SET revoked = true WHERE username = $1 AND revoked = false`
+
+	_, err := pg.DB.Exec(query, username)
+
+	return err
+}
+
+func (pg *PostgresAccessTokenStore) TouchAccessToken(id int) error {
+	query :=
+		`UPDATE PersonalAccessTokens SET last_used_at = $2 WHERE id = $1`
+
+	_, err := pg.DB.Exec(query, id, time.Now())
+
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAccessToken(row rowScanner) (*AccessToken, error) {
+	token := &AccessToken{}
+	var scopes string
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(&token.ID, &token.Username, &token.Name, &scopes, &token.CreatedAt, &token.ExpiresAt, &lastUsedAt, &token.Revoked)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return token, nil
+}
@@ -0,0 +1,268 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// prev_hash of the first entry
+const auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Arbitrary key of the advisory lock that serializes appends to the chain
+const auditChainLock = 4313
+
+type AuditStore interface {
+	AppendAuditEvent(event *AuditEvent) error
+	GetAuditEvents(filter AuditFilter) ([]AuditEvent, error)
+	VerifyAuditChain() (*AuditVerification, error)
+}
+
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Action    string            `json:"action"`
+	Actor     string            `json:"actor,omitempty"`
+	Target    string            `json:"target,omitempty"`
+	RepoOwner string            `json:"repo_owner,omitempty"`
+	RepoName  string            `json:"repo_name,omitempty"`
+	IPAddress string            `json:"ip_address,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	PrevHash  string            `json:"prev_hash"`
+	Hash      string            `json:"hash"`
+}
+
+// Empty fields do not filter, events come newest first
+type AuditFilter struct {
+	// Events the user did or that were done to them
+	User      string
+	Actor     string
+	Action    string
+	RepoOwner string
+	RepoName  string
+	Since     time.Time
+	Until     time.Time
+	// Cursor, only events with a smaller id
+	BeforeID int64
+	Limit    int
+}
+
+// Result of walking the whole chain, BrokenAt is the first entry whose hash does not match
+type AuditVerification struct {
+	Entries  int64  `json:"entries"`
+	Valid    bool   `json:"valid"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	LastHash string `json:"last_hash"`
+}
+
+type PostgresAuditStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+}
+
+// Hashed content of an entry, the field order is part of the chain format
+type auditContent struct {
+	PrevHash  string `json:"prev_hash"`
+	CreatedAt string `json:"created_at"`
+	Action    string `json:"action"`
+	Actor     string `json:"actor"`
+	Target    string `json:"target"`
+	RepoOwner string `json:"repo_owner"`
+	RepoName  string `json:"repo_name"`
+	IPAddress string `json:"ip_address"`
+	Details   string `json:"details"`
+}
+
+func auditHash(event *AuditEvent, details string) string {
+	content, _ := json.Marshal(auditContent{
+		PrevHash:  event.PrevHash,
+		CreatedAt: event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Action:    event.Action,
+		Actor:     event.Actor,
+		Target:    event.Target,
+		RepoOwner: event.RepoOwner,
+		RepoName:  event.RepoName,
+		IPAddress: event.IPAddress,
+		Details:   details,
+	})
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAuditEvent links event to the newest entry and stores it, filling in its id and hashes
+func (pg *PostgresAuditStore) AppendAuditEvent(event *AuditEvent) error {
+	details := "{}"
+	if len(event.Details) > 0 {
+		encoded, err := json.Marshal(event.Details)
+		if err != nil {
+			return err
+		}
+		details = string(encoded)
+	}
+
+	// Stored without time zone at microsecond precision, hashed as it reads back
+	event.CreatedAt = event.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`SELECT hash FROM AuditLog ORDER BY id DESC LIMIT 1`).Scan(&event.PrevHash)
+
+	if err == sql.ErrNoRows {
+		event.PrevHash = auditGenesisHash
+	} else if err != nil {
+		return err
+	}
+
+	event.Hash = auditHash(event, details)
+
+	query :=
+		`INSERT INTO AuditLog (created_at, action, actor, target, repo_owner, repo_name, ip_address, details, prev_hash, hash)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id`
+
+	err = tx.QueryRow(query, event.CreatedAt, event.Action, event.Actor, event.Target, event.RepoOwner, event.RepoName,
+		event.IPAddress, details, event.PrevHash, event.Hash).Scan(&event.ID)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresAuditStore) GetAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	var conditions []string
+	var args []any
+
+	where := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.User != "" {
+		args = append(args, filter.User)
+		conditions = append(conditions, fmt.Sprintf("(actor = $%d OR target = $%d)", len(args), len(args)))
+	}
+	if filter.Actor != "" {
+		where("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.RepoOwner != "" {
+		where("repo_owner = $%d", filter.RepoOwner)
+	}
+	if filter.RepoName != "" {
+		where("repo_name = $%d", filter.RepoName)
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= $%d", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < $%d", filter.Until.UTC())
+	}
+	if filter.BeforeID > 0 {
+		where("id < $%d", filter.BeforeID)
+	}
+
+	query := `SELECT id, created_at, action, actor, target, repo_owner, repo_name, ip_address, details, prev_hash, hash FROM AuditLog`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := pg.DB.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event, _, err := scanAuditEvent(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, *event)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return events, nil
+}
+
+// VerifyAuditChain recomputes every hash from the first entry on
+func (pg *PostgresAuditStore) VerifyAuditChain() (*AuditVerification, error) {
+	rows, err := pg.DB.Query(
+		`SELECT id, created_at, action, actor, target, repo_owner, repo_name, ip_address, details, prev_hash, hash
+		FROM AuditLog ORDER BY id ASC`)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &AuditVerification{Valid: true, LastHash: auditGenesisHash}
+	for rows.Next() {
+		event, details, err := scanAuditEvent(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Entries++
+		if event.PrevHash != result.LastHash || auditHash(event, details) != event.Hash {
+			result.Valid = false
+			result.BrokenAt = event.ID
+			return result, nil
+		}
+		result.LastHash = event.Hash
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return result, nil
+}
+
+func scanAuditEvent(row rowScanner) (*AuditEvent, string, error) {
+	event := &AuditEvent{}
+	var details string
+
+	err := row.Scan(&event.ID, &event.CreatedAt, &event.Action, &event.Actor, &event.Target, &event.RepoOwner,
+		&event.RepoName, &event.IPAddress, &details, &event.PrevHash, &event.Hash)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if details != "{}" {
+		if err = json.Unmarshal([]byte(details), &event.Details); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return event, details, nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
+)
+
+type EmailTokenPurpose string
+
+const (
+	VerifyEmail   EmailTokenPurpose = "VERIFY_EMAIL"
+	ResetPassword EmailTokenPurpose = "RESET_PASSWORD"
+)
+
+type EmailTokenStore interface {
+	CreateEmailToken(token *EmailToken, plaintext string) error
+	ConsumeEmailToken(purpose EmailTokenPurpose, plaintext string) (*EmailToken, error)
+}
+
+// Token mailed to a user, only its keyed hash is stored
+type EmailToken struct {
+	Username     string
+	Purpose      EmailTokenPurpose
+	EmailAddress string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type PostgresEmailTokenStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+	// HMAC key for tokens at rest
+	Key []byte
+}
+
+// CreateEmailToken stores a token and drops the unused ones it supersedes
+func (pg *PostgresEmailTokenStore) CreateEmailToken(token *EmailToken, plaintext string) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM EmailTokens WHERE username = $1 AND purpose = $2 AND used_at IS NULL`, token.Username, token.Purpose)
+
+	if err != nil {
+		return err
+	}
+
+	query :=
+		`INSERT INTO EmailTokens (token_hash,username,purpose,email_address,created_at,expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6)`
+
+	_, err = tx.Exec(query, hashing.HashToken(pg.Key, plaintext), token.Username, token.Purpose,
+		token.EmailAddress, token.CreatedAt, token.ExpiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeEmailToken marks a token used and returns it, sql.ErrNoRows if it is unknown, used or expired
+func (pg *PostgresEmailTokenStore) ConsumeEmailToken(purpose EmailTokenPurpose, plaintext string) (*EmailToken, error) {
+	query :=
+		`UPDATE EmailTokens SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING username, purpose, email_address, created_at, expires_at`
+
+	token := &EmailToken{}
+	err := pg.DB.QueryRow(query, hashing.HashToken(pg.Key, plaintext), purpose, time.Now()).
+		Scan(&token.Username, &token.Purpose, &token.EmailAddress, &token.CreatedAt, &token.ExpiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Pending offer of a role on a repository, the role applies once the invitee accepts
+type Invitation struct {
+	ID        int       `json:"id"`
+	RepoOwner string    `json:"repo_owner"`
+	RepoName  string    `json:"repo_name"`
+	Invitee   string    `json:"invitee"`
+	Inviter   string    `json:"inviter"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type InvitationStore interface {
+	CreateInvitation(invitation *Invitation) (*Invitation, error)
+	GetInvitation(invitee string, id int) (*Invitation, error)
+	GetRepoInvitations(username, reponame string) ([]Invitation, error)
+	GetUserInvitations(invitee string) ([]Invitation, error)
+	AcceptInvitation(invitee string, id int) (*Invitation, error)
+	DeclineInvitation(invitee string, id int) (*Invitation, error)
+	CancelInvitation(username, reponame string, id int) (*Invitation, error)
+}
+
+type PostgresInvitationStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+}
+
+const invitationColumns = `id, repoOwner, repoName, invitee, inviter, role, createdAt, expiresAt`
+
+// CreateInvitation replaces an earlier invitation of the same user to the same repository, expired or not
+func (pg *PostgresInvitationStore) CreateInvitation(invitation *Invitation) (*Invitation, error) {
+	query :=
+		`INSERT INTO RepositoryInvitations (repoOwner, repoName, invitee, inviter, role, createdAt, expiresAt)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (repoOwner, repoName, invitee) DO UPDATE SET
+			inviter = EXCLUDED.inviter, role = EXCLUDED.role, createdAt = EXCLUDED.createdAt, expiresAt = EXCLUDED.expiresAt
+		RETURNING id`
+
+	err := pg.DB.QueryRow(query, invitation.RepoOwner, invitation.RepoName, invitation.Invitee, invitation.Inviter,
+		invitation.Role, invitation.CreatedAt, invitation.ExpiresAt).Scan(&invitation.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// GetInvitation is a pending invitation of invitee, sql.ErrNoRows once it expired
+func (pg *PostgresInvitationStore) GetInvitation(invitee string, id int) (*Invitation, error) {
+	query :=
+		`SELECT ` + invitationColumns + ` FROM RepositoryInvitations WHERE id = $1 AND invitee = $2 AND expiresAt > $3`
+
+	return scanInvitation(pg.DB.QueryRow(query, id, invitee, time.Now()))
+}
+
+func (pg *PostgresInvitationStore) GetRepoInvitations(username, reponame string) ([]Invitation, error) {
+	query :=
+		`SELECT ` + invitationColumns + ` FROM RepositoryInvitations
+		WHERE repoOwner = $1 AND repoName = $2 AND expiresAt > $3 ORDER BY createdAt DESC`
+
+	return pg.queryInvitations(query, username, reponame, time.Now())
+}
+
+func (pg *PostgresInvitationStore) GetUserInvitations(invitee string) ([]Invitation, error) {
+	query :=
+		`SELECT ` + invitationColumns + ` FROM RepositoryInvitations
+		WHERE invitee = $1 AND expiresAt > $2 ORDER BY createdAt DESC`
+
+	return pg.queryInvitations(query, invitee, time.Now())
+}
+
+// AcceptInvitation makes the invitee a collaborator with the invited role, a role held already is replaced
+func (pg *PostgresInvitationStore) AcceptInvitation(invitee string, id int) (*Invitation, error) {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invitation, err := scanInvitation(tx.QueryRow(
+		`DELETE FROM RepositoryInvitations WHERE id = $1 AND invitee = $2 AND expiresAt > $3 RETURNING `+invitationColumns,
+		id, invitee, time.Now()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO RepositoryUsers (contributor, repoOwner, repoName, role) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (repoName, repoOwner, contributor) DO UPDATE SET role = EXCLUDED.role`,
+		invitation.Invitee, invitation.RepoOwner, invitation.RepoName, invitation.Role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+func (pg *PostgresInvitationStore) DeclineInvitation(invitee string, id int) (*Invitation, error) {
+	query :=
+		`DELETE FROM RepositoryInvitations WHERE id = $1 AND invitee = $2 AND expiresAt > $3 RETURNING ` + invitationColumns
+
+	return scanInvitation(pg.DB.QueryRow(query, id, invitee, time.Now()))
+}
+
+// CancelInvitation withdraws an invitation to the repository, expired ones included
+func (pg *PostgresInvitationStore) CancelInvitation(username, reponame string, id int) (*Invitation, error) {
+	query :=
+		`DELETE FROM RepositoryInvitations WHERE id = $1 AND repoOwner = $2 AND repoName = $3 RETURNING ` + invitationColumns
+
+	return scanInvitation(pg.DB.QueryRow(query, id, username, reponame))
+}
+
+func (pg *PostgresInvitationStore) queryInvitations(query string, args ...any) ([]Invitation, error) {
+	rows, err := pg.DB.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, *invitation)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return invitations, nil
+}
+
+func scanInvitation(row rowScanner) (*Invitation, error) {
+	invitation := &Invitation{}
+
+	err := row.Scan(&invitation.ID, &invitation.RepoOwner, &invitation.RepoName, &invitation.Invitee, &invitation.Inviter,
+		&invitation.Role, &invitation.CreatedAt, &invitation.ExpiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
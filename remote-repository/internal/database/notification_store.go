@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+type NotificationStore interface {
+	CreateNotification(notification *Notification) error
+	GetNotifications(username string, unreadOnly bool, limit int) ([]Notification, error)
+	MarkNotificationRead(username string, id int64) error
+	MarkAllNotificationsRead(username string) error
+}
+
+// Something that happened to a user, like being invited to a repository
+type Notification struct {
+	ID        int64      `json:"id"`
+	Username  string     `json:"-"`
+	Kind      string     `json:"kind"`
+	Message   string     `json:"message"`
+	RepoOwner string     `json:"repo_owner,omitempty"`
+	RepoName  string     `json:"repo_name,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+type PostgresNotificationStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+}
+
+func (pg *PostgresNotificationStore) CreateNotification(notification *Notification) error {
+	query :=
+		`INSERT INTO Notifications (username, kind, message, repoOwner, repoName, createdAt)
+		VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`
+
+	return pg.DB.QueryRow(query, notification.Username, notification.Kind, notification.Message,
+		notification.RepoOwner, notification.RepoName, notification.CreatedAt).Scan(&notification.ID)
+}
+
+// GetNotifications lists the latest notifications of username first
+func (pg *PostgresNotificationStore) GetNotifications(username string, unreadOnly bool, limit int) ([]Notification, error) {
+	query :=
+		`SELECT id, username, kind, message, repoOwner, repoName, createdAt, readAt FROM Notifications
+		WHERE username = $1 AND (NOT $2 OR readAt IS NULL)
+		ORDER BY id DESC LIMIT $3`
+
+	rows, err := pg.DB.Query(query, username, unreadOnly, limit)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		var readAt sql.NullTime
+
+		err = rows.Scan(&notification.ID, &notification.Username, &notification.Kind, &notification.Message,
+			&notification.RepoOwner, &notification.RepoName, &notification.CreatedAt, &readAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if readAt.Valid {
+			notification.ReadAt = &readAt.Time
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return notifications, nil
+}
+
+func (pg *PostgresNotificationStore) MarkNotificationRead(username string, id int64) error {
+	query :=
+		`UPDATE Notifications SET readAt = COALESCE(readAt, $3) WHERE id = $1 AND username = $2`
+
+	result, err := pg.DB.Exec(query, id, username, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (pg *PostgresNotificationStore) MarkAllNotificationsRead(username string) error {
+	query :=
+		`UPDATE Notifications SET readAt = $2 WHERE username = $1 AND readAt IS NULL`
+
+	_, err := pg.DB.Exec(query, username, time.Now())
+
+	return err
+}
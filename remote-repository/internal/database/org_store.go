@@ -0,0 +1,374 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+var ErrLastOrgOwner = errors.New("An organization needs at least one owner")
+
+// Organization roles, owners manage members, teams and every repository of the organization
+type OrgRole string
+
+const (
+	OrgMember OrgRole = "member"
+	OrgOwner  OrgRole = "owner"
+)
+
+type OrgMembership struct {
+	Username string  `json:"username"`
+	Role     OrgRole `json:"role"`
+}
+
+// Group of organization members granted roles on repositories of the organization in bulk
+type Team struct {
+	Org         string `json:"org"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type TeamRepo struct {
+	RepoName string `json:"repo_name"`
+	Role     Role   `json:"role"`
+}
+
+type OrgStore interface {
+	CreateOrganization(org *User, owner string) error
+	GetOrgRole(org, username string) (OrgRole, error)
+	GetOrgMembers(org string) ([]OrgMembership, error)
+	SetOrgMember(org, username string, role OrgRole) error
+	RemoveOrgMember(org, username string) error
+
+	CreateTeam(team *Team) error
+	GetTeams(org string) ([]Team, error)
+	DeleteTeam(org, team string) error
+	GetTeamMembers(org, team string) ([]string, error)
+	AddTeamMember(org, team, username string) error
+	RemoveTeamMember(org, team, username string) error
+	GetTeamRepos(org, team string) ([]TeamRepo, error)
+	SetTeamRepo(org, team, reponame string, role Role) error
+	RemoveTeamRepo(org, team, reponame string) error
+}
+
+type PostgresOrgStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+}
+
+// CreateOrganization adds the organization to the namespace of users with owner as its first owner
+func (pg *PostgresOrgStore) CreateOrganization(org *User, owner string) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO Users (username, fullname, password_hash, bio, email_address, account_type) VALUES ($1,$2,'',$3,NULL,$4)`,
+		org.Username, org.FullName, org.Bio, AccountOrganization)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO OrganizationMembers (org, username, role) VALUES ($1,$2,$3)`, org.Username, owner, OrgOwner)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetOrgRole is the role of username in org, empty when they are not a member
+func (pg *PostgresOrgStore) GetOrgRole(org, username string) (OrgRole, error) {
+	query :=
+		`SELECT role FROM OrganizationMembers WHERE org = $1 AND username = $2`
+
+	var role OrgRole
+	err := pg.DB.QueryRow(query, org, username).Scan(&role)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return role, nil
+}
+
+func (pg *PostgresOrgStore) GetOrgMembers(org string) ([]OrgMembership, error) {
+	query :=
+		`SELECT username, role FROM OrganizationMembers WHERE org = $1 ORDER BY role DESC, username`
+
+	rows, err := pg.DB.Query(query, org)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrgMembership{}
+	for rows.Next() {
+		var member OrgMembership
+
+		if err = rows.Scan(&member.Username, &member.Role); err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return members, nil
+}
+
+// SetOrgMember adds username to org, or changes the role of an existing member.
+// Demoting the last owner fails with ErrLastOrgOwner.
+func (pg *PostgresOrgStore) SetOrgMember(org, username string, role OrgRole) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != OrgOwner {
+		if err = keepAnOwner(tx, org, username); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO OrganizationMembers (org, username, role) VALUES ($1,$2,$3)
+		ON CONFLICT (org, username) DO UPDATE SET role = EXCLUDED.role`,
+		org, username, role)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveOrgMember also takes username off every team of org, removing the last owner fails with ErrLastOrgOwner
+func (pg *PostgresOrgStore) RemoveOrgMember(org, username string) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = keepAnOwner(tx, org, username); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM OrganizationMembers WHERE org = $1 AND username = $2`, org, username)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// keepAnOwner locks the owners of org until tx ends and fails when username is the only one,
+// so two owners stepping down at the same time cannot leave the organization without one
+func keepAnOwner(tx *sql.Tx, org, username string) error {
+	rows, err := tx.Query(`SELECT username FROM OrganizationMembers WHERE org = $1 AND role = 'owner' FOR UPDATE`, org)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	owners := 0
+	isOwner := false
+	for rows.Next() {
+		var owner string
+
+		if err = rows.Scan(&owner); err != nil {
+			return err
+		}
+
+		owners++
+		isOwner = isOwner || owner == username
+	}
+
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	if isOwner && owners <= 1 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
+
+func (pg *PostgresOrgStore) CreateTeam(team *Team) error {
+	query :=
+		`INSERT INTO Teams (org, name, description) VALUES ($1,$2,$3)`
+
+	_, err := pg.DB.Exec(query, team.Org, team.Name, team.Description)
+
+	return err
+}
+
+func (pg *PostgresOrgStore) GetTeams(org string) ([]Team, error) {
+	query :=
+		`SELECT org, name, description FROM Teams WHERE org = $1 ORDER BY name`
+
+	rows, err := pg.DB.Query(query, org)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		var team Team
+
+		if err = rows.Scan(&team.Org, &team.Name, &team.Description); err != nil {
+			return nil, err
+		}
+
+		teams = append(teams, team)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return teams, nil
+}
+
+// DeleteTeam drops its members and the roles it granted
+func (pg *PostgresOrgStore) DeleteTeam(org, team string) error {
+	return pg.exec(`DELETE FROM Teams WHERE org = $1 AND name = $2`, org, team)
+}
+
+func (pg *PostgresOrgStore) GetTeamMembers(org, team string) ([]string, error) {
+	query :=
+		`SELECT username FROM TeamMembers WHERE org = $1 AND team = $2 ORDER BY username`
+
+	rows, err := pg.DB.Query(query, org, team)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []string{}
+	for rows.Next() {
+		var member string
+
+		if err = rows.Scan(&member); err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return members, nil
+}
+
+// AddTeamMember fails on the foreign key unless username is a member of org
+func (pg *PostgresOrgStore) AddTeamMember(org, team, username string) error {
+	query :=
+		`INSERT INTO TeamMembers (org, team, username) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`
+
+	_, err := pg.DB.Exec(query, org, team, username)
+
+	return err
+}
+
+func (pg *PostgresOrgStore) RemoveTeamMember(org, team, username string) error {
+	return pg.exec(`DELETE FROM TeamMembers WHERE org = $1 AND team = $2 AND username = $3`, org, team, username)
+}
+
+func (pg *PostgresOrgStore) GetTeamRepos(org, team string) ([]TeamRepo, error) {
+	query :=
+		`SELECT repoName, role FROM TeamRepositories WHERE org = $1 AND team = $2 ORDER BY repoName`
+
+	rows, err := pg.DB.Query(query, org, team)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := []TeamRepo{}
+	for rows.Next() {
+		var repo TeamRepo
+
+		if err = rows.Scan(&repo.RepoName, &repo.Role); err != nil {
+			return nil, err
+		}
+
+		repos = append(repos, repo)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return repos, nil
+}
+
+// SetTeamRepo grants every member of team role on a repository of org, or changes the role granted
+func (pg *PostgresOrgStore) SetTeamRepo(org, team, reponame string, role Role) error {
+	query :=
+		`INSERT INTO TeamRepositories (org, team, repoName, role) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (org, team, repoName) DO UPDATE SET role = EXCLUDED.role`
+
+	_, err := pg.DB.Exec(query, org, team, reponame, role)
+
+	return err
+}
+
+func (pg *PostgresOrgStore) RemoveTeamRepo(org, team, reponame string) error {
+	return pg.exec(`DELETE FROM TeamRepositories WHERE org = $1 AND team = $2 AND repoName = $3`, org, team, reponame)
+}
+
+// exec runs a statement that has to change a row, sql.ErrNoRows when none matched
+func (pg *PostgresOrgStore) exec(query string, args ...any) error {
+	result, err := pg.DB.Exec(query, args...)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
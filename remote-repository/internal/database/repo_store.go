@@ -9,6 +9,11 @@ import (
 	"time"
 )
 
+var (
+	ErrStaleBranch   = errors.New("Branch moved since it was read")
+	ErrRepoNameTaken = errors.New("Repository Already Exists")
+)
+
 type PrivacyState int
 
 const (
@@ -21,15 +26,53 @@ var Privacy = map[PrivacyState]string{
 	Private: "PRIVATE",
 }
 
+// Repository roles, each allows everything the ones before it do
+type Role string
+
+const (
+	RoleRead     Role = "read"
+	RoleTriage   Role = "triage"
+	RoleWrite    Role = "write"
+	RoleMaintain Role = "maintain"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleRead:     1,
+	RoleTriage:   2,
+	RoleWrite:    3,
+	RoleMaintain: 4,
+	RoleAdmin:    5,
+}
+
+func IsValidRole(role Role) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// AtLeast reports whether r allows what min does, the empty role allows nothing
+func (r Role) AtLeast(min Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[min]
+}
+
+// Someone with access to a repository, the owner is listed with the admin role
+type Collaborator struct {
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+	Owner    bool   `json:"owner,omitempty"`
+}
+
 type Repository struct {
-	RepoName     string    `json:"repo_name"`
-	RepoOwner    string    `json:"repo_owner"`
-	Description  string    `json:"description"`
-	Privacy      string    `json:"privacy"`
-	CreatedAt    time.Time `json:"created_at,omitempty"`
-	Contributors []string  `json:"contributors"`
-	Branches     []Branch  `json:"branches,omitempty"`
-	Secret       string    `json:"-"`
+	RepoName     string         `json:"repo_name"`
+	RepoOwner    string         `json:"repo_owner"`
+	Description  string         `json:"description"`
+	Privacy      string         `json:"privacy"`
+	CreatedAt    time.Time      `json:"created_at,omitempty"`
+	Contributors []Collaborator `json:"contributors"`
+	Branches     []Branch       `json:"branches,omitempty"`
+	Secret       string         `json:"-"`
+	// Bytes of stored objects, only filled in for administrators
+	SizeBytes int64 `json:"size_bytes,omitempty"`
 }
 
 type Branch struct {
@@ -57,12 +100,30 @@ type RepoStore interface {
 	CreateRepo(repo *Repository) (*Repository, error)
 	GetAllReposbyUsername(username, currentUsername string) ([]Repository, error)
 	GetRepoByUsername(username, reponame string) (*Repository, error)
-	GetAllContributors(username, reponame string) ([]string, error)
-	GetAccessStatusOnRepo(username, reponame, target string) (bool, error)
-	GrantAccessOnRepo(username, reponame, target string) error
+	GetAllContributors(username, reponame string) ([]Collaborator, error)
+	GetRoleOnRepo(username, reponame, target string) (Role, error)
+	GrantAccessOnRepo(username, reponame, target string, role Role) error
 	RevokeAccessOnRepo(username, reponame, target string) error
 	GetRepoPrivacy(username, reponame string) (string, error)
 	GetRepoSecret(username, reponame string) (string, error)
+	RotateRepoSecret(username, reponame string) (string, error)
+	GetBranchHead(username, reponame, branch string) (string, error)
+	GetBranchNames(username, reponame string) ([]string, error)
+	GetBranchHeads(username, reponame string) (map[string]string, error)
+	GetDefaultBranch(username, reponame string) (string, error)
+	GetReadableRepos(currentUsername string) ([]Repository, error)
+	GetRequireTwoFactor(username, reponame string) (bool, error)
+	SetRequireTwoFactor(username, reponame string, required bool) error
+	GetContributorsWithoutTwoFactor(username, reponame string) ([]string, error)
+	CountPrivateRepos(username string) (int, error)
+	GetCommitHashes(username, reponame string) (map[string]bool, error)
+	UpdateBranch(username, reponame, branch, oldHead, newHead, pusher string, commits []Commit) error
+	SearchRepos(query string, limit, offset int) ([]Repository, error)
+	DeleteRepo(username, reponame string) error
+	RepoExists(username, reponame string) (bool, error)
+	MoveRepo(username, reponame, newOwner, newName string) error
+	AcceptTransfer(transfer *Transfer) error
+	GetRepoRedirect(username, reponame string) (string, string, error)
 }
 
 type PostgresRepoStore struct {
@@ -80,23 +141,69 @@ func GenerateRepoSecret() string {
 
 func (pg *PostgresRepoStore) CreateRepo(repo *Repository) (*Repository, error) {
 	query :=
-		`INSERT INTO (repoName,repoOwner,description,privacy,createdAt,secret) VALUES ($1,$2,$3,$4,$5)`
+		`INSERT INTO Repository (repoName,repoOwner,description,privacy,createdAt,secret) VALUES ($1,$2,$3,$4,$5,$6)`
+
+	secret := GenerateRepoSecret()
+	if secret == "" {
+		return nil, errors.New("Could not generate repository secret")
+	}
 
 	tx, err := pg.DB.Begin()
 
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	_, err = tx.Exec(query, repo.RepoName, repo.RepoOwner, repo.Description, repo.Privacy, time.Now(), GenerateRepoSecret())
+	_, err = tx.Exec(query, repo.RepoName, repo.RepoOwner, repo.Description, repo.Privacy, time.Now(), secret)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// The location is taken again, it no longer leads to the repository that moved away from it
+	_, err = tx.Exec(`DELETE FROM RepositoryRedirects WHERE oldOwner = $1 AND oldName = $2`, repo.RepoOwner, repo.RepoName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return repo, nil
 }
 
+// RotateRepoSecret replaces the secret remote requests are signed with, clients need the new one from /remote
+func (pg *PostgresRepoStore) RotateRepoSecret(username, reponame string) (string, error) {
+	query :=
+		`UPDATE Repository SET secret = $3 WHERE repoOwner = $1 AND repoName = $2`
+
+	secret := GenerateRepoSecret()
+	if secret == "" {
+		return "", errors.New("Could not generate repository secret")
+	}
+
+	result, err := pg.DB.Exec(query, username, reponame, secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return "", err
+	}
+
+	if rowsAffected == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return secret, nil
+}
+
 func (pg *PostgresRepoStore) GetRepoSecret(username, reponame string) (string, error) {
 	query := 
 	`SELECT secret FROM Repository WHERE repoName = $1 AND repoOwner = $2`
@@ -171,10 +278,8 @@ func (pg *PostgresRepoStore) GetAllReposbyUsername(username, currentUsername str
 		}
 
 		currentUserQuery :=
-			`SELECT r.repoName, r.repoOwner, r.description, r.privacy, r.createdAt FROM Repository AS r 
-		INNER JOIN RepositoryUsers AS ru 
-		ON r.repoName = ru.repoName AND r.repoOwner = ru.repoOwner
-		WHERE ru.contributor = $1 AND r.repoOwner = $2`
+			`SELECT r.repoName, r.repoOwner, r.description, r.privacy, r.createdAt FROM Repository AS r
+		WHERE r.repoOwner = $2 AND r.privacy = 'PRIVATE' AND ` + hasRoleOnRepo
 
 		repo, err = pg.DB.Query(currentUserQuery, currentUsername, username)
 
@@ -199,7 +304,7 @@ func (pg *PostgresRepoStore) GetAllReposbyUsername(username, currentUsername str
 
 func (pg *PostgresRepoStore) GetRepoByUsername(username, reponame string) (*Repository, error) {
 	repoQuery :=
-		`SELECT * FROM Repository WHERE repoName = $1 AND repoOwner = $2`
+		`SELECT repoName, repoOwner, description, privacy, createdAt FROM Repository WHERE repoName = $1 AND repoOwner = $2`
 
 	repo := &Repository{}
 	err := pg.DB.QueryRow(repoQuery, reponame, username).Scan(&repo.RepoName, &repo.RepoOwner, &repo.Description, &repo.Privacy, &repo.CreatedAt)
@@ -296,46 +401,49 @@ func (pg *PostgresRepoStore) GetRepoByUsername(username, reponame string) (*Repo
 	return repo, nil
 }
 
-func (pg *PostgresRepoStore) GetAllContributors(username, reponame string) ([]string, error) {
+func (pg *PostgresRepoStore) GetAllContributors(username, reponame string) ([]Collaborator, error) {
 	query :=
-		`SELECT contributor FROM RepositoryUsers WHERE repoOwner = $1 AND repoName = $2`
+		`SELECT contributor, role FROM RepositoryUsers WHERE repoOwner = $1 AND repoName = $2 ORDER BY contributor`
 
 	rows, err := pg.DB.Query(query, username, reponame)
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var contributors []string
+	contributors := []Collaborator{{Username: username, Role: RoleAdmin, Owner: true}}
 	for rows.Next() {
-		var contributorName string
+		var contributor Collaborator
 
-		if err = rows.Scan(&contributorName); err != nil {
+		if err = rows.Scan(&contributor.Username, &contributor.Role); err != nil {
 			return nil, err
 		}
 
-		contributors = append(contributors, contributorName)
+		contributors = append(contributors, contributor)
 	}
 
-	return append(contributors, username), nil
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return contributors, nil
 }
 
-func (pg *PostgresRepoStore) GrantAccessOnRepo(username, reponame, target string) error {
+// GrantAccessOnRepo changes the role of an existing collaborator, sql.ErrNoRows for anyone else.
+// New collaborators are added by accepting an invitation, see InvitationStore.
+func (pg *PostgresRepoStore) GrantAccessOnRepo(username, reponame, target string, role Role) error {
 	query :=
-		`INSERT INTO RepositoryUsers(contributor,repoOwner,repoName) VALUES($1,$2,$3)`
+		`UPDATE RepositoryUsers SET role = $4 WHERE contributor = $1 AND repoOwner = $2 AND repoName = $3`
 
-	tx, err := pg.DB.Begin()
+	result, err := pg.DB.Exec(query, target, username, reponame, role)
 
 	if err != nil {
 		return err
 	}
 
-	rows, err := tx.Exec(query, target, username, reponame)
-	if err != nil {
-		return err
-	}
+	rowsAffected, err := result.RowsAffected()
 
-	rowsAffected, err := rows.RowsAffected()
 	if err != nil {
 		return err
 	}
@@ -344,9 +452,7 @@ func (pg *PostgresRepoStore) GrantAccessOnRepo(username, reponame, target string
 		return sql.ErrNoRows
 	}
 
-	err = tx.Commit()
-
-	return err
+	return nil
 }
 
 func (pg *PostgresRepoStore) RevokeAccessOnRepo(username, reponame, target string) error {
@@ -399,26 +505,554 @@ func (pg *PostgresRepoStore) GetRepoPrivacy(username, reponame string) (string,
 	return privacy, nil
 }
 
-func (pg *PostgresRepoStore) GetAccessStatusOnRepo(username, reponame, target string) (bool, error) {
+// GetRoleOnRepo is the highest role target holds, admin for the owner and the owners of an owning organization,
+// otherwise what target was granted directly or through the teams it is on. Empty for everyone else.
+func (pg *PostgresRepoStore) GetRoleOnRepo(username, reponame, target string) (Role, error) {
 	if username == target {
-		return true, nil
+		return RoleAdmin, nil
 	}
 	query :=
-		`SELECT contributor FROM RepositoryUsers WHERE repoOwner = $1 AND repoName = $2 AND contributor = $3`
+		`SELECT role FROM RepositoryUsers WHERE repoOwner = $1 AND repoName = $2 AND contributor = $3
+		UNION ALL
+		SELECT 'admin' FROM OrganizationMembers WHERE org = $1 AND username = $3 AND role = 'owner'
+		UNION ALL
+		SELECT tr.role FROM TeamRepositories AS tr
+		JOIN TeamMembers AS tm ON tm.org = tr.org AND tm.team = tr.team
+		WHERE tr.org = $1 AND tr.repoName = $2 AND tm.username = $3`
 
-	var contributor string
-	err := pg.DB.QueryRow(query, username, reponame, target).Scan(contributor)
+	rows, err := pg.DB.Query(query, username, reponame, target)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
+		return "", err
+	}
+	defer rows.Close()
+
+	var highest Role
+	for rows.Next() {
+		var role Role
+
+		if err = rows.Scan(&role); err != nil {
+			return "", err
+		}
+
+		if !highest.AtLeast(role) {
+			highest = role
+		}
+	}
+
+	if rows.Err() != nil {
+		return "", rows.Err()
+	}
+
+	return highest, nil
+}
+
+// Head recorded by the last push, or the latest commit for branches that were never pushed to
+const branchHeadQuery = `
+	SELECT COALESCE(b.headCommit, (
+		SELECT c.commitHash FROM Commit AS c
+		WHERE c.repoOwner = b.repoOwner AND c.repoName = b.repoName AND c.branchName = b.branchName
+		ORDER BY c.commitTime DESC LIMIT 1
+	)) FROM Branch AS b WHERE b.repoOwner = $1 AND b.repoName = $2 AND b.branchName = $3`
+
+func (pg *PostgresRepoStore) GetBranchHead(username, reponame, branch string) (string, error) {
+	var head sql.NullString
+	err := pg.DB.QueryRow(branchHeadQuery, username, reponame, branch).Scan(&head)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !head.Valid {
+		return "", sql.ErrNoRows
+	}
+
+	return head.String, nil
+}
+
+// GetBranchHeads maps every branch with a commit to its head, with the same fallback as GetBranchHead
+func (pg *PostgresRepoStore) GetBranchHeads(username, reponame string) (map[string]string, error) {
+	query :=
+		`SELECT b.branchName, COALESCE(b.headCommit, (
+			SELECT c.commitHash FROM Commit AS c
+			WHERE c.repoOwner = b.repoOwner AND c.repoName = b.repoName AND c.branchName = b.branchName
+			ORDER BY c.commitTime DESC LIMIT 1
+		)) FROM Branch AS b WHERE b.repoOwner = $1 AND b.repoName = $2`
+
+	rows, err := pg.DB.Query(query, username, reponame)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heads := map[string]string{}
+	for rows.Next() {
+		var branch string
+		var head sql.NullString
+
+		if err = rows.Scan(&branch, &head); err != nil {
+			return nil, err
+		}
+
+		if head.Valid {
+			heads[branch] = head.String
+		}
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return heads, nil
+}
+
+func (pg *PostgresRepoStore) GetBranchNames(username, reponame string) ([]string, error) {
+	query :=
+		`SELECT branchName FROM Branch WHERE repoOwner = $1 AND repoName = $2 ORDER BY branchName`
+
+	rows, err := pg.DB.Query(query, username, reponame)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var branches []string
+	for rows.Next() {
+		var branch string
+
+		if err = rows.Scan(&branch); err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, branch)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return branches, nil
+}
+
+func (pg *PostgresRepoStore) GetDefaultBranch(username, reponame string) (string, error) {
+	query :=
+		`SELECT defaultBranch FROM Repository WHERE repoOwner = $1 AND repoName = $2`
+
+	var branch string
+	err := pg.DB.QueryRow(query, username, reponame).Scan(&branch)
+
+	if err != nil {
+		return "", err
+	}
+
+	return branch, nil
+}
+
+// Matches repositories r that $1 holds a role on, see GetRoleOnRepo
+const hasRoleOnRepo = `(r.repoOwner = $1 OR EXISTS (
+		SELECT 1 FROM RepositoryUsers AS ru
+		WHERE ru.repoName = r.repoName AND ru.repoOwner = r.repoOwner AND ru.contributor = $1
+	) OR EXISTS (
+		SELECT 1 FROM OrganizationMembers AS om
+		WHERE om.org = r.repoOwner AND om.username = $1 AND om.role = 'owner'
+	) OR EXISTS (
+		SELECT 1 FROM TeamRepositories AS tr
+		JOIN TeamMembers AS tm ON tm.org = tr.org AND tm.team = tr.team
+		WHERE tr.org = r.repoOwner AND tr.repoName = r.repoName AND tm.username = $1
+	))`
+
+// GetReadableRepos lists every repository that is public or that currentUsername holds a role on
+func (pg *PostgresRepoStore) GetReadableRepos(currentUsername string) ([]Repository, error) {
+	query :=
+		`SELECT r.repoName, r.repoOwner, r.description, r.privacy, r.createdAt FROM Repository AS r
+		WHERE r.privacy = 'PUBLIC' OR ` + hasRoleOnRepo + `
+		ORDER BY r.repoOwner, r.repoName`
+
+	rows, err := pg.DB.Query(query, currentUsername)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var repos []Repository
+	for rows.Next() {
+		var repo Repository
+
+		if err = rows.Scan(&repo.RepoName, &repo.RepoOwner, &repo.Description, &repo.Privacy, &repo.CreatedAt); err != nil {
+			return nil, err
 		}
+
+		repos = append(repos, repo)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return repos, nil
+}
+
+func (pg *PostgresRepoStore) GetRequireTwoFactor(username, reponame string) (bool, error) {
+	query :=
+		`SELECT requireTwoFactor FROM Repository WHERE repoOwner = $1 AND repoName = $2`
+
+	var required bool
+	err := pg.DB.QueryRow(query, username, reponame).Scan(&required)
+
+	if err != nil {
 		return false, err
 	}
 
-	if contributor != target {
-		return false, errors.New("Invalid Name")
+	return required, nil
+}
+
+func (pg *PostgresRepoStore) SetRequireTwoFactor(username, reponame string, required bool) error {
+	query :=
+		`UPDATE Repository SET requireTwoFactor = $3 WHERE repoOwner = $1 AND repoName = $2`
+
+	result, err := pg.DB.Exec(query, username, reponame, required)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (pg *PostgresRepoStore) GetContributorsWithoutTwoFactor(username, reponame string) ([]string, error) {
+	query :=
+		`SELECT ru.contributor FROM RepositoryUsers AS ru
+		JOIN Users AS u ON u.username = ru.contributor
+		WHERE ru.repoOwner = $1 AND ru.repoName = $2 AND u.totp_enabled = false
+		ORDER BY ru.contributor`
+
+	rows, err := pg.DB.Query(query, username, reponame)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contributors []string
+	for rows.Next() {
+		var contributor string
+
+		if err = rows.Scan(&contributor); err != nil {
+			return nil, err
+		}
+
+		contributors = append(contributors, contributor)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return contributors, nil
+}
+
+func (pg *PostgresRepoStore) CountPrivateRepos(username string) (int, error) {
+	query :=
+		`SELECT COUNT(*) FROM Repository WHERE repoOwner = $1 AND privacy = 'PRIVATE'`
+
+	var count int
+	err := pg.DB.QueryRow(query, username).Scan(&count)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetCommitHashes lists every commit recorded on any branch of the repository
+func (pg *PostgresRepoStore) GetCommitHashes(username, reponame string) (map[string]bool, error) {
+	query :=
+		`SELECT DISTINCT commitHash FROM Commit WHERE repoOwner = $1 AND repoName = $2`
+
+	rows, err := pg.DB.Query(query, username, reponame)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := map[string]bool{}
+	for rows.Next() {
+		var hash string
+
+		if err = rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+
+		hashes[hash] = true
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return hashes, nil
+}
+
+// UpdateBranch moves a branch from oldHead to newHead and records the commits it gained.
+// An empty oldHead creates the branch. ErrStaleBranch means the branch is no longer at oldHead.
+// Commit authors without an account are recorded as the pusher.
+func (pg *PostgresRepoStore) UpdateBranch(username, reponame, branch, oldHead, newHead, pusher string, commits []Commit) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if oldHead == "" {
+		result, err := tx.Exec(
+			`INSERT INTO Branch (branchName, repoName, repoOwner, headCommit) VALUES ($1,$2,$3,$4) ON CONFLICT DO NOTHING`,
+			branch, reponame, username, newHead)
+
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrStaleBranch
+		}
+	} else {
+		var head sql.NullString
+		err = tx.QueryRow(branchHeadQuery+` FOR UPDATE OF b`, username, reponame, branch).Scan(&head)
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrStaleBranch
+			}
+			return err
+		}
+
+		if head.String != oldHead {
+			return ErrStaleBranch
+		}
+	}
+
+	query :=
+		`INSERT INTO Commit (commitHash, branchName, repoName, repoOwner, author, commitMsg, commitTime, treeHash)
+		VALUES ($1,$2,$3,$4,COALESCE((SELECT username FROM Users WHERE username = $5), $6),$7,$8,$9)
+		ON CONFLICT DO NOTHING`
+
+	for _, commit := range commits {
+		_, err = tx.Exec(query, commit.CommitHash, branch, reponame, username, commit.AuthorUsername, pusher,
+			commit.CommitMsg, commit.CommitTime, commit.TreeHash)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE Branch SET headCommit = $4 WHERE branchName = $1 AND repoName = $2 AND repoOwner = $3`,
+		branch, reponame, username, newHead)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SearchRepos lists every repository whose owner/name contains query, private ones included
+func (pg *PostgresRepoStore) SearchRepos(query string, limit, offset int) ([]Repository, error) {
+	rows, err := pg.DB.Query(
+		`SELECT repoName, repoOwner, description, privacy, createdAt FROM Repository
+		WHERE $1 = '' OR repoOwner || '/' || repoName ILIKE '%' || $1 || '%'
+		ORDER BY repoOwner, repoName LIMIT $2 OFFSET $3`, escapeLike(query), limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := []Repository{}
+	for rows.Next() {
+		var repo Repository
+
+		if err = rows.Scan(&repo.RepoName, &repo.RepoOwner, &repo.Description, &repo.Privacy, &repo.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		repos = append(repos, repo)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return repos, nil
+}
+
+// DeleteRepo removes a repository with its branches, commits, files and contributors
+func (pg *PostgresRepoStore) DeleteRepo(username, reponame string) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Branches do not cascade from Repository, commits and files cascade from them
+	if _, err = tx.Exec(`DELETE FROM Branch WHERE repoOwner = $1 AND repoName = $2`, username, reponame); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM Repository WHERE repoOwner = $1 AND repoName = $2`, username, reponame)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresRepoStore) RepoExists(username, reponame string) (bool, error) {
+	query :=
+		`SELECT EXISTS (SELECT 1 FROM Repository WHERE repoOwner = $1 AND repoName = $2)`
+
+	var exists bool
+	err := pg.DB.QueryRow(query, username, reponame).Scan(&exists)
+
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// MoveRepo gives a repository a new owner and name, rewriting its key in every table that refers to it.
+// Collaborator roles and pending invitations move along, roles held through teams of the old owner do not.
+// The old location redirects to the new one until a repository takes it. ErrRepoNameTaken when the new location is in use.
+func (pg *PostgresRepoStore) MoveRepo(username, reponame, newOwner, newName string) error {
+	return pg.moveRepo(username, reponame, newOwner, newName, nil)
+}
+
+// AcceptTransfer moves the repository of a pending transfer to its recipient and consumes the transfer in the same transaction.
+// sql.ErrNoRows when it was cancelled, declined, redirected, accepted or expired since it was read.
+func (pg *PostgresRepoStore) AcceptTransfer(transfer *Transfer) error {
+	return pg.moveRepo(transfer.RepoOwner, transfer.RepoName, transfer.Recipient, transfer.RepoName, transfer)
+}
+
+func (pg *PostgresRepoStore) moveRepo(username, reponame, newOwner, newName string, transfer *Transfer) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// References are checked at commit, once every table points at the new key
+	if _, err = tx.Exec(`SET CONSTRAINTS ALL DEFERRED`); err != nil {
+		return err
+	}
+
+	if transfer != nil {
+		query :=
+			`DELETE FROM RepositoryTransfers
+			WHERE id = $1 AND recipient = $2 AND repoOwner = $3 AND repoName = $4 AND expiresAt > $5
+			RETURNING id`
+
+		var id int
+		if err = tx.QueryRow(query, transfer.ID, transfer.Recipient, username, reponame, time.Now()).Scan(&id); err != nil {
+			return err
+		}
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM Repository WHERE repoOwner = $1 AND repoName = $2)`, newOwner, newName).Scan(&exists)
+
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrRepoNameTaken
+	}
+
+	from, to := []any{username, reponame}, []any{newOwner, newName}
+	both := append(append([]any{}, from...), to...)
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		// Transfers and team roles stay with the owner they were made for
+		{`DELETE FROM RepositoryTransfers WHERE repoOwner = $1 AND repoName = $2 AND repoOwner <> $3`, []any{username, reponame, newOwner}},
+		{`DELETE FROM TeamRepositories WHERE org = $1 AND repoName = $2 AND org <> $3`, []any{username, reponame, newOwner}},
+		// The new owner has every permission, a role or invitation of theirs would only linger
+		{`DELETE FROM RepositoryUsers WHERE repoOwner = $1 AND repoName = $2 AND contributor = $3`, []any{username, reponame, newOwner}},
+		{`DELETE FROM RepositoryInvitations WHERE repoOwner = $1 AND repoName = $2 AND invitee = $3`, []any{username, reponame, newOwner}},
+		{`DELETE FROM RepositoryRedirects WHERE oldOwner = $1 AND oldName = $2`, to},
+
+		{`UPDATE Repository SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE Branch SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE Commit SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE ParentCommits SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE Files SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE RepositoryUsers SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE RepositoryInvitations SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE RepositoryTransfers SET repoOwner = $3, repoName = $4 WHERE repoOwner = $1 AND repoName = $2`, both},
+		{`UPDATE TeamRepositories SET repoName = $3 WHERE org = $1 AND repoName = $2`, []any{username, reponame, newName}},
+
+		// Earlier locations follow the repository instead of redirecting twice
+		{`UPDATE RepositoryRedirects SET newOwner = $3, newName = $4 WHERE newOwner = $1 AND newName = $2`, both},
+		{`INSERT INTO RepositoryRedirects (oldOwner, oldName, newOwner, newName, createdAt) VALUES ($1,$2,$3,$4,$5)`, append(both, time.Now())},
+	}
+
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement.query, statement.args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetRepoRedirect is where a repository that moved away from username/reponame lives now, sql.ErrNoRows if none did
+func (pg *PostgresRepoStore) GetRepoRedirect(username, reponame string) (string, string, error) {
+	query :=
+		`SELECT newOwner, newName FROM RepositoryRedirects WHERE oldOwner = $1 AND oldName = $2`
+
+	var newOwner, newName string
+	err := pg.DB.QueryRow(query, username, reponame).Scan(&newOwner, &newName)
+
+	if err != nil {
+		return "", "", err
 	}
 
-	return true, nil
+	return newOwner, newName, nil
 }
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+type SSHKeyStore interface {
+	CreateSSHKey(key *SSHKey) (*SSHKey, error)
+	GetSSHKeys(username string) ([]SSHKey, error)
+	GetSSHKeyByFingerprint(fingerprint string) (*SSHKey, error)
+	DeleteSSHKey(username string, id int) error
+	DeleteAllSSHKeys(username string) error
+	TouchSSHKey(id int) error
+}
+
+// Public key a user authenticates with over SSH, looked up by its SHA256 fingerprint
+type SSHKey struct {
+	ID          int        `json:"id"`
+	Username    string     `json:"username"`
+	Name        string     `json:"name"`
+	Fingerprint string     `json:"fingerprint"`
+	PublicKey   string     `json:"public_key"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+type PostgresSSHKeyStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+}
+
+func (pg *PostgresSSHKeyStore) CreateSSHKey(key *SSHKey) (*SSHKey, error) {
+	query :=
+		`INSERT INTO SSHKeys (username,name,fingerprint,public_key,created_at)
+		VALUES ($1,$2,$3,$4,$5) RETURNING id`
+
+	err := pg.DB.QueryRow(query, key.Username, key.Name, key.Fingerprint, key.PublicKey, key.CreatedAt).Scan(&key.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (pg *PostgresSSHKeyStore) GetSSHKeys(username string) ([]SSHKey, error) {
+	query :=
+		`SELECT id, username, name, fingerprint, public_key, created_at, last_used_at
+		FROM SSHKeys WHERE username = $1 ORDER BY created_at DESC`
+
+	rows, err := pg.DB.Query(query, username)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SSHKey{}
+	for rows.Next() {
+		key, err := scanSSHKey(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, *key)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return keys, nil
+}
+
+func (pg *PostgresSSHKeyStore) GetSSHKeyByFingerprint(fingerprint string) (*SSHKey, error) {
+	query :=
+		`SELECT id, username, name, fingerprint, public_key, created_at, last_used_at
+		FROM SSHKeys WHERE fingerprint = $1`
+
+	return scanSSHKey(pg.DB.QueryRow(query, fingerprint))
+}
+
+func (pg *PostgresSSHKeyStore) DeleteSSHKey(username string, id int) error {
+	query :=
+		`DELETE FROM SSHKeys WHERE id = $1 AND username = $2`
+
+	result, err := pg.DB.Exec(query, id, username)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteAllSSHKeys removes every key of username, having none is not an error
+func (pg *PostgresSSHKeyStore) DeleteAllSSHKeys(username string) error {
+	query :=
+		`DELETE FROM SSHKeys WHERE username = $1`
+
+	_, err := pg.DB.Exec(query, username)
+
+	return err
+}
+
+func (pg *PostgresSSHKeyStore) TouchSSHKey(id int) error {
+	query :=
+		`UPDATE SSHKeys SET last_used_at = $2 WHERE id = $1`
+
+	_, err := pg.DB.Exec(query, id, time.Now())
+
+	return err
+}
+
+func scanSSHKey(row rowScanner) (*SSHKey, error) {
+	key := &SSHKey{}
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(&key.ID, &key.Username, &key.Name, &key.Fingerprint, &key.PublicKey, &key.CreatedAt, &lastUsedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return key, nil
+}
@@ -2,33 +2,71 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
 )
 
+var ErrTokenAlreadyRotated = errors.New("Refresh Token already rotated")
+
 type TokenStore interface {
-	StoreRefreshToken(username, token string) error
+	StoreRefreshToken(username, token, family string, device Device) error
 	GetRefreshToken(token string) (*RefreshToken, error)
+	RotateRefreshToken(token, successor string, device Device) error
 	RevokeAllTokens(username string) error
 	RevokeToken(token string) error
+	RevokeTokenFamily(family string) error
+	GetSessions(username string, since time.Time) ([]Session, error)
+	RevokeSession(username, family string) error
+	RevokeOtherSessions(username, family string) error
+}
+
+// What a login or refresh request said about the client
+type Device struct {
+	UserAgent string `json:"user_agent"`
+	IPAddress string `json:"ip_address"`
+}
+
+// A signed in device, one token family seen through its live token
+type Session struct {
+	ID string `json:"id"`
+	Device
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
 }
 
+// Every refresh token belongs to the family started at login,
+// rotating a token revokes it and records the token that replaced it.
+// Only HMAC-SHA256 hashes of tokens are stored, the plaintext never reaches the database.
 type RefreshToken struct {
-	Token     string    `json:"token"`
-	Revoked   bool      `json:"revoked"`
-	CreatedAt time.Time `json:"created_at"`
-	RevokedAt time.Time `json:"revoked_at,omitempty"`
+	TokenHash  string    `json:"-"`
+	Username   string    `json:"username"`
+	Family     string    `json:"family"`
+	ReplacedBy string    `json:"-"`
+	Revoked    bool      `json:"revoked"`
+	CreatedAt  time.Time `json:"created_at"`
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+	// When the family signed in, every token of it carries the same time
+	FamilyCreatedAt time.Time `json:"family_created_at"`
+	Device
+	LastUsedAt time.Time `json:"last_used_at"`
 }
 
 type PostgresTokenStore struct {
 	DB     *sql.DB
 	Logger *slog.Logger
+	// HMAC key for refresh tokens at rest
+	Key []byte
 }
 
-func (pg *PostgresTokenStore) StoreRefreshToken(username, token string) error {
+func (pg *PostgresTokenStore) StoreRefreshToken(username, token, family string, device Device) error {
 	query :=
-		`INSERT INTO RefreshTokens (username,refreshtoken,created_at,revoked) VALUES ($1,$2,$3,$4)`
+		`INSERT INTO RefreshTokens (username,refreshtoken,family_id,created_at,revoked,user_agent,ip_address,last_used_at,family_created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$4,$4)`
 
 	tx, err := pg.DB.Begin()
 
@@ -36,7 +74,7 @@ func (pg *PostgresTokenStore) StoreRefreshToken(username, token string) error {
 		return err
 	}
 
-	_, err = tx.Exec(query, username, token, time.Now(), false)
+	_, err = tx.Exec(query, username, hashing.HashToken(pg.Key, token), family, time.Now(), false, device.UserAgent, device.IPAddress)
 
 	if err != nil {
 		return err
@@ -51,9 +89,14 @@ func (pg *PostgresTokenStore) StoreRefreshToken(username, token string) error {
 
 func (pg *PostgresTokenStore) GetRefreshToken(token string) (*RefreshToken, error) {
 	query :=
-		`SELECT refreshtoken, created_at,revoked, revoked_at FROM RefreshTokens WHERE refreshtoken = $1`
+		`SELECT refreshtoken, username, family_id, replaced_by, created_at, revoked, revoked_at, user_agent, ip_address, last_used_at, family_created_at
+		FROM RefreshTokens WHERE refreshtoken = $1`
 	refreshtoken := &RefreshToken{}
-	err := pg.DB.QueryRow(query, token).Scan(&refreshtoken)
+	var replacedBy sql.NullString
+	var revokedAt sql.NullTime
+	err := pg.DB.QueryRow(query, hashing.HashToken(pg.Key, token)).Scan(&refreshtoken.TokenHash, &refreshtoken.Username, &refreshtoken.Family,
+		&replacedBy, &refreshtoken.CreatedAt, &refreshtoken.Revoked, &revokedAt,
+		&refreshtoken.UserAgent, &refreshtoken.IPAddress, &refreshtoken.LastUsedAt, &refreshtoken.FamilyCreatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -62,6 +105,9 @@ func (pg *PostgresTokenStore) GetRefreshToken(token string) (*RefreshToken, erro
 		return nil, err
 	}
 
+	refreshtoken.ReplacedBy = replacedBy.String
+	refreshtoken.RevokedAt = revokedAt.Time
+
 	return refreshtoken, nil
 }
 
@@ -102,7 +148,38 @@ func (pg *PostgresTokenStore) RevokeAllTokens(username string) error {
 
 func (pg *PostgresTokenStore) RevokeToken(token string) error {
 	query :=
-		`UPDATE RefreshTokens WHERE token = $2 SET revoked = true`
+		`UPDATE RefreshTokens SET revoked = true, revoked_at = $2 WHERE refreshtoken = $1 AND revoked = false`
+
+	result, err := pg.DB.Exec(query, hashing.HashToken(pg.Key, token), time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RotateRefreshToken revokes token and stores successor in the same family, keeping the time the family signed in.
+// Only one caller can rotate a token, later attempts get ErrTokenAlreadyRotated.
+func (pg *PostgresTokenStore) RotateRefreshToken(token, successor string, device Device) error {
+	revokeQuery :=
+		`UPDATE RefreshTokens SET revoked = true, revoked_at = $2, replaced_by = $3
+		WHERE refreshtoken = $1 AND revoked = false
+		RETURNING username, family_id, family_created_at`
+
+	insertQuery :=
+		`INSERT INTO RefreshTokens (username,refreshtoken,family_id,created_at,revoked,user_agent,ip_address,last_used_at,family_created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$4,$8)`
 
 	tx, err := pg.DB.Begin()
 
@@ -110,11 +187,106 @@ func (pg *PostgresTokenStore) RevokeToken(token string) error {
 		return err
 	}
 
-	_, err = tx.Exec(query, token)
+	now := time.Now()
+	var username, family string
+	var familyCreatedAt time.Time
+	successorHash := hashing.HashToken(pg.Key, successor)
+	err = tx.QueryRow(revokeQuery, hashing.HashToken(pg.Key, token), now, successorHash).Scan(&username, &family, &familyCreatedAt)
+
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return ErrTokenAlreadyRotated
+		}
+		return err
+	}
+
+	_, err = tx.Exec(insertQuery, username, successorHash, family, now, false, device.UserAgent, device.IPAddress, familyCreatedAt)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresTokenStore) RevokeTokenFamily(family string) error {
+	query :=
+		`UPDATE RefreshTokens SET revoked = true, revoked_at = COALESCE(revoked_at, $2) WHERE family_id = $1`
+
+	_, err := pg.DB.Exec(query, family, time.Now())
+
+	return err
+}
+
+// GetSessions lists the families of username with a live token created after since, most recently used first
+func (pg *PostgresTokenStore) GetSessions(username string, since time.Time) ([]Session, error) {
+	query :=
+		`SELECT t.family_id, t.user_agent, t.ip_address, t.last_used_at, t.family_created_at
+		FROM RefreshTokens AS t
+		WHERE t.username = $1 AND t.revoked = false AND t.created_at > $2
+		ORDER BY t.last_used_at DESC`
+
+	rows, err := pg.DB.Query(query, username, since)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+
+		err = rows.Scan(&session.ID, &session.UserAgent, &session.IPAddress, &session.LastUsedAt, &session.CreatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession signs one device of username out, sql.ErrNoRows if it has no live token
+func (pg *PostgresTokenStore) RevokeSession(username, family string) error {
+	query :=
+		`UPDATE RefreshTokens SET revoked = true, revoked_at = $3
+		WHERE username = $1 AND family_id = $2 AND revoked = false`
+
+	result, err := pg.DB.Exec(query, username, family, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
 
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
+
+// RevokeOtherSessions signs every device of username out except the one of family
+func (pg *PostgresTokenStore) RevokeOtherSessions(username, family string) error {
+	query :=
+		`UPDATE RefreshTokens SET revoked = true, revoked_at = $3
+		WHERE username = $1 AND family_id <> $2 AND revoked = false`
+
+	_, err := pg.DB.Exec(query, username, family, time.Now())
+
+	return err
+}
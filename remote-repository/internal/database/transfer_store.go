@@ -0,0 +1,134 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Pending move of a repository to another user or organization, see RepoStore.MoveRepo
+type Transfer struct {
+	ID          int       `json:"id"`
+	RepoOwner   string    `json:"repo_owner"`
+	RepoName    string    `json:"repo_name"`
+	Recipient   string    `json:"recipient"`
+	RequestedBy string    `json:"requested_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type TransferStore interface {
+	CreateTransfer(transfer *Transfer) (*Transfer, error)
+	GetTransfer(id int) (*Transfer, error)
+	GetIncomingTransfers(username string) ([]Transfer, error)
+	CancelTransfer(username, reponame string) (*Transfer, error)
+	DeclineTransfer(id int) error
+}
+
+type PostgresTransferStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+}
+
+const transferColumns = `t.id, t.repoOwner, t.repoName, t.recipient, t.requestedBy, t.createdAt, t.expiresAt`
+
+// CreateTransfer replaces a transfer of the same repository that is still pending
+func (pg *PostgresTransferStore) CreateTransfer(transfer *Transfer) (*Transfer, error) {
+	query :=
+		`INSERT INTO RepositoryTransfers (repoOwner, repoName, recipient, requestedBy, createdAt, expiresAt)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		ON CONFLICT (repoOwner, repoName) DO UPDATE SET
+			recipient = EXCLUDED.recipient, requestedBy = EXCLUDED.requestedBy, createdAt = EXCLUDED.createdAt, expiresAt = EXCLUDED.expiresAt
+		RETURNING id`
+
+	err := pg.DB.QueryRow(query, transfer.RepoOwner, transfer.RepoName, transfer.Recipient, transfer.RequestedBy,
+		transfer.CreatedAt, transfer.ExpiresAt).Scan(&transfer.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// GetTransfer is a pending transfer, sql.ErrNoRows once it expired
+func (pg *PostgresTransferStore) GetTransfer(id int) (*Transfer, error) {
+	query :=
+		`SELECT ` + transferColumns + ` FROM RepositoryTransfers AS t WHERE t.id = $1 AND t.expiresAt > $2`
+
+	return scanTransfer(pg.DB.QueryRow(query, id, time.Now()))
+}
+
+// GetIncomingTransfers lists pending transfers to username and to the organizations username owns
+func (pg *PostgresTransferStore) GetIncomingTransfers(username string) ([]Transfer, error) {
+	query :=
+		`SELECT ` + transferColumns + ` FROM RepositoryTransfers AS t
+		WHERE t.expiresAt > $2 AND (t.recipient = $1 OR EXISTS (
+			SELECT 1 FROM OrganizationMembers AS om WHERE om.org = t.recipient AND om.username = $1 AND om.role = 'owner'
+		))
+		ORDER BY t.createdAt DESC`
+
+	rows, err := pg.DB.Query(query, username, time.Now())
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []Transfer{}
+	for rows.Next() {
+		transfer, err := scanTransfer(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transfers = append(transfers, *transfer)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return transfers, nil
+}
+
+func (pg *PostgresTransferStore) CancelTransfer(username, reponame string) (*Transfer, error) {
+	query :=
+		`DELETE FROM RepositoryTransfers AS t WHERE t.repoOwner = $1 AND t.repoName = $2 RETURNING ` + transferColumns
+
+	return scanTransfer(pg.DB.QueryRow(query, username, reponame))
+}
+
+func (pg *PostgresTransferStore) DeclineTransfer(id int) error {
+	result, err := pg.DB.Exec(`DELETE FROM RepositoryTransfers WHERE id = $1`, id)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func scanTransfer(row rowScanner) (*Transfer, error) {
+	transfer := &Transfer{}
+
+	err := row.Scan(&transfer.ID, &transfer.RepoOwner, &transfer.RepoName, &transfer.Recipient, &transfer.RequestedBy,
+		&transfer.CreatedAt, &transfer.ExpiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/sealing"
+)
+
+type TwoFactorStore interface {
+	GetTwoFactor(username string) (*TwoFactor, error)
+	SetPendingSecret(username, secret string) error
+	EnableTwoFactor(username string, recoveryCodes []string) error
+	DisableTwoFactor(username string) error
+	ConsumeStep(username string, step int64) (bool, error)
+	ReplaceRecoveryCodes(username string, recoveryCodes []string) error
+	ConsumeRecoveryCode(username, recoveryCode string) (bool, error)
+}
+
+// TOTP state of a user, Secret is set once enrollment starts and Enabled once a code confirmed it
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+	// Recovery codes that were not used yet
+	RecoveryCodesLeft int
+	// Owners of private repositories from before two factor was required for them can go without it until then
+	GraceUntil time.Time
+}
+
+type PostgresTwoFactorStore struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+	// HMAC key for recovery codes at rest, TOTP secrets are sealed with a key derived from it
+	Key []byte
+}
+
+// secretKey seals TOTP secrets, they have to be read back so they cannot be hashed like recovery codes
+func (pg *PostgresTwoFactorStore) secretKey() []byte {
+	return sealing.Key(pg.Key, "totp_secret")
+}
+
+func (pg *PostgresTwoFactorStore) GetTwoFactor(username string) (*TwoFactor, error) {
+	query :=
+		`SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step,
+		(SELECT COUNT(*) FROM RecoveryCodes WHERE username = $1 AND used_at IS NULL), two_factor_grace_until
+		FROM Users WHERE username = $1`
+
+	twoFactor := &TwoFactor{}
+	var graceUntil sql.NullTime
+	err := pg.DB.QueryRow(query, username).Scan(&twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep, &twoFactor.RecoveryCodesLeft, &graceUntil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor.GraceUntil = graceUntil.Time
+
+	// Secrets stored before sealing are read as they are until SealSecrets ran
+	if sealing.IsSealed(twoFactor.Secret) {
+		if twoFactor.Secret, err = sealing.Open(pg.secretKey(), twoFactor.Secret, username); err != nil {
+			return nil, err
+		}
+	}
+
+	return twoFactor, nil
+}
+
+// SetPendingSecret starts or restarts enrollment, it never replaces the secret of an enabled user
+func (pg *PostgresTwoFactorStore) SetPendingSecret(username, secret string) error {
+	query :=
+		`UPDATE Users SET totp_secret = $2, totp_last_step = 0 WHERE username = $1 AND totp_enabled = false`
+
+	sealed, err := sealing.Seal(pg.secretKey(), secret, username)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := pg.DB.Exec(query, username, sealed)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (pg *PostgresTwoFactorStore) EnableTwoFactor(username string, recoveryCodes []string) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE Users SET totp_enabled = true WHERE username = $1 AND totp_secret IS NOT NULL`, username)
+
+	if err != nil {
+		return err
+	}
+
+	if err = pg.replaceRecoveryCodes(tx, username, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresTwoFactorStore) DisableTwoFactor(username string) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE Users SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0 WHERE username = $1`, username)
+
+	if err != nil {
+		return err
+	}
+
+	if err = pg.replaceRecoveryCodes(tx, username, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeStep records step as used, false means it or a later step was already accepted
+func (pg *PostgresTwoFactorStore) ConsumeStep(username string, step int64) (bool, error) {
+	query :=
+		`UPDATE Users SET totp_last_step = $2 WHERE username = $1 AND totp_last_step < $2`
+
+	result, err := pg.DB.Exec(query, username, step)
+
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (pg *PostgresTwoFactorStore) ReplaceRecoveryCodes(username string, recoveryCodes []string) error {
+	tx, err := pg.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = pg.replaceRecoveryCodes(tx, username, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeRecoveryCode marks an unused recovery code as used, false means there was none
+func (pg *PostgresTwoFactorStore) ConsumeRecoveryCode(username, recoveryCode string) (bool, error) {
+	query :=
+		`UPDATE RecoveryCodes SET used_at = $3 WHERE username = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := pg.DB.Exec(query, username, hashing.HashToken(pg.Key, recoveryCode), time.Now())
+
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (pg *PostgresTwoFactorStore) replaceRecoveryCodes(tx *sql.Tx, username string, recoveryCodes []string) error {
+	_, err := tx.Exec(`DELETE FROM RecoveryCodes WHERE username = $1`, username)
+
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(`INSERT INTO RecoveryCodes (username, code_hash) VALUES ($1, $2)`, username, hashing.HashToken(pg.Key, code))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SealSecrets seals the TOTP secrets still stored in plaintext, it runs on start
+func (pg *PostgresTwoFactorStore) SealSecrets() error {
+	query :=
+		`SELECT username, totp_secret FROM Users WHERE totp_secret IS NOT NULL AND totp_secret NOT LIKE 'v1:%'`
+
+	rows, err := pg.DB.Query(query)
+
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	plaintext := map[string]string{}
+	for rows.Next() {
+		var username, secret string
+
+		if err = rows.Scan(&username, &secret); err != nil {
+			return err
+		}
+
+		plaintext[username] = secret
+	}
+
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for username, secret := range plaintext {
+		sealed, err := sealing.Seal(pg.secretKey(), secret, username)
+
+		if err != nil {
+			return err
+		}
+
+		// Skipped when the user enrolled again meanwhile
+		_, err = pg.DB.Exec(`UPDATE Users SET totp_secret = $3 WHERE username = $1 AND totp_secret = $2`, username, secret, sealed)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plaintext) > 0 {
+		pg.Logger.Info("Sealed plaintext TOTP secrets", "count", len(plaintext))
+	}
+	return nil
+}
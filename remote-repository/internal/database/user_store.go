@@ -3,6 +3,15 @@ package database
 import (
 	"database/sql"
 	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
+)
+
+const (
+	AccountUser         = "user"
+	AccountOrganization = "organization"
 )
 
 type User struct {
@@ -11,17 +20,33 @@ type User struct {
 	FullName     string `json:"full_name"`
 	Bio          string `json:"bio,omitempty"`
 	EmailAddress string `json:"email"`
+	// Unverified accounts are limited, see middleware.RequireVerifiedEmail
+	EmailVerified bool `json:"email_verified"`
+	// Site administrator, see middleware.RequireAdmin
+	IsAdmin bool `json:"is_admin,omitempty"`
+	// Suspended accounts fail authentication, see middleware.CheckSuspended
+	Suspended       bool   `json:"suspended,omitempty"`
+	SuspendedReason string `json:"suspended_reason,omitempty"`
+	// Organizations have no password or email address, see OrgStore
+	AccountType string `json:"account_type"`
 }
 
 type UserProfile struct {
-	Username      string   `json:"username"`               // Both
-	FullName      string   `json:"full_name"`              // Both
-	Bio           string   `json:"bio,omitempty"`          // Both
-	EmailAddress  string   `json:"email"`                  // Both
-	Repos         []string `json:"repositories,omitempty"` // All repos if self, only public if visitor
-	ReposCount    int      `json:"repo_count,omitempty"`   // Self
-	TotalCommits  int      `json:"commit_count,omitempty"` // Self
-	TopRepository string   `json:"top_repo,omitempty"`     // Self
+	Username             string            `json:"username"`                        // Both
+	AccountType          string            `json:"account_type"`                    // Both, user or organization
+	FullName             string            `json:"full_name"`                       // Both
+	Bio                  string            `json:"bio,omitempty"`                   // Both
+	EmailAddress         string            `json:"email"`                           // Both
+	Repos                []string          `json:"repositories,omitempty"`          // All repos if self, only public if visitor
+	ReposCount           int               `json:"repo_count,omitempty"`            // Counted over the repos above
+	TotalCommits         int               `json:"commit_count,omitempty"`          // Counted over the repos above
+	TopRepository        string            `json:"top_repo,omitempty"`              // Counted over the repos above
+	ContributionCalendar []ContributionDay `json:"contribution_calendar,omitempty"` // Commits per day over the last year
+}
+
+type ContributionDay struct {
+	Date    string `json:"date"`
+	Commits int    `json:"commits"`
 }
 
 type UserStore interface {
@@ -33,11 +58,22 @@ type UserStore interface {
 
 	GetUserSelfProfile(username string) (*UserProfile, error)
 	GetUserProfile(username string) (*UserProfile, error)
+
+	GetUsernameByIdentity(provider, subject string) (string, error)
+	LinkIdentity(provider, subject, username, email string) error
+
+	SetEmailVerified(username, email string) error
+	UpdatePassword(username, passwordHash string) error
+
+	SearchUsers(query string, limit, offset int) ([]User, error)
+	SetSuspended(username string, suspended bool, reason string) error
 }
 
 type PostgresUserStore struct {
 	DB     *sql.DB
 	Logger *slog.Logger
+	// HMAC key refresh tokens are stored with, see PostgresTokenStore
+	TokenKey []byte
 }
 
 func (pg *PostgresUserStore) DeleteUser(username string) error {
@@ -67,10 +103,10 @@ func (pg *PostgresUserStore) CreateUser(user *User) (*User, error) {
 
 	query :=
 		`INSERT INTO Users
-	(username,fullname, password_hash, bio, email_address)
-	VALUES ($1,$2,$3,$4,$5);`
+	(username,fullname, password_hash, bio, email_address, email_verified)
+	VALUES ($1,$2,$3,$4,$5,$6);`
 
-	_, err = tx.Exec(query, user.Username, user.FullName, user.PasswordHash, user.Bio, user.EmailAddress)
+	_, err = tx.Exec(query, user.Username, user.FullName, user.PasswordHash, user.Bio, user.EmailAddress, user.EmailVerified)
 
 	if err != nil {
 		return nil, err
@@ -89,9 +125,9 @@ func (pg *PostgresUserStore) GetUserbyUsername(username string) (*User, error) {
 	user := &User{}
 
 	query :=
-		`SELECT * FROM Users WHERE username = $1`
+		`SELECT username, fullname, password_hash, bio, COALESCE(email_address, ''), email_verified, is_admin, suspended, suspended_reason, account_type FROM Users WHERE username = $1`
 
-	err := pg.DB.QueryRow(query, username).Scan(&user.Username, &user.FullName, &user.PasswordHash, &user.Bio, &user.EmailAddress)
+	err := pg.DB.QueryRow(query, username).Scan(&user.Username, &user.FullName, &user.PasswordHash, &user.Bio, &user.EmailAddress, &user.EmailVerified, &user.IsAdmin, &user.Suspended, &user.SuspendedReason, &user.AccountType)
 
 	if err != nil {
 		return nil, err
@@ -104,9 +140,9 @@ func (pg *PostgresUserStore) GetUserbyEmailAddress(email string) (*User, error)
 	user := &User{}
 
 	query :=
-		`SELECT * FROM Users WHERE email_address = $1`
+		`SELECT username, fullname, password_hash, bio, COALESCE(email_address, ''), email_verified, is_admin, suspended, suspended_reason, account_type FROM Users WHERE email_address = $1`
 
-	err := pg.DB.QueryRow(query, email).Scan(&user.Username, &user.FullName, &user.PasswordHash, &user.Bio, &user.EmailAddress)
+	err := pg.DB.QueryRow(query, email).Scan(&user.Username, &user.FullName, &user.PasswordHash, &user.Bio, &user.EmailAddress, &user.EmailVerified, &user.IsAdmin, &user.Suspended, &user.SuspendedReason, &user.AccountType)
 
 	if err != nil {
 		return nil, err
@@ -119,7 +155,7 @@ func (pg *PostgresUserStore) GetUserByToken(token string) (string, error) {
 	query :=
 		`SELECT username FROM RefreshTokens WHERE refreshtoken = $1`
 	var username string
-	err := pg.DB.QueryRow(query, token).Scan(&username)
+	err := pg.DB.QueryRow(query, hashing.HashToken(pg.TokenKey, token)).Scan(&username)
 
 	if err != nil {
 		return "", err
@@ -129,9 +165,252 @@ func (pg *PostgresUserStore) GetUserByToken(token string) (string, error) {
 }
 
 func (pg *PostgresUserStore) GetUserSelfProfile(username string) (*UserProfile, error) {
-	return nil,nil
+	return pg.getProfile(username, true)
 }
 
 func (pg *PostgresUserStore) GetUserProfile(username string) (*UserProfile, error) {
-	return nil,nil
+	return pg.getProfile(username, false)
+}
+
+// Repositories the user owns or contributes to, private ones only if includePrivate is set
+const profileReposQuery = `
+	SELECT r.repoOwner, r.repoName FROM Repository AS r
+	WHERE (r.privacy = 'PUBLIC' OR $2) AND (r.repoOwner = $1 OR EXISTS (
+		SELECT 1 FROM RepositoryUsers AS ru
+		WHERE ru.repoOwner = r.repoOwner AND ru.repoName = r.repoName AND ru.contributor = $1
+	))`
+
+func (pg *PostgresUserStore) getProfile(username string, includePrivate bool) (*UserProfile, error) {
+	user, err := pg.GetUserbyUsername(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &UserProfile{
+		Username:     user.Username,
+		AccountType:  user.AccountType,
+		FullName:     user.FullName,
+		Bio:          user.Bio,
+		EmailAddress: user.EmailAddress,
+	}
+
+	rows, err := pg.DB.Query(profileReposQuery+` ORDER BY r.repoOwner, r.repoName`, username, includePrivate)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var repoOwner, repoName string
+
+		if err = rows.Scan(&repoOwner, &repoName); err != nil {
+			return nil, err
+		}
+
+		profile.Repos = append(profile.Repos, repoOwner+"/"+repoName)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	profile.ReposCount = len(profile.Repos)
+
+	// Commits are stored once per branch they are on, so they are counted by hash
+	commitsQuery :=
+		`SELECT c.repoOwner, c.repoName, COUNT(DISTINCT c.commitHash) FROM Commit AS c
+		WHERE c.author = $1 AND (c.repoOwner, c.repoName) IN (` + profileReposQuery + `)
+		GROUP BY c.repoOwner, c.repoName
+		ORDER BY COUNT(DISTINCT c.commitHash) DESC, c.repoOwner, c.repoName`
+
+	commits, err := pg.DB.Query(commitsQuery, username, includePrivate)
+
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	for commits.Next() {
+		var repoOwner, repoName string
+		var count int
+
+		if err = commits.Scan(&repoOwner, &repoName, &count); err != nil {
+			return nil, err
+		}
+
+		if profile.TopRepository == "" {
+			profile.TopRepository = repoOwner + "/" + repoName
+		}
+		profile.TotalCommits += count
+	}
+
+	if commits.Err() != nil {
+		return nil, commits.Err()
+	}
+
+	calendarQuery :=
+		`SELECT to_char(day, 'YYYY-MM-DD'), COUNT(*) FROM (
+			SELECT DISTINCT c.repoOwner, c.repoName, c.commitHash, date_trunc('day', c.commitTime) AS day FROM Commit AS c
+			WHERE c.author = $1 AND c.commitTime >= now() - INTERVAL '1 year'
+			AND (c.repoOwner, c.repoName) IN (` + profileReposQuery + `)
+		) AS authored
+		GROUP BY day
+		ORDER BY day`
+
+	days, err := pg.DB.Query(calendarQuery, username, includePrivate)
+
+	if err != nil {
+		return nil, err
+	}
+	defer days.Close()
+
+	for days.Next() {
+		var day ContributionDay
+
+		if err = days.Scan(&day.Date, &day.Commits); err != nil {
+			return nil, err
+		}
+
+		profile.ContributionCalendar = append(profile.ContributionCalendar, day)
+	}
+
+	if days.Err() != nil {
+		return nil, days.Err()
+	}
+
+	return profile, nil
+}
+
+func (pg *PostgresUserStore) GetUsernameByIdentity(provider, subject string) (string, error) {
+	query :=
+		`SELECT username FROM OAuthIdentities WHERE provider = $1 AND subject = $2`
+
+	var username string
+	err := pg.DB.QueryRow(query, provider, subject).Scan(&username)
+
+	if err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+func (pg *PostgresUserStore) LinkIdentity(provider, subject, username, email string) error {
+	query :=
+		`INSERT INTO OAuthIdentities (provider,subject,username,email_address,created_at) VALUES ($1,$2,$3,$4,$5)`
+
+	_, err := pg.DB.Exec(query, provider, subject, username, email, time.Now())
+
+	return err
+}
+
+// SetEmailVerified only succeeds while email is still the address of the account
+func (pg *PostgresUserStore) SetEmailVerified(username, email string) error {
+	query :=
+		`UPDATE Users SET email_verified = true WHERE username = $1 AND email_address = $2`
+
+	result, err := pg.DB.Exec(query, username, email)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (pg *PostgresUserStore) UpdatePassword(username, passwordHash string) error {
+	query :=
+		`UPDATE Users SET password_hash = $2 WHERE username = $1`
+
+	result, err := pg.DB.Exec(query, username, passwordHash)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SearchUsers matches query against usernames, names and email addresses, an empty query lists everyone.
+// Password hashes are left out.
+func (pg *PostgresUserStore) SearchUsers(query string, limit, offset int) ([]User, error) {
+	rows, err := pg.DB.Query(
+		`SELECT username, fullname, COALESCE(bio, ''), COALESCE(email_address, ''), email_verified, is_admin, suspended, suspended_reason, account_type FROM Users
+		WHERE $1 = '' OR username ILIKE '%' || $1 || '%' OR fullname ILIKE '%' || $1 || '%' OR email_address ILIKE '%' || $1 || '%'
+		ORDER BY username LIMIT $2 OFFSET $3`, escapeLike(query), limit, offset)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+
+		err = rows.Scan(&user.Username, &user.FullName, &user.Bio, &user.EmailAddress, &user.EmailVerified, &user.IsAdmin,
+			&user.Suspended, &user.SuspendedReason, &user.AccountType)
+
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return users, nil
+}
+
+func (pg *PostgresUserStore) SetSuspended(username string, suspended bool, reason string) error {
+	query :=
+		`UPDATE Users SET suspended = $2, suspended_reason = $3 WHERE username = $1`
+
+	result, err := pg.DB.Exec(query, username, suspended, reason)
+
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// escapeLike makes the wildcards of a user supplied LIKE pattern match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RequestTimeout bounds reading the body and writing the response of ordinary requests
+	RequestTimeout = 30 * time.Second
+	// TransferTimeout is for pushes, pulls and archives, which may carry up to a gigabyte
+	TransferTimeout = 30 * time.Minute
+)
+
+// Deadline limits how long the rest of the request may take to read and to answer.
+// The server sets no read or write timeout of its own so routes can allow more than the default,
+// a later Deadline on a route replaces the one applied to every request.
+func Deadline(timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		deadline := time.Now().Add(timeout)
+		rc := http.NewResponseController(ctx.Writer)
+
+		// Test recorders support neither, a real connection supports both
+		_ = rc.SetReadDeadline(deadline)
+		_ = rc.SetWriteDeadline(deadline)
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/cache"
+)
+
+var ErrDenylistUnavailable = errors.New("Error checking whether the token was revoked")
+
+// Context keys holding the jti and expiry of the JWT a request was made with
+const (
+	tokenIDKey     = "TOKEN_ID"
+	tokenExpiryKey = "TOKEN_EXPIRES"
+)
+
+// Denylist keys, an entry lives as long as the tokens it denies could
+const (
+	deniedTokenPrefix   = "denied:jti:"
+	deniedSessionPrefix = "denied:sid:"
+)
+
+// DenyAccessToken rejects the JWT with this jti until it expires on its own
+func (am *AuthenticationMiddleware) DenyAccessToken(id string, expires time.Time) error {
+	ttl := time.Until(expires)
+	if id == "" || ttl <= 0 {
+		return nil
+	}
+	return am.Denylist.Set(deniedTokenPrefix+id, "1", ttl)
+}
+
+// DenySession rejects every JWT issued for session, none of them outlives the access token timeout
+func (am *AuthenticationMiddleware) DenySession(session string) error {
+	if session == "" {
+		return nil
+	}
+	return am.Denylist.Set(deniedSessionPrefix+session, "1", am.Timeout)
+}
+
+// ExtractAccessTokenFromContext is empty for requests made with a personal access token
+func (am *AuthenticationMiddleware) ExtractAccessTokenFromContext(c *gin.Context) (string, time.Time) {
+	return c.GetString(tokenIDKey), c.GetTime(tokenExpiryKey)
+}
+
+func (am *AuthenticationMiddleware) isDenied(claims jwt.MapClaims) (bool, error) {
+	if id, _ := claims["jti"].(string); id != "" {
+		denied, err := am.denied(deniedTokenPrefix + id)
+		if denied || err != nil {
+			return denied, err
+		}
+	}
+
+	if session, _ := claims["sid"].(string); session != "" {
+		return am.denied(deniedSessionPrefix + session)
+	}
+	return false, nil
+}
+
+func (am *AuthenticationMiddleware) denied(key string) (bool, error) {
+	_, err := am.Denylist.Get(key)
+
+	if errors.Is(err, cache.ErrMiss) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
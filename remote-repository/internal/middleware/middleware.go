@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"errors"
 	"log/slog"
@@ -14,6 +15,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/cache"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/signing"
 )
 
 var (
@@ -23,30 +26,58 @@ var (
 	ErrInvalidUsernameType        = errors.New("Error invalid username type")
 	ErrMissingAuthorizationHeader = errors.New("Error Missing Authorizaion Header")
 	ErrMissingBearerPrefix        = errors.New("Missing Bearer Prefix")
+	ErrRevokedToken               = errors.New("Error Revoked Token")
+	ErrRepoNotFound               = errors.New("Repository Not Found")
+	ErrRepoAccessDenied           = errors.New("Your role on this repository does not allow this")
+	ErrTwoFactorRequired          = errors.New("Two factor authentication is required for this repository")
+	ErrEmailNotVerified           = errors.New("Verify your email address first")
+	ErrAccountSuspended           = errors.New("Account Suspended")
 )
 
 type AuthenticationMiddleware struct {
-	TokenStore database.TokenStore
-	RepoStore  database.RepoStore
-
-	JWTSecret   string
-	Timeout     time.Duration
-	MaxRefresh  time.Duration
+	UserStore        database.UserStore
+	TokenStore       database.TokenStore
+	RepoStore        database.RepoStore
+	AccessTokenStore database.AccessTokenStore
+	TwoFactorStore   database.TwoFactorStore
+	OrgStore         database.OrgStore
+	// Nonces of requests signed with a repository secret, shared by every instance with Redis
+	Nonces cache.Cache
+	// Access tokens and sessions signed out before their tokens expire
+	Denylist cache.Cache
+
+	// Asymmetric keys access tokens are signed and verified with
+	Keys *signing.KeySet
+	// iss claim of issued tokens, checked on verification
+	Issuer     string
+	Timeout    time.Duration
+	MaxRefresh time.Duration
+	// How long a session lasts from sign in, however often its refresh token is rotated
+	MaxSession  time.Duration
 	Logger      *slog.Logger
 	IdentityKey string
 }
 
+// Context key holding the session (refresh token family) a JWT was issued for
+const sessionKey = "SESSION"
+
 // Generation
-func (am *AuthenticationMiddleware) GenerateJWTToken(data string) (string, error) {
+func (am *AuthenticationMiddleware) GenerateJWTToken(data, session string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
 	claims := jwt.MapClaims{
 		am.IdentityKey: data,
+		"jti":          base64.RawURLEncoding.EncodeToString(id),
+		"sid":          session,
+		"iss":          am.Issuer,
 		"exp":          time.Now().Add(am.Timeout).Unix(),
 		"iat":          time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString([]byte(am.JWTSecret))
+	tokenString, err := am.Keys.Sign(claims)
 
 	if err != nil {
 		return "", err
@@ -64,28 +95,30 @@ func (am *AuthenticationMiddleware) GenerateAccessToken(username string) (string
 }
 
 // Token Validation
-func (am *AuthenticationMiddleware) ValidateJWTToken(c *gin.Context) (jwt.MapClaims, error) {
-	var token string
-	var err error
+func ExtractBearerToken(c *gin.Context) (string, error) {
 	authHeader := c.GetHeader("Authorization")
 	if authHeader == "" {
-		return nil, ErrMissingAuthorizationHeader
+		return "", ErrMissingAuthorizationHeader
 	}
 	const Prefix = "Bearer "
 
 	if !strings.HasPrefix(authHeader, Prefix) {
-		return nil, ErrMissingBearerPrefix
+		return "", ErrMissingBearerPrefix
 	}
 
-	token = strings.TrimPrefix(authHeader, Prefix)
+	return strings.TrimPrefix(authHeader, Prefix), nil
+}
+
+func (am *AuthenticationMiddleware) ValidateJWTToken(c *gin.Context) (jwt.MapClaims, error) {
+	token, err := ExtractBearerToken(c)
+	if err != nil {
+		return nil, err
+	}
 
 	jwtToken, err := jwt.Parse(token, func(t *jwt.Token) (any, error) {
-		if t.Method != jwt.SigningMethodHS256 {
-			return nil, jwt.ErrSignatureInvalid
-		}
 		c.Set("JWT_TOKEN", token)
-		return []byte(am.JWTSecret), nil
-	})
+		return am.Keys.Keyfunc(t)
+	}, jwt.WithValidMethods(am.Keys.Methods()), jwt.WithIssuer(am.Issuer))
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -94,10 +127,47 @@ func (am *AuthenticationMiddleware) ValidateJWTToken(c *gin.Context) (jwt.MapCla
 		return nil, err
 	}
 
-	if claims, ok := jwtToken.Claims.(jwt.MapClaims); ok && jwtToken.Valid {
-		return claims, nil
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok || !jwtToken.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	denied, err := am.isDenied(claims)
+
+	if err != nil {
+		am.Logger.Error("Error checking the token denylist", "error", err)
+		return nil, ErrDenylistUnavailable
+	}
+
+	if denied {
+		return nil, ErrRevokedToken
+	}
+	return claims, nil
+}
+
+func (am *AuthenticationMiddleware) ValidatePersonalAccessToken(token string) (*database.AccessToken, error) {
+	accessToken, err := am.AccessTokenStore.GetAccessToken(token)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if accessToken.Revoked {
+		return nil, ErrRevokedToken
 	}
-	return nil, ErrInvalidToken
+
+	if time.Now().After(accessToken.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+
+	if err = am.AccessTokenStore.TouchAccessToken(accessToken.ID); err != nil {
+		am.Logger.Error("Error recording access token use", "error", err)
+	}
+
+	return accessToken, nil
 }
 
 func (am *AuthenticationMiddleware) ValidateRefreshToken(token string) (bool, error) {
@@ -127,6 +197,26 @@ func (am *AuthenticationMiddleware) RevokeRefreshToken(username string) error {
 // Middleware
 func (am *AuthenticationMiddleware) Autheticate() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if token, err := ExtractBearerToken(ctx); err == nil && strings.HasPrefix(token, PersonalAccessTokenPrefix) {
+			accessToken, err := am.ValidatePersonalAccessToken(token)
+
+			if err != nil {
+				am.Logger.Error("Error Authenticating")
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err = am.CheckSuspended(accessToken.Username); err != nil {
+				am.abortSuspended(ctx, err)
+				return
+			}
+
+			ctx.Set(am.IdentityKey, accessToken.Username)
+			ctx.Set(scopesKey, accessToken.Scopes)
+			ctx.Next()
+			return
+		}
+
 		claims, err := am.ValidateJWTToken(ctx)
 
 		if err != nil {
@@ -135,7 +225,22 @@ func (am *AuthenticationMiddleware) Autheticate() gin.HandlerFunc {
 			return
 		}
 
+		username, _ := claims[am.IdentityKey].(string)
+		if err = am.CheckSuspended(username); err != nil {
+			am.abortSuspended(ctx, err)
+			return
+		}
+
 		ctx.Set(am.IdentityKey, claims[am.IdentityKey])
+		if session, ok := claims["sid"].(string); ok {
+			ctx.Set(sessionKey, session)
+		}
+		if id, ok := claims["jti"].(string); ok {
+			ctx.Set(tokenIDKey, id)
+		}
+		if expires, err := claims.GetExpirationTime(); err == nil && expires != nil {
+			ctx.Set(tokenExpiryKey, expires.Time)
+		}
 		ctx.Next()
 	}
 }
@@ -154,6 +259,11 @@ func (am *AuthenticationMiddleware) ExtractUserFromContext(c *gin.Context) (stri
 	return username, nil
 }
 
+// ExtractSessionFromContext is empty for requests made with a personal access token
+func (am *AuthenticationMiddleware) ExtractSessionFromContext(c *gin.Context) string {
+	return c.GetString(sessionKey)
+}
+
 func ExtractRefreshTokenFromContext(c *gin.Context) (*models.LogoutRequest, error) {
 	Refresh := &models.LogoutRequest{}
 	err := c.BindJSON(&Refresh)
@@ -166,36 +276,64 @@ func ExtractRefreshTokenFromContext(c *gin.Context) (*models.LogoutRequest, erro
 	return Refresh, nil
 }
 
-func (am *AuthenticationMiddleware) AuthorizePrivacy() gin.HandlerFunc {
+// CheckSuspended fails with ErrAccountSuspended for suspended accounts, unknown users are left to the caller
+func (am *AuthenticationMiddleware) CheckSuspended(username string) error {
+	user, err := am.UserStore.GetUserbyUsername(username)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Suspended {
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+func (am *AuthenticationMiddleware) abortSuspended(ctx *gin.Context, err error) {
+	if err == ErrAccountSuspended {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	am.Logger.Error("Error checking account suspension", "error", err)
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+}
+
+// RequireAdmin lets only site administrators through
+func (am *AuthenticationMiddleware) RequireAdmin() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		user := ctx.Param("username")
+		currentUser, err := am.ExtractUserFromContext(ctx)
 
-		if user == "" {
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no username was specified in url"})
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUsernameNotInContext})
 			return
 		}
 
-		repo := ctx.Param("reponame")
+		user, err := am.UserStore.GetUserbyUsername(currentUser)
 
-		if repo == "" {
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no repository name was specified in url"})
+		if err != nil {
+			if err == sql.ErrNoRows {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			return
 		}
 
-		privacy, err := am.RepoStore.GetRepoPrivacy(user, repo)
-
-		if err != nil {
-			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+		if !user.IsAdmin {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "administrators only"})
 			return
 		}
-		ctx.Set("REPOOWNER", user)
-		ctx.Set("REPONAME", repo)
-		ctx.Set("PRIVACY", privacy)
+
 		ctx.Next()
 	}
 }
 
-func (am *AuthenticationMiddleware) AuthorizeEditAccess() gin.HandlerFunc {
+// RequireVerifiedEmail keeps accounts that did not confirm their email address from creating or changing anything shared
+func (am *AuthenticationMiddleware) RequireVerifiedEmail() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		currentUser, err := am.ExtractUserFromContext(ctx)
 
@@ -204,6 +342,28 @@ func (am *AuthenticationMiddleware) AuthorizeEditAccess() gin.HandlerFunc {
 			return
 		}
 
+		user, err := am.UserStore.GetUserbyUsername(currentUser)
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if !user.EmailVerified {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "verify your email address first, a new link can be requested from /auth/verify-email"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+func (am *AuthenticationMiddleware) AuthorizePrivacy() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
 		user := ctx.Param("username")
 
 		if user == "" {
@@ -218,57 +378,183 @@ func (am *AuthenticationMiddleware) AuthorizeEditAccess() gin.HandlerFunc {
 			return
 		}
 
-		authorized, err := am.RepoStore.GetAccessStatusOnRepo(user, repo, currentUser)
+		privacy, err := am.RepoStore.GetRepoPrivacy(user, repo)
 
 		if err != nil {
-			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-			return
-		}
+			currentUser, _ := am.ExtractUserFromContext(ctx)
+			newOwner, newName, newPrivacy, moved := am.visibleMove(user, repo, currentUser)
 
-		if authorized {
-			ctx.Set("CONTRIBUTOR", true)
-		} else {
-			ctx.Set("CONTRIBUTOR", false)
-		}
+			if !moved {
+				ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+				return
+			}
+
+			if !servedInPlace[ctx.FullPath()] {
+				redirectMovedRepo(ctx, user, repo, newOwner, newName)
+				return
+			}
 
+			user, repo, privacy = newOwner, newName, newPrivacy
+			ctx.Header(HeaderRepoMoved, user+"/"+repo)
+		}
 		ctx.Set("REPOOWNER", user)
 		ctx.Set("REPONAME", repo)
+		ctx.Set("PRIVACY", privacy)
 		ctx.Next()
 	}
 }
 
-func (am *AuthenticationMiddleware) AuthorizeOwnership() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		currentUser, err := am.ExtractUserFromContext(ctx)
+// Header telling remotes served from an old address where the repository lives now
+const HeaderRepoMoved = "X-Jit-Repository-Moved"
 
-		if err != nil {
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUsernameNotInContext})
-			return
-		}
+// Routes of remotes, they sign the address they were set up with and do not follow redirects,
+// so requests to an old address are served in place
+var servedInPlace = map[string]bool{
+	"/:username/repo/:reponame/remote": true,
+	"/:username/repo/:reponame/push":   true,
+	"/:username/repo/:reponame/pull":   true,
+}
 
-		user := ctx.Param("username")
+// ResolveRepo is where user/repo lives, the new location when the repository was transferred or renamed away from it
+// and currentUser can see it there
+func (am *AuthenticationMiddleware) ResolveRepo(user, repo, currentUser string) (string, string) {
+	if _, err := am.RepoStore.GetRepoPrivacy(user, repo); err == nil {
+		return user, repo
+	}
 
-		if user == "" {
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no username was specified in url"})
-			return
+	if newOwner, newName, _, moved := am.visibleMove(user, repo, currentUser); moved {
+		return newOwner, newName
+	}
+	return user, repo
+}
+
+// visibleMove is where user/repo went and the privacy of the repository there, when currentUser holds a role on it.
+// Everyone else is not told a repository ever lived at user/repo.
+func (am *AuthenticationMiddleware) visibleMove(user, repo, currentUser string) (string, string, string, bool) {
+	newOwner, newName, moved := am.movedRepo(user, repo)
+
+	if !moved {
+		return "", "", "", false
+	}
+
+	privacy, err := am.RepoStore.GetRepoPrivacy(newOwner, newName)
+
+	if err != nil {
+		return "", "", "", false
+	}
+
+	role, err := am.roleOnRepo(newOwner, newName, currentUser, privacy)
+
+	if err != nil {
+		if err != ErrTwoFactorRequired {
+			am.Logger.Error("Error checking access to moved repository", "repo", newOwner+"/"+newName, "error", err)
 		}
+		return "", "", "", false
+	}
 
-		repo := ctx.Param("reponame")
+	if !role.AtLeast(database.RoleRead) {
+		return "", "", "", false
+	}
 
-		if repo == "" {
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no repository name was specified in url"})
-			return
+	return newOwner, newName, privacy, true
+}
+
+// movedRepo looks up where a repository that left user/repo went
+func (am *AuthenticationMiddleware) movedRepo(user, repo string) (string, string, bool) {
+	newOwner, newName, err := am.RepoStore.GetRepoRedirect(user, repo)
+
+	if err != nil {
+		if err != sql.ErrNoRows {
+			am.Logger.Error("Error looking up repository redirect", "repo", user+"/"+repo, "error", err)
 		}
+		return "", "", false
+	}
 
-		if user == currentUser {
-			ctx.Set("OWNER", true)
-		} else {
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "you are not the owner of this repositories"})
-			return
+	return newOwner, newName, true
+}
+
+// redirectMovedRepo sends a request for the old location of a repository to the new one.
+// Reads are moved permanently, other methods keep their method and body.
+func redirectMovedRepo(ctx *gin.Context, user, repo, newOwner, newName string) {
+	location := "/" + newOwner + "/repo/" + newName + strings.TrimPrefix(ctx.Request.URL.Path, "/"+user+"/repo/"+repo)
+	if ctx.Request.URL.RawQuery != "" {
+		location += "?" + ctx.Request.URL.RawQuery
+	}
+
+	status := http.StatusPermanentRedirect
+	if ctx.Request.Method == http.MethodGet || ctx.Request.Method == http.MethodHead {
+		status = http.StatusMovedPermanently
+	}
+
+	ctx.Redirect(status, location)
+	ctx.Abort()
+}
+
+// missingTwoFactor reports whether currentUser lacks two factor where it is required:
+// on repositories whose owner requires it and on private repositories currentUser owns.
+// Owners who had private repositories before that keep access to them until their grace period ends.
+func (am *AuthenticationMiddleware) missingTwoFactor(user, repo, currentUser, privacy string) (bool, error) {
+	ownPrivate := user == currentUser && privacy == "PRIVATE"
+
+	required, err := am.RepoStore.GetRequireTwoFactor(user, repo)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !required && !ownPrivate {
+		return false, nil
+	}
+
+	twoFactor, err := am.TwoFactorStore.GetTwoFactor(currentUser)
+
+	if err != nil {
+		return false, err
+	}
+
+	if twoFactor.Enabled {
+		return false, nil
+	}
+
+	return required || time.Now().After(twoFactor.GraceUntil), nil
+}
+
+// CheckRepoAccess runs the checks of AuthorizePrivacy, RequireRole and RequireVerifiedEmail
+// for transports that do not go through gin and returns the role found. Roles of write and above also need a verified email address.
+func (am *AuthenticationMiddleware) CheckRepoAccess(user, repo, currentUser string, min database.Role) (database.Role, error) {
+	privacy, err := am.RepoStore.GetRepoPrivacy(user, repo)
+
+	if err != nil {
+		return "", ErrRepoNotFound
+	}
+
+	role, err := am.roleOnRepo(user, repo, currentUser, privacy)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !role.AtLeast(min) {
+		// Private repositories are not revealed to outsiders
+		if role == "" {
+			return "", ErrRepoNotFound
 		}
+		return "", ErrRepoAccessDenied
+	}
 
-		ctx.Set("REPOOWNER", user)
-		ctx.Set("REPONAME", repo)
-		ctx.Next()
+	if !min.AtLeast(database.RoleWrite) {
+		return role, nil
 	}
+
+	account, err := am.UserStore.GetUserbyUsername(currentUser)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !account.EmailVerified {
+		return "", ErrEmailNotVerified
+	}
+
+	return role, nil
 }
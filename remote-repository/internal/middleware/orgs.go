@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+)
+
+// Context key holding the organization of the request
+const orgKey = "ORG"
+
+// RequireOrgRole lets members of the :org organization through, only its owners when ownerOnly is set.
+// Everyone else is told the organization does not exist.
+func (am *AuthenticationMiddleware) RequireOrgRole(ownerOnly bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		currentUser, err := am.ExtractUserFromContext(ctx)
+
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUsernameNotInContext})
+			return
+		}
+
+		org := ctx.Param("org")
+		role, err := am.OrgStore.GetOrgRole(org, currentUser)
+
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if role == "" {
+			ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+			return
+		}
+
+		if ownerOnly && role != database.OrgOwner {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only owners of the organization can do this"})
+			return
+		}
+
+		ctx.Set(orgKey, org)
+		ctx.Next()
+	}
+}
+
+// ExtractOrgFromContext is the organization RequireOrgRole checked
+func (am *AuthenticationMiddleware) ExtractOrgFromContext(ctx *gin.Context) string {
+	return ctx.GetString(orgKey)
+}
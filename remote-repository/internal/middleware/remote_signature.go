@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/remotesign"
+)
+
+const (
+	// How far a signed request's timestamp may be from the server clock, nonces are remembered twice as long
+	signatureMaxSkew = 5 * time.Minute
+	// Largest body spooled for digest verification, the size of the largest push
+	MaxSignedBodySize = 1 << 30
+)
+
+var (
+	ErrMissingSignature = errors.New("Missing Request Signature")
+	ErrInvalidSignature = errors.New("Invalid Request Signature")
+	ErrStaleSignature   = errors.New("Request timestamp is too far from the server time")
+	ErrReplayedRequest  = errors.New("Request nonce was already used")
+	ErrBodyDigest       = errors.New("Request body does not match its digest")
+)
+
+// Cache key of a used nonce, it lives until the request's timestamp could no longer be accepted
+const usedNoncePrefix = "nonce:"
+
+// VerifyRemoteSignature checks a request signed with the repository secret as described in package remotesign.
+// The body is spooled to disk while its digest is checked so handlers only ever see verified content.
+// It must run after AuthorizePrivacy and RequireRole have checked the repository.
+func (am *AuthenticationMiddleware) VerifyRemoteSignature() gin.HandlerFunc {
+	return am.verifyRemoteSignature
+}
+
+// VerifyRemoteSignatureOrReader is VerifyRemoteSignature for reads. Readers never get the repository secret,
+// so an unsigned request is let through when a JWT or a token with repo:read authenticated it.
+// Signed requests are still verified.
+func (am *AuthenticationMiddleware) VerifyRemoteSignatureOrReader() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.GetHeader(remotesign.HeaderSignature) != "" {
+			am.verifyRemoteSignature(ctx)
+			return
+		}
+
+		if _, err := am.ExtractUserFromContext(ctx); err != nil || !am.HasScope(ctx, ScopeRepoRead) {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrMissingSignature.Error()})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (am *AuthenticationMiddleware) verifyRemoteSignature(ctx *gin.Context) {
+	timestamp := ctx.GetHeader(remotesign.HeaderTimestamp)
+	nonce := ctx.GetHeader(remotesign.HeaderNonce)
+	digest := strings.ToLower(ctx.GetHeader(remotesign.HeaderContentSHA256))
+	signature := ctx.GetHeader(remotesign.HeaderSignature)
+
+	if timestamp == "" || nonce == "" || digest == "" || signature == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrMissingSignature.Error()})
+		return
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || !remotesign.ValidNonce(nonce) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidSignature.Error()})
+		return
+	}
+
+	signedAt := time.Unix(seconds, 0)
+	if skew := time.Since(signedAt); skew > signatureMaxSkew || skew < -signatureMaxSkew {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrStaleSignature.Error()})
+		return
+	}
+
+	secret, err := am.RepoStore.GetRepoSecret(ctx.GetString("REPOOWNER"), ctx.GetString("REPONAME"))
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	target := ctx.Request.URL.EscapedPath()
+	if ctx.Request.URL.RawQuery != "" {
+		target += "?" + ctx.Request.URL.RawQuery
+	}
+
+	if !remotesign.Verify(secret, ctx.Request.Method, target, timestamp, nonce, digest, signature) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidSignature.Error()})
+		return
+	}
+
+	// Only nonces of genuine requests are recorded, others could otherwise burn them
+	fresh, err := am.Nonces.Add(usedNoncePrefix+nonce, "1", time.Until(signedAt.Add(2*signatureMaxSkew)))
+	if err != nil {
+		am.Logger.Error("Error recording request nonce", "error", err)
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !fresh {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrReplayedRequest.Error()})
+		return
+	}
+
+	body, err := spoolVerified(ctx, digest)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+		case err == ErrBodyDigest:
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			am.Logger.Error("Error spooling signed request body", "error", err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+	defer func() {
+		body.Close()
+		os.Remove(body.Name())
+	}()
+
+	ctx.Request.Body = body
+	ctx.Next()
+}
+
+// spoolVerified copies the body to a temporary file and rewinds it once its SHA-256 matches digest
+func spoolVerified(ctx *gin.Context, digest string) (*os.File, error) {
+	file, err := os.CreateTemp("", "jit-signed-body-*")
+	if err != nil {
+		return nil, err
+	}
+
+	fail := func(err error) (*os.File, error) {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	hash := sha256.New()
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxSignedBodySize)
+
+	if _, err = io.Copy(io.MultiWriter(file, hash), body); err != nil {
+		return fail(err)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != digest {
+		return fail(ErrBodyDigest)
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return file, nil
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+)
+
+// Context key holding the role of the current user on the repository of the request
+const roleKey = "ROLE"
+
+// Token scope a route needs besides the role, by the role it requires
+var roleScopes = map[database.Role]string{
+	database.RoleRead:     ScopeRepoRead,
+	database.RoleTriage:   ScopeRepoRead,
+	database.RoleWrite:    ScopeRepoWrite,
+	database.RoleMaintain: ScopeRepoAdmin,
+	database.RoleAdmin:    ScopeRepoAdmin,
+}
+
+// RequireRole lets the request through when the current user holds at least min on the repository,
+// everyone reads public repositories. It must run after AuthorizePrivacy has set the repository.
+func (am *AuthenticationMiddleware) RequireRole(min database.Role) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		currentUser, err := am.ExtractUserFromContext(ctx)
+
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUsernameNotInContext})
+			return
+		}
+
+		user := ctx.GetString("REPOOWNER")
+		repo := ctx.GetString("REPONAME")
+
+		if user == "" || repo == "" {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no repository was specified in url"})
+			return
+		}
+
+		if scope := roleScopes[min]; !am.HasScope(ctx, scope) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token is missing the " + scope + " scope"})
+			return
+		}
+
+		role, err := am.roleOnRepo(user, repo, currentUser, ctx.GetString("PRIVACY"))
+
+		if err != nil {
+			if err == ErrTwoFactorRequired {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "two factor authentication is required for this repository, enable it under /settings/2fa"})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if !role.AtLeast(min) {
+			if role == "" {
+				ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "repository not found"})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this needs the " + string(min) + " role on the repository, you have " + string(role)})
+			return
+		}
+
+		ctx.Set(roleKey, role)
+		ctx.Next()
+	}
+}
+
+// ExtractRoleFromContext is the role RequireRole found, read for visitors of public repositories
+func (am *AuthenticationMiddleware) ExtractRoleFromContext(ctx *gin.Context) database.Role {
+	role, _ := ctx.Get(roleKey)
+	r, _ := role.(database.Role)
+	return r
+}
+
+// roleOnRepo is the role of currentUser, read on public repositories for everyone without one and empty on private ones.
+// Collaborators failing the two factor rules of the repository get ErrTwoFactorRequired.
+func (am *AuthenticationMiddleware) roleOnRepo(user, repo, currentUser, privacy string) (database.Role, error) {
+	role, err := am.RepoStore.GetRoleOnRepo(user, repo, currentUser)
+
+	if err != nil {
+		return "", err
+	}
+
+	if role == "" {
+		if privacy == "PUBLIC" {
+			return database.RoleRead, nil
+		}
+		return "", nil
+	}
+
+	missing, err := am.missingTwoFactor(user, repo, currentUser, privacy)
+
+	if err != nil {
+		return "", err
+	}
+
+	if missing {
+		return "", ErrTwoFactorRequired
+	}
+
+	return role, nil
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes a personal access token can be limited to
+const (
+	ScopeRepoRead  = "repo:read"
+	ScopeRepoWrite = "repo:write"
+	ScopeRepoAdmin = "repo:admin"
+	ScopeUser      = "user"
+)
+
+// Prefix that tells personal access tokens apart from JWTs in the Authorization header
+const PersonalAccessTokenPrefix = "jit_"
+
+// Context key holding the scopes of the token the request was authenticated with,
+// it is unset for JWTs which carry every scope
+const scopesKey = "SCOPES"
+
+var impliedScopes = map[string][]string{
+	ScopeRepoRead:  {ScopeRepoRead, ScopeRepoWrite, ScopeRepoAdmin},
+	ScopeRepoWrite: {ScopeRepoWrite, ScopeRepoAdmin},
+	ScopeRepoAdmin: {ScopeRepoAdmin},
+	ScopeUser:      {ScopeUser},
+}
+
+func IsValidScope(scope string) bool {
+	_, ok := impliedScopes[scope]
+	return ok
+}
+
+// HasScope reports whether the request's credentials grant scope, repo:admin implies repo:write implies repo:read
+func (am *AuthenticationMiddleware) HasScope(ctx *gin.Context, scope string) bool {
+	value, ok := ctx.Get(scopesKey)
+	if !ok {
+		return true
+	}
+
+	granted, _ := value.([]string)
+	for _, g := range granted {
+		for _, s := range impliedScopes[scope] {
+			if g == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (am *AuthenticationMiddleware) RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !am.HasScope(ctx, scope) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token is missing the " + scope + " scope"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// RequireSignIn refuses requests made with a personal access token, whatever its scopes,
+// so a token cannot mint another token with more scopes or a later expiry
+func (am *AuthenticationMiddleware) RequireSignIn() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := ctx.Get(scopesKey); ok {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "personal access tokens cannot do this, please log in"})
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -20,4 +20,93 @@ type TokenResponse struct {
 
 type LogoutRequest struct {
 	RefreshToken string `json:"refresh_token"`
-}
\ No newline at end of file
+}
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+// Public key in authorized_keys format, name defaults to the key comment
+type AddSSHKeyRequest struct {
+	Name      string `json:"name,omitempty"`
+	PublicKey string `json:"public_key"`
+}
+
+type OAuthSignupRequest struct {
+	SignupToken string `json:"signup_token"`
+	Username    string `json:"username"`
+}
+
+// Returned by the password step when the account has two factor enabled
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RequireTwoFactorRequest struct {
+	Required bool `json:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	EmailAddress string `json:"email"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Reason is recorded in the audit log and kept on the account for other administrators
+type AdminSuspendRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdminDeleteRepoRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Name shares the namespace of usernames, FullName defaults to it
+type CreateOrgRequest struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Bio      string `json:"bio"`
+}
+
+type OrgMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type CreateTeamRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type TeamRepoRequest struct {
+	Role string `json:"role"`
+}
+
+// NewOwner is a user or an organization, it has to accept the transfer
+type TransferRepoRequest struct {
+	NewOwner string `json:"new_owner"`
+}
+
+type RenameRepoRequest struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,135 @@
+// Package cache stores short lived string values under keys, in process or on a server speaking the Redis protocol.
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMiss       = errors.New("Key Not Found")
+	ErrNotCounter = errors.New("Value is not a counter")
+)
+
+type Cache interface {
+	// Get fails with ErrMiss when the key is unset or expired
+	Get(key string) (string, error)
+	// Set stores value under key for ttl, zero keeps it until it is deleted
+	Set(key, value string, ttl time.Duration) error
+	// Add is Set for a key that is unset or expired, it reports false and changes nothing otherwise
+	Add(key, value string, ttl time.Duration) (bool, error)
+	// Increment adds one to the counter under key, unset keys start at zero, and keeps it for ttl from now
+	Increment(key string, ttl time.Duration) (int64, error)
+	Delete(key string) error
+	Close() error
+}
+
+// How many writes between sweeps of expired entries
+const sweepEvery = 1024
+
+type memoryEntry struct {
+	value   string
+	expires time.Time
+}
+
+// MemoryCache lives in the process, values are lost on restart and not shared between instances
+type MemoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	writes  int
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: map[string]memoryEntry{}}
+}
+
+func (mc *MemoryCache) Get(key string) (string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	entry, ok := mc.entries[key]
+	if !ok {
+		return "", ErrMiss
+	}
+
+	if !entry.expires.IsZero() && !time.Now().Before(entry.expires) {
+		delete(mc.entries, key)
+		return "", ErrMiss
+	}
+	return entry.value, nil
+}
+
+func (mc *MemoryCache) Set(key, value string, ttl time.Duration) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.set(key, value, ttl)
+	return nil
+}
+
+func (mc *MemoryCache) Add(key, value string, ttl time.Duration) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if entry, ok := mc.entries[key]; ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		return false, nil
+	}
+
+	mc.set(key, value, ttl)
+	return true, nil
+}
+
+func (mc *MemoryCache) Increment(key string, ttl time.Duration) (int64, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	var count int64
+	if entry, ok := mc.entries[key]; ok && (entry.expires.IsZero() || time.Now().Before(entry.expires)) {
+		var err error
+		if count, err = strconv.ParseInt(entry.value, 10, 64); err != nil {
+			return 0, ErrNotCounter
+		}
+	}
+
+	count++
+	mc.set(key, strconv.FormatInt(count, 10), ttl)
+	return count, nil
+}
+
+// set stores an entry and sweeps now and then, the lock is held by the caller
+func (mc *MemoryCache) set(key, value string, ttl time.Duration) {
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	mc.entries[key] = entry
+
+	mc.writes++
+	if mc.writes%sweepEvery == 0 {
+		mc.sweep()
+	}
+}
+
+func (mc *MemoryCache) Delete(key string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	delete(mc.entries, key)
+	return nil
+}
+
+func (mc *MemoryCache) Close() error {
+	return nil
+}
+
+// sweep drops expired entries that were never read again, the lock is held by the caller
+func (mc *MemoryCache) sweep() {
+	now := time.Now()
+	for key, entry := range mc.entries {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			delete(mc.entries, key)
+		}
+	}
+}
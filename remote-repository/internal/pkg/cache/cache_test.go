@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// Long enough to read a value back before it expires on a busy machine
+const shortTTL = 50 * time.Millisecond
+
+func TestMemoryCacheGetBeforeExpiry(t *testing.T) {
+	mc := NewMemoryCache()
+	mc.Set("key", "value", time.Minute)
+
+	value, err := mc.Get("key")
+	if err != nil || value != "value" {
+		t.Fatalf("Get = %q, %v, want value", value, err)
+	}
+}
+
+func TestMemoryCacheExpires(t *testing.T) {
+	mc := NewMemoryCache()
+	mc.Set("key", "value", shortTTL)
+	time.Sleep(2 * shortTTL)
+
+	if _, err := mc.Get("key"); err != ErrMiss {
+		t.Fatalf("Get after ttl = %v, want ErrMiss", err)
+	}
+	if _, ok := mc.entries["key"]; ok {
+		t.Fatal("expired entry was kept after Get")
+	}
+}
+
+func TestMemoryCacheZeroTTLKeeps(t *testing.T) {
+	mc := NewMemoryCache()
+	mc.Set("key", "value", 0)
+	time.Sleep(shortTTL)
+
+	if value, err := mc.Get("key"); err != nil || value != "value" {
+		t.Fatalf("Get = %q, %v, want value", value, err)
+	}
+
+	mc.Delete("key")
+	if _, err := mc.Get("key"); err != ErrMiss {
+		t.Fatalf("Get after Delete = %v, want ErrMiss", err)
+	}
+}
+
+func TestMemoryCacheSetRenewsTTL(t *testing.T) {
+	mc := NewMemoryCache()
+	mc.Set("key", "old", shortTTL)
+	mc.Set("key", "new", time.Minute)
+	time.Sleep(2 * shortTTL)
+
+	if value, err := mc.Get("key"); err != nil || value != "new" {
+		t.Fatalf("Get = %q, %v, want new", value, err)
+	}
+}
+
+func TestMemoryCacheAddRefusesLiveKey(t *testing.T) {
+	mc := NewMemoryCache()
+
+	if added, _ := mc.Add("key", "first", time.Minute); !added {
+		t.Fatal("Add of an unset key was refused")
+	}
+	if added, _ := mc.Add("key", "second", time.Minute); added {
+		t.Fatal("Add of a live key succeeded")
+	}
+
+	if value, _ := mc.Get("key"); value != "first" {
+		t.Fatalf("Get = %q, a refused Add changed the value", value)
+	}
+}
+
+func TestMemoryCacheAddReplacesExpiredKey(t *testing.T) {
+	mc := NewMemoryCache()
+	mc.Add("key", "first", shortTTL)
+	time.Sleep(2 * shortTTL)
+
+	if added, _ := mc.Add("key", "second", time.Minute); !added {
+		t.Fatal("Add of an expired key was refused")
+	}
+	if value, _ := mc.Get("key"); value != "second" {
+		t.Fatalf("Get = %q, want second", value)
+	}
+}
+
+func TestMemoryCacheSweepsExpiredEntries(t *testing.T) {
+	mc := NewMemoryCache()
+	mc.Set("expired", "value", time.Millisecond)
+	mc.Set("kept", "value", 0)
+	time.Sleep(shortTTL)
+
+	for i := mc.writes; i < sweepEvery; i++ {
+		mc.Set("kept", "value", 0)
+	}
+
+	if _, ok := mc.entries["expired"]; ok {
+		t.Fatal("expired entry survived a sweep")
+	}
+	if _, ok := mc.entries["kept"]; !ok {
+		t.Fatal("sweep dropped an entry without ttl")
+	}
+}
+
+func TestMemoryCacheIncrement(t *testing.T) {
+	mc := NewMemoryCache()
+
+	for want := int64(1); want <= 3; want++ {
+		if count, err := mc.Increment("key", time.Minute); err != nil || count != want {
+			t.Fatalf("Increment = %d, %v, want %d", count, err, want)
+		}
+	}
+
+	mc.Set("name", "value", 0)
+	if _, err := mc.Increment("name", time.Minute); err != ErrNotCounter {
+		t.Fatalf("Increment of a string = %v, want ErrNotCounter", err)
+	}
+}
+
+func TestMemoryCacheIncrementRestartsAfterTTL(t *testing.T) {
+	mc := NewMemoryCache()
+	mc.Increment("key", shortTTL)
+	mc.Increment("key", shortTTL)
+	time.Sleep(2 * shortTTL)
+
+	if count, _ := mc.Increment("key", time.Minute); count != 1 {
+		t.Fatalf("Increment after ttl = %d, want 1", count)
+	}
+}
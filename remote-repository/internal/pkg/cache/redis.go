@@ -0,0 +1,333 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrServerReply    = errors.New("Redis Error Reply")
+	ErrMalformedReply = errors.New("Malformed Redis Reply")
+	ErrInvalidURL     = errors.New("Redis URL must look like redis://[user:password@]host[:port][/db] or rediss://")
+)
+
+const (
+	defaultRedisPort = "6379"
+	maxIdleConns     = 8
+	// Longest reply line accepted, values are read by their announced length
+	maxReplyLine = 64 * 1024
+	// Largest bulk value accepted
+	maxBulkLength = 16 * 1024 * 1024
+)
+
+// RedisCache talks RESP to a Redis compatible server and keeps a few connections open between commands
+type RedisCache struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	// Deadline of a whole command, dial included
+	Timeout time.Duration
+
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedisCache parses rawURL without connecting, the first command dials
+func NewRedisCache(rawURL string) (*RedisCache, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+
+	rc := &RedisCache{
+		addr:    u.Host,
+		Timeout: 2 * time.Second,
+		idle:    make(chan *redisConn, maxIdleConns),
+	}
+	if u.Port() == "" {
+		rc.addr = net.JoinHostPort(u.Hostname(), defaultRedisPort)
+	}
+
+	if u.User != nil {
+		rc.password, _ = u.User.Password()
+		rc.username = u.User.Username()
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if rc.db, err = strconv.Atoi(db); err != nil || rc.db < 0 {
+			return nil, ErrInvalidURL
+		}
+	}
+
+	if u.Scheme == "rediss" {
+		rc.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	return rc, nil
+}
+
+func (rc *RedisCache) Get(key string) (string, error) {
+	reply, err := rc.do("GET", key)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := reply.(string)
+	if !ok {
+		return "", ErrMiss
+	}
+	return value, nil
+}
+
+func (rc *RedisCache) Set(key, value string, ttl time.Duration) error {
+	_, err := rc.do(setArgs(key, value, ttl)...)
+	return err
+}
+
+func (rc *RedisCache) Add(key, value string, ttl time.Duration) (bool, error) {
+	// SET NX replies with a null when the key exists
+	reply, err := rc.do(append(setArgs(key, value, ttl), "NX")...)
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+func (rc *RedisCache) Increment(key string, ttl time.Duration) (int64, error) {
+	reply, err := rc.do("INCR", key)
+	if err != nil {
+		return 0, err
+	}
+
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, ErrMalformedReply
+	}
+
+	if ttl > 0 {
+		if _, err = rc.do("PEXPIRE", key, milliseconds(ttl)); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (rc *RedisCache) Delete(key string) error {
+	_, err := rc.do("DEL", key)
+	return err
+}
+
+// Close closes the idle connections, commands still running close theirs when they finish
+func (rc *RedisCache) Close() error {
+	for {
+		select {
+		case c := <-rc.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func setArgs(key, value string, ttl time.Duration) []string {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", milliseconds(ttl))
+	}
+	return args
+}
+
+// milliseconds rounds ttl up so a short one does not become no expiry
+func milliseconds(ttl time.Duration) string {
+	return strconv.FormatInt(int64((ttl+time.Millisecond-1)/time.Millisecond), 10)
+}
+
+// do sends one command and reads its reply, a pooled connection that went stale is replaced once
+func (rc *RedisCache) do(args ...string) (any, error) {
+	c, pooled, err := rc.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.command(rc.Timeout, args...)
+	if err != nil && pooled && !errors.Is(err, ErrServerReply) {
+		c.conn.Close()
+		if c, err = rc.dial(); err != nil {
+			return nil, err
+		}
+		reply, err = c.command(rc.Timeout, args...)
+	}
+
+	// An error reply leaves the connection in sync, anything else may not
+	if err != nil && !errors.Is(err, ErrServerReply) {
+		c.conn.Close()
+		return nil, err
+	}
+
+	rc.put(c)
+	return reply, err
+}
+
+func (rc *RedisCache) get() (*redisConn, bool, error) {
+	select {
+	case c := <-rc.idle:
+		return c, true, nil
+	default:
+	}
+
+	c, err := rc.dial()
+	return c, false, err
+}
+
+func (rc *RedisCache) put(c *redisConn) {
+	select {
+	case rc.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+// dial connects and authenticates, then selects the database of the URL
+func (rc *RedisCache) dial() (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: rc.Timeout}
+
+	var conn net.Conn
+	var err error
+	if rc.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", rc.addr, rc.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", rc.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if rc.password != "" {
+		args := []string{"AUTH", rc.password}
+		if rc.username != "" {
+			args = []string{"AUTH", rc.username, rc.password}
+		}
+		if _, err = c.command(rc.Timeout, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if rc.db != 0 {
+		if _, err = c.command(rc.Timeout, "SELECT", strconv.Itoa(rc.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) command(timeout time.Duration, args ...string) (any, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+// reply reads one RESP value: a string, an int64, nil for a null, or a slice of values
+func (c *redisConn) reply() (any, error) {
+	line, err := c.line()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, ErrMalformedReply
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("%w: %s", ErrServerReply, line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrMalformedReply
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxBulkLength {
+			return nil, ErrMalformedReply
+		}
+		if n == -1 {
+			return nil, nil
+		}
+
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		if string(data[n:]) != "\r\n" {
+			return nil, ErrMalformedReply
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, ErrMalformedReply
+		}
+		if n == -1 {
+			return nil, nil
+		}
+
+		values := make([]any, 0, n)
+		for range n {
+			value, err := c.reply()
+			if err != nil && !errors.Is(err, ErrServerReply) {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+	return nil, ErrMalformedReply
+}
+
+func (c *redisConn) line() (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := c.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+
+		line = append(line, chunk...)
+		if len(line) > maxReplyLine {
+			return "", ErrMalformedReply
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
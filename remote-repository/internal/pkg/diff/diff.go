@@ -0,0 +1,78 @@
+package diff
+
+import "slices"
+
+// Port of the client's Myers diff (src/diff.cpp).
+// Every line of the result is prefixed with ' ' (kept), '-' (removed from a) or '+' (added in b).
+func Diff(a, b []string) []string {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	del := make([]int, 2*max+1)
+	var trace [][]int
+	var x, y int
+	found := -1
+
+	for d := 0; d <= max; d++ {
+		for k := max - d; k <= max+d; k += 2 {
+			if k == max-d || (k != max+d && del[k-1] < del[k+1]) {
+				x = del[k+1]
+			} else {
+				x = del[k-1] + 1
+			}
+			y = x + max - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			del[k] = x
+			if x >= n && y >= m {
+				found = k
+				break
+			}
+		}
+		trace = append(trace, slices.Clone(del))
+		if found != -1 {
+			break
+		}
+	}
+
+	var lines []string
+	k := found
+	for d := len(trace) - 1; d > 0; d-- {
+		x = trace[d][k]
+		var prevK int
+		if k == max-d || (k != max+d && trace[d][k-1] < trace[d][k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := trace[d][prevK]
+		y = x + max - k
+		prevY := prevX + max - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			lines = append(lines, " "+a[x])
+		}
+		if x > prevX {
+			x--
+			lines = append(lines, "-"+a[x])
+		} else if y > prevY {
+			y--
+			lines = append(lines, "+"+b[y])
+		}
+		k = prevK
+	}
+
+	x, y = x-1, y-1
+	for x == y && x >= 0 {
+		lines = append(lines, " "+a[x])
+		x, y = x-1, y-1
+	}
+
+	slices.Reverse(lines)
+	return lines
+}
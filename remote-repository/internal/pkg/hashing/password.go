@@ -1,6 +1,75 @@
 package hashing
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	// 4 GiB, every login allocates this much
+	maxArgon2Memory = 4 * 1024 * 1024
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("Unknown Password Hash Algorithm")
+	ErrInvalidHash      = errors.New("Invalid Password Hash")
+	ErrInvalidParams    = errors.New("Invalid Password Hash Parameters")
+)
+
+// Params decides how new hashes are made, hashes made differently are upgraded on the next login
+type Params struct {
+	Algorithm  string
+	BcryptCost int
+	// Argon2id memory in KiB, passes and lanes
+	Argon2Memory  uint32
+	Argon2Time    uint32
+	Argon2Threads uint8
+}
+
+var DefaultParams = Params{
+	Algorithm:     Bcrypt,
+	BcryptCost:    12,
+	Argon2Memory:  64 * 1024,
+	Argon2Time:    3,
+	Argon2Threads: 2,
+}
+
+var current = DefaultParams
+
+// Configure sets the parameters of every hash made from now on, it is meant to be called once at startup
+func Configure(params Params) error {
+	switch params.Algorithm {
+	case Bcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("%w: bcrypt cost must be between %d and %d", ErrInvalidParams, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case Argon2id:
+		if params.Argon2Memory < 8*uint32(params.Argon2Threads) || params.Argon2Time < 1 || params.Argon2Threads < 1 {
+			return fmt.Errorf("%w: argon2id needs at least one pass, one thread and 8 KiB per thread", ErrInvalidParams)
+		}
+		if params.Argon2Memory > maxArgon2Memory {
+			return fmt.Errorf("%w: argon2id memory is limited to %d KiB", ErrInvalidParams, maxArgon2Memory)
+		}
+	default:
+		return ErrUnknownAlgorithm
+	}
+
+	current = params
+	return nil
+}
 
 type Password struct {
 	Plaintext *string `json:"-"`
@@ -8,7 +77,14 @@ type Password struct {
 }
 
 func (p *Password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	var hash []byte
+	var err error
+
+	if current.Algorithm == Argon2id {
+		hash, err = hashArgon2id(plaintextPassword, current)
+	} else {
+		hash, err = bcrypt.GenerateFromPassword([]byte(plaintextPassword), current.BcryptCost)
+	}
 
 	if err != nil {
 		return err
@@ -20,6 +96,10 @@ func (p *Password) Set(plaintextPassword string) error {
 }
 
 func (p *Password) MatchPassword(password []byte) (bool, error) {
+	if bytes.HasPrefix(p.Hash, []byte("$"+Argon2id+"$")) {
+		return matchArgon2id(p.Hash, password)
+	}
+
 	err := bcrypt.CompareHashAndPassword(p.Hash, password)
 
 	if err != nil {
@@ -31,3 +111,75 @@ func (p *Password) MatchPassword(password []byte) (bool, error) {
 	}
 	return true, nil
 }
+
+// NeedsRehash reports whether the hash was made with another algorithm or weaker parameters than configured
+func (p *Password) NeedsRehash() bool {
+	if current.Algorithm == Argon2id {
+		params, _, _, err := decodeArgon2id(p.Hash)
+		return err != nil || params.Argon2Memory < current.Argon2Memory ||
+			params.Argon2Time < current.Argon2Time || params.Argon2Threads < current.Argon2Threads
+	}
+
+	cost, err := bcrypt.Cost(p.Hash)
+	return err != nil || cost < current.BcryptCost
+}
+
+// Encoded in the PHC string format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func hashArgon2id(password string, params Params) ([]byte, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, argon2KeyLength)
+
+	encoded := fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version,
+		params.Argon2Memory, params.Argon2Time, params.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func matchArgon2id(hash, password []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey(password, salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, uint32(len(key)))
+
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, bcrypt.ErrMismatchedHashAndPassword
+	}
+	return true, nil
+}
+
+func decodeArgon2id(hash []byte) (params Params, salt, key []byte, err error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	params.Algorithm = Argon2id
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads)
+	if err != nil || params.Argon2Time < 1 || params.Argon2Threads < 1 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
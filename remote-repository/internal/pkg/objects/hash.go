@@ -0,0 +1,59 @@
+package objects
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// Hash computes the name of an object the way the client does,
+// a 32 bit MurmurHash3 of the serialized object as 8 hex digits (src/hashmap.cpp)
+func Hash(data []byte) string {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	scramble := func(k uint32) uint32 {
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		return k * c2
+	}
+
+	var h uint32
+	tail := len(data) / 4 * 4
+
+	for i := 0; i < tail; i += 4 {
+		h ^= scramble(binary.LittleEndian.Uint32(data[i:]))
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	for i := len(data) - 1; i >= tail; i-- {
+		k = k<<8 | uint32(data[i])
+	}
+	h ^= scramble(k)
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+
+	return fmt.Sprintf("%08x", h)
+}
+
+// IsHash reports whether s looks like an object name
+func IsHash(s string) bool {
+	if len(s) != 8 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
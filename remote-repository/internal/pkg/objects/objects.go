@@ -0,0 +1,127 @@
+package objects
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mirrors the object model of the C++ client (src/gitobjects.h)
+
+const TimestampLayout = "2006-01-02,15:04:05"
+
+var (
+	ErrInvalidObject = errors.New("Invalid Object")
+	ErrNotABlob      = errors.New("Object is not a blob")
+	ErrNotATree      = errors.New("Object is not a tree")
+	ErrNotACommit    = errors.New("Object is not a commit")
+	ErrInvalidName   = errors.New("Tree entry names must be a single path component")
+)
+
+type Blob struct {
+	Hash    string
+	Content []byte
+}
+
+type TreeEntry struct {
+	Type string
+	Name string
+	Hash string
+}
+
+type Tree struct {
+	Hash    string
+	Entries []TreeEntry
+}
+
+type Commit struct {
+	Hash      string
+	TreeHash  string
+	Author    string
+	Timestamp string
+	Message   string
+	Parents   []string
+}
+
+func (c *Commit) Time() time.Time {
+	t, err := time.Parse(TimestampLayout, c.Timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// ValidEntryName reports whether name is safe to use as a file name below a tree,
+// names come from pushed data and end up in archives clients extract
+func ValidEntryName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func ParseBlob(hash string, data []byte) (*Blob, error) {
+	header, body, ok := bytes.Cut(data, []byte{'\n'})
+	if !ok || !bytes.HasPrefix(header, []byte("blob ")) {
+		return nil, ErrNotABlob
+	}
+	return &Blob{Hash: hash, Content: body}, nil
+}
+
+func ParseTree(hash string, data []byte) (*Tree, error) {
+	lines := strings.Split(string(data), "\n")
+	header := strings.Fields(lines[0])
+	if len(header) != 2 || header[0] != "tree" {
+		return nil, ErrNotATree
+	}
+
+	count, err := strconv.Atoi(header[1])
+	if err != nil || count > len(lines)-1 {
+		return nil, ErrInvalidObject
+	}
+
+	tree := &Tree{Hash: hash}
+	for _, line := range lines[1 : count+1] {
+		first := strings.IndexByte(line, ' ')
+		last := strings.LastIndexByte(line, ' ')
+		if first < 0 || last <= first {
+			return nil, ErrInvalidObject
+		}
+		tree.Entries = append(tree.Entries, TreeEntry{
+			Type: line[:first],
+			Name: line[first+1 : last],
+			Hash: line[last+1:],
+		})
+	}
+	return tree, nil
+}
+
+func ParseCommit(hash string, data []byte) (*Commit, error) {
+	lines := strings.Split(string(data), "\n")
+	header := strings.Fields(lines[0])
+	if len(header) != 2 || header[0] != "commit" {
+		return nil, ErrNotACommit
+	}
+
+	count, err := strconv.Atoi(header[1])
+	if err != nil || count > len(lines)-1 {
+		return nil, ErrInvalidObject
+	}
+
+	commit := &Commit{Hash: hash}
+	for _, line := range lines[1 : count+1] {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "author":
+			commit.Author = value
+		case "timestamp":
+			commit.Timestamp = value
+		case "message":
+			commit.Message = strings.TrimSpace(value)
+		case "tree":
+			commit.TreeHash = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		}
+	}
+	return commit, nil
+}
@@ -0,0 +1,289 @@
+package objects
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrObjectNotFound = errors.New("Object not found")
+	ErrPathNotFound   = errors.New("Path not found in tree")
+)
+
+type Store interface {
+	Get(owner, repo, hash string) ([]byte, error)
+	Put(owner, repo, hash string, data []byte) error
+	Exists(owner, repo, hash string) bool
+	// Size is the bytes stored for a repository, zero when it has no objects
+	Size(owner, repo string) (int64, error)
+	RemoveRepo(owner, repo string) error
+	// MoveRepo gives the objects of a repository a new owner and name
+	MoveRepo(owner, repo, newOwner, newRepo string) error
+}
+
+// FileStore keeps objects on disk in the same layout as a client's .jit/objects directory,
+// one directory per repository: <Root>/<owner>/<repo>/<hash>
+type FileStore struct {
+	Root string
+}
+
+func (s *FileStore) objectPath(owner, repo, hash string) (string, error) {
+	return s.path(owner, repo, hash)
+}
+
+func (s *FileStore) repoPath(owner, repo string) (string, error) {
+	return s.path(owner, repo)
+}
+
+// path joins parts under Root, each must be a single path element
+func (s *FileStore) path(parts ...string) (string, error) {
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return "", ErrObjectNotFound
+		}
+	}
+	return filepath.Join(append([]string{s.Root}, parts...)...), nil
+}
+
+func (s *FileStore) Get(owner, repo, hash string) ([]byte, error) {
+	p, err := s.objectPath(owner, repo, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *FileStore) Put(owner, repo, hash string, data []byte) error {
+	p, err := s.objectPath(owner, repo, hash)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+func (s *FileStore) Exists(owner, repo, hash string) bool {
+	p, err := s.objectPath(owner, repo, hash)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+func (s *FileStore) Size(owner, repo string) (int64, error) {
+	dir, err := s.repoPath(owner, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// RemoveRepo deletes every object of a repository, it is not an error when there are none
+func (s *FileStore) RemoveRepo(owner, repo string) error {
+	dir, err := s.repoPath(owner, repo)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// MoveRepo renames the directory of a repository, it is not an error when it has no objects
+func (s *FileStore) MoveRepo(owner, repo, newOwner, newRepo string) error {
+	from, err := s.repoPath(owner, repo)
+	if err != nil {
+		return err
+	}
+
+	to, err := s.repoPath(newOwner, newRepo)
+	if err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(from); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err = os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// Repo reads and decodes the objects of a single repository
+type Repo struct {
+	Store Store
+	Owner string
+	Name  string
+}
+
+func (r *Repo) get(hash string) ([]byte, error) {
+	return r.Store.Get(r.Owner, r.Name, hash)
+}
+
+func (r *Repo) ReadBlob(hash string) (*Blob, error) {
+	data, err := r.get(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBlob(hash, data)
+}
+
+func (r *Repo) ReadTree(hash string) (*Tree, error) {
+	data, err := r.get(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTree(hash, data)
+}
+
+func (r *Repo) ReadCommit(hash string) (*Commit, error) {
+	data, err := r.get(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCommit(hash, data)
+}
+
+// FindBlob resolves a slash separated path inside a tree to the blob stored there
+func (r *Repo) FindBlob(treeHash, filePath string) (*Blob, error) {
+	entry, err := r.findEntry(treeHash, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Type != "blob" {
+		return nil, ErrPathNotFound
+	}
+	return r.ReadBlob(entry.Hash)
+}
+
+// FindTree resolves a slash separated directory path inside a tree, an empty path is the tree itself
+func (r *Repo) FindTree(treeHash, dirPath string) (*Tree, error) {
+	if strings.Trim(path.Clean("/"+dirPath), "/") == "" {
+		return r.ReadTree(treeHash)
+	}
+
+	entry, err := r.findEntry(treeHash, dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Type != "tree" {
+		return nil, ErrPathNotFound
+	}
+	return r.ReadTree(entry.Hash)
+}
+
+func (r *Repo) findEntry(treeHash, entryPath string) (TreeEntry, error) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+entryPath), "/"), "/")
+	hash := treeHash
+
+	for i, part := range parts {
+		tree, err := r.ReadTree(hash)
+		if err != nil {
+			return TreeEntry{}, err
+		}
+
+		entry, ok := tree.lookup(part)
+		if !ok {
+			return TreeEntry{}, ErrPathNotFound
+		}
+
+		if i == len(parts)-1 {
+			return entry, nil
+		}
+
+		if entry.Type != "tree" {
+			return TreeEntry{}, ErrPathNotFound
+		}
+		hash = entry.Hash
+	}
+	return TreeEntry{}, ErrPathNotFound
+}
+
+type WalkFunc func(filePath string, entry TreeEntry) error
+
+// Walk visits every entry below a tree in name order, like ObjectStore::reconstruct in the client.
+// Returning fs.SkipDir from fn for a tree entry skips its contents.
+func (r *Repo) Walk(treeHash string, fn WalkFunc) error {
+	return r.walk(treeHash, "", fn)
+}
+
+func (r *Repo) walk(treeHash, prefix string, fn WalkFunc) error {
+	tree, err := r.ReadTree(treeHash)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range tree.Entries {
+		// Pushes are checked already, this keeps older objects from escaping the archive root
+		if !ValidEntryName(entry.Name) {
+			return fmt.Errorf("%w: %q in tree %s", ErrInvalidName, entry.Name, treeHash)
+		}
+		filePath := path.Join(prefix, entry.Name)
+
+		err = fn(filePath, entry)
+		if entry.Type == "tree" {
+			if errors.Is(err, fs.SkipDir) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err = r.walk(entry.Hash, filePath, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tree) lookup(name string) (TreeEntry, bool) {
+	for _, entry := range t.Entries {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return TreeEntry{}, false
+}
@@ -0,0 +1,57 @@
+// Package remotesign defines how the CLI signs push and pull requests to a remote with the repository secret.
+//
+// A signed request carries four headers:
+//
+//	X-Jit-Timestamp       unix seconds when the request was signed
+//	X-Jit-Nonce           16 to 64 characters of [A-Za-z0-9_-], never reused
+//	X-Jit-Content-SHA256  hex SHA-256 of the request body, of nothing for empty bodies
+//	X-Jit-Signature       hex HMAC-SHA256 of the string to sign keyed with the repository secret
+//
+// The string to sign joins these lines with "\n":
+//
+//	JIT-HMAC-SHA256
+//	<method>
+//	<escaped path>[?<raw query>]
+//	<timestamp>
+//	<nonce>
+//	<content sha256>
+package remotesign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+const (
+	HeaderTimestamp     = "X-Jit-Timestamp"
+	HeaderNonce         = "X-Jit-Nonce"
+	HeaderContentSHA256 = "X-Jit-Content-SHA256"
+	HeaderSignature     = "X-Jit-Signature"
+
+	algorithm = "JIT-HMAC-SHA256"
+)
+
+var nonceRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+func ValidNonce(nonce string) bool {
+	return nonceRegex.MatchString(nonce)
+}
+
+func StringToSign(method, target, timestamp, nonce, contentSHA256 string) string {
+	return strings.Join([]string{algorithm, method, target, timestamp, nonce, contentSHA256}, "\n")
+}
+
+func Sign(secret, method, target, timestamp, nonce, contentSHA256 string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(method, target, timestamp, nonce, contentSHA256)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify compares in constant time
+func Verify(secret, method, target, timestamp, nonce, contentSHA256, signature string) bool {
+	expected := Sign(secret, method, target, timestamp, nonce, contentSHA256)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
@@ -0,0 +1,81 @@
+package sealing
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Prefix of sealed values, rows written before sealing hold the plaintext
+const prefix = "v1:"
+
+var ErrInvalidSealed = errors.New("Invalid Sealed Secret")
+
+// Key derives the AES-256 key for one kind of secret from a server key, so one key can serve several columns
+func Key(serverKey []byte, label string) []byte {
+	mac := hmac.New(sha256.New, serverKey)
+	mac.Write([]byte("jit sealing " + label))
+	return mac.Sum(nil)
+}
+
+// Seal encrypts a secret the server has to read back with AES-256-GCM.
+// owner is authenticated with it, a sealed value copied to another owner's row does not open.
+func Seal(key []byte, plaintext, owner string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(owner))
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value made by Seal for owner
+func Open(key []byte, sealed, owner string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", ErrInvalidSealed
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, prefix))
+	if err != nil {
+		return "", ErrInvalidSealed
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return "", ErrInvalidSealed
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(owner))
+	if err != nil {
+		return "", ErrInvalidSealed
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value was made by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,274 @@
+// Package signing loads the asymmetric keys access tokens are signed with and publishes them as a JWKS.
+//
+// Keys live in one directory as PEM files. Private keys (*.pem) are Ed25519 or RSA of at least 2048 bits,
+// the one whose file name sorts last signs new tokens and the others only verify tokens issued before a rotation.
+// Public keys (*.pub.pem) verify tokens of a key whose private half was already removed.
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSABits = 2048
+
+var (
+	ErrNoSigningKey   = errors.New("No signing key found")
+	ErrWeakKey        = errors.New("RSA keys must be at least 2048 bits")
+	ErrUnsupportedKey = errors.New("Only Ed25519 and RSA keys are supported")
+	ErrUnknownKey     = errors.New("Unknown Key ID")
+)
+
+type Key struct {
+	// RFC 7638 thumbprint, sent as the kid header
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+	// Nil for keys that only verify
+	Private crypto.Signer
+}
+
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	// Verification keys in the order they are published
+	ordered []*Key
+}
+
+// Load reads every key in dir and fails unless at least one private key is usable for signing
+func Load(dir string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	set := &KeySet{keys: map[string]*Key{}}
+
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if _, ok := set.keys[key.ID]; ok {
+			continue
+		}
+		set.keys[key.ID] = key
+		set.ordered = append(set.ordered, key)
+
+		if key.Private != nil {
+			set.signing = key
+		}
+	}
+
+	if set.signing == nil {
+		return nil, fmt.Errorf("%w in %s", ErrNoSigningKey, dir)
+	}
+
+	return set, nil
+}
+
+func loadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(parsed)
+}
+
+func newKey(parsed any) (*Key, error) {
+	key := &Key{}
+
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Private = k
+		key.Public = k.Public()
+	case *rsa.PrivateKey:
+		key.Private = k
+		key.Public = k.Public()
+	case ed25519.PublicKey, *rsa.PublicKey:
+		key.Public = k
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	switch public := key.Public.(type) {
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	case *rsa.PublicKey:
+		if public.N.BitLen() < minRSABits {
+			return nil, ErrWeakKey
+		}
+		key.Method = jwt.SigningMethodRS256
+	}
+
+	key.ID = thumbprint(jwkOf(key))
+	return key, nil
+}
+
+// Generate writes a new Ed25519 private key to dir, named so that it sorts after the existing ones and signs from the next start
+func Generate(dir string) (string, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, time.Now().UTC().Format("20060102T150405Z")+".pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Sign signs claims with the current signing key and names it in the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+
+	return token.SignedString(ks.signing.Private)
+}
+
+// Keyfunc picks the verification key named by a token's kid, for jwt.Parse
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return key.Public, nil
+}
+
+// Methods lists the algorithms of the loaded keys
+func (ks *KeySet) Methods() []string {
+	seen := map[string]bool{}
+	var methods []string
+
+	for _, key := range ks.ordered {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// JWK is the public half of a key as published in the JWKS, RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists every verification key, the signing key included
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, key := range ks.ordered {
+		jwk := jwkOf(key)
+		jwk.Kid = key.ID
+		jwk.Use = "sig"
+		jwk.Alg = key.Method.Alg()
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func jwkOf(key *Key) JWK {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch public := key.Public.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: encode(public)}
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: encode(public.N.Bytes()), E: encode(big.NewInt(int64(public.E)).Bytes())}
+	}
+	return JWK{}
+}
+
+// thumbprint hashes the required members of a JWK in lexicographic order, RFC 7638
+func thumbprint(jwk JWK) string {
+	var members []string
+	add := func(name, value string) {
+		quoted, _ := json.Marshal(value)
+		members = append(members, fmt.Sprintf("%q:%s", name, quoted))
+	}
+
+	if jwk.Kty == "OKP" {
+		add("crv", jwk.Crv)
+		add("kty", jwk.Kty)
+		add("x", jwk.X)
+	} else {
+		add("e", jwk.E)
+		add("kty", jwk.Kty)
+		add("n", jwk.N)
+	}
+
+	sum := sha256.Sum256([]byte("{" + strings.Join(members, ",") + "}"))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+// Package transfer implements the stream push and pull exchange over HTTP and SSH.
+//
+// A stream is a sequence of packets ending with "done". Every packet is a line of space
+// separated fields, object packets are followed by the raw object:
+//
+//	want <branch>
+//	have <commit>
+//	update <branch> <old commit or 00000000> <new commit> [force]
+//	ref <branch> <commit>
+//	object <hash> <size>
+//	<size bytes>
+//	ok <branch>
+//	error <branch> <message>
+//	done
+package transfer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	Want   = "want"
+	Have   = "have"
+	Update = "update"
+	Ref    = "ref"
+	Object = "object"
+	OK     = "ok"
+	Error  = "error"
+	Done   = "done"
+
+	// Old side of an update that creates the branch
+	ZeroHash = "00000000"
+	// Last field of an update that may rewrite history
+	Force = "force"
+
+	maxLineLength = 4096
+)
+
+var (
+	ErrMalformedPacket = errors.New("Malformed Packet")
+	ErrObjectTooLarge  = errors.New("Object Too Large")
+)
+
+type Packet struct {
+	Kind   string
+	Fields []string
+	// Object content, only set for object packets
+	Data []byte
+}
+
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Line writes a packet without data, fields must not contain spaces or newlines
+func (pw *Writer) Line(kind string, fields ...string) error {
+	_, err := pw.w.WriteString(strings.Join(append([]string{kind}, fields...), " ") + "\n")
+	return err
+}
+
+func (pw *Writer) Object(hash string, data []byte) error {
+	if err := pw.Line(Object, hash, strconv.Itoa(len(data))); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(data)
+	return err
+}
+
+// Done ends the stream and flushes it
+func (pw *Writer) Done() error {
+	if err := pw.Line(Done); err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+func (pw *Writer) Flush() error {
+	return pw.w.Flush()
+}
+
+type Reader struct {
+	r *bufio.Reader
+	// Largest object accepted
+	MaxObjectSize int
+}
+
+func NewReader(r io.Reader, maxObjectSize int) *Reader {
+	return &Reader{r: bufio.NewReader(r), MaxObjectSize: maxObjectSize}
+}
+
+// Next reads one packet, io.ErrUnexpectedEOF means the stream stopped before done
+func (pr *Reader) Next() (*Packet, error) {
+	line, err := pr.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(line, " ")
+	packet := &Packet{Kind: fields[0], Fields: fields[1:]}
+
+	if packet.Kind != Object {
+		return packet, nil
+	}
+
+	if len(packet.Fields) != 2 {
+		return nil, ErrMalformedPacket
+	}
+
+	size, err := strconv.Atoi(packet.Fields[1])
+	if err != nil || size < 0 {
+		return nil, ErrMalformedPacket
+	}
+	if size > pr.MaxObjectSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes", ErrObjectTooLarge, packet.Fields[0], size)
+	}
+
+	packet.Data = make([]byte, size)
+	if _, err = io.ReadFull(pr.r, packet.Data); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return packet, nil
+}
+
+func (pr *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := pr.r.ReadLine()
+		if err != nil {
+			if err == io.EOF {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+
+		line = append(line, chunk...)
+		if len(line) > maxLineLength {
+			return "", ErrMalformedPacket
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
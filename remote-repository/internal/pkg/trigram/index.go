@@ -0,0 +1,172 @@
+package trigram
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"sync"
+	"unicode/utf8"
+)
+
+// Index maps every trigram of the indexed documents to the documents containing it.
+// Trigrams are taken from lowercased content so one index serves case sensitive and insensitive queries.
+// Content is not kept, callers read it back to confirm a candidate.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[trigram]map[string]struct{}
+}
+
+// Three bytes of content packed into one value
+type trigram uint32
+
+type document struct {
+	refs int
+	// What to take the document out of when the last reference goes
+	trigrams []trigram
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     map[string]*document{},
+		postings: map[trigram]map[string]struct{}{},
+	}
+}
+
+// Add indexes a document, documents are reference counted so shared content is only indexed once
+func (ix *Index) Add(id string, content []byte) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if doc, ok := ix.docs[id]; ok {
+		doc.refs++
+		return
+	}
+
+	found := trigrams(bytes.ToLower(content))
+	doc := &document{refs: 1, trigrams: make([]trigram, 0, len(found))}
+	ix.docs[id] = doc
+
+	for t := range found {
+		posting, ok := ix.postings[t]
+		if !ok {
+			posting = map[string]struct{}{}
+			ix.postings[t] = posting
+		}
+		posting[id] = struct{}{}
+		doc.trigrams = append(doc.trigrams, t)
+	}
+}
+
+// Remove drops one reference to a document and unindexes it once nothing refers to it
+func (ix *Index) Remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	if doc.refs > 1 {
+		doc.refs--
+		return
+	}
+	delete(ix.docs, id)
+
+	for _, t := range doc.trigrams {
+		delete(ix.postings[t], id)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+}
+
+func (ix *Index) Has(id string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	_, ok := ix.docs[id]
+	return ok
+}
+
+// Candidates returns the documents that may match re, or nil and false when the
+// expression has no required literal text and every document must be scanned
+func (ix *Index) Candidates(re *syntax.Regexp) (map[string]struct{}, bool) {
+	var required []trigram
+	for _, literal := range requiredLiterals(re.Simplify()) {
+		for t := range trigrams(bytes.ToLower([]byte(literal))) {
+			required = append(required, t)
+		}
+	}
+
+	if len(required) == 0 {
+		return nil, false
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	result := map[string]struct{}{}
+	for id := range ix.postings[required[0]] {
+		result[id] = struct{}{}
+	}
+	for _, t := range required[1:] {
+		posting := ix.postings[t]
+		for id := range result {
+			if _, ok := posting[id]; !ok {
+				delete(result, id)
+			}
+		}
+	}
+	return result, true
+}
+
+func trigrams(content []byte) map[trigram]struct{} {
+	result := map[trigram]struct{}{}
+	for i := 0; i+3 <= len(content); i++ {
+		if content[i] == '\n' || content[i+1] == '\n' || content[i+2] == '\n' {
+			continue
+		}
+		result[trigram(content[i])<<16|trigram(content[i+1])<<8|trigram(content[i+2])] = struct{}{}
+	}
+	return result
+}
+
+// requiredLiterals collects runs of text every match of re must contain
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{literalString(re)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var result []string
+		var run []byte
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				run = append(run, literalString(sub)...)
+				continue
+			}
+			if len(run) > 0 {
+				result = append(result, string(run))
+				run = nil
+			}
+			result = append(result, requiredLiterals(sub)...)
+		}
+		if len(run) > 0 {
+			result = append(result, string(run))
+		}
+		return result
+	}
+	return nil
+}
+
+func literalString(re *syntax.Regexp) string {
+	buf := make([]byte, 0, len(re.Rune))
+	for _, r := range re.Rune {
+		buf = utf8.AppendRune(buf, r)
+	}
+	return string(buf)
+}
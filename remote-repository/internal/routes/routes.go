@@ -3,39 +3,143 @@ package route
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/app"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/utils"
 )
 
 func SetupRoutes(app *app.Application) *gin.Engine {
 	r := gin.Default()
 	gin.SetMode(gin.DebugMode)
+	// Client addresses feed login rate limiting, so forwarded headers are only taken from known proxies
+	if err := r.SetTrustedProxies(utils.GetTrustedProxies()); err != nil {
+		panic(err)
+	}
+	r.Use(middleware.Deadline(middleware.RequestTimeout))
 	r.GET("/health", app.CheckHealth)
 
 	r.GET("/", app.Main)
+	r.GET("/.well-known/jwks.json", app.AuthHandler.HandleJWKS) // Public keys for verifying access tokens
 
 	auth := r.Group("/auth")
-	auth.POST("/login", app.AuthHandler.HandleLogin)       // Done
-	auth.POST("/register", app.AuthHandler.HandleRegister) // Done
+	auth.POST("/login", app.AuthHandler.HandleLogin)              // Done
+	auth.POST("/login/2fa", app.AuthHandler.HandleLoginTwoFactor) // Second step when the password step returned a challenge
+	auth.POST("/register", app.AuthHandler.HandleRegister)        // Done
 
 	auth.POST("/refresh", app.AuthHandler.HandleRefresh)                                 // Done
-	auth.POST("/logout", app.AuthMiddleware.Autheticate(), app.AuthHandler.HandleLogout) // Done
+	auth.POST("/logout", app.AuthMiddleware.Autheticate(), app.AuthHandler.HandleLogout) // Signs out the current session only
+
+	auth.GET("/verify-email", app.EmailHandler.HandleVerifyEmail)                                           // Link from the verification email, ?token=
+	auth.POST("/verify-email", app.AuthMiddleware.Autheticate(), app.EmailHandler.HandleResendVerification) // Send a new verification link
+	auth.POST("/forgot-password", app.EmailHandler.HandleForgotPassword)                                    // Mail a reset token, same answer for unknown addresses
+	auth.POST("/reset-password", app.EmailHandler.HandleResetPassword)                                      // Token and new password, signs every session out
+
+	auth.GET("/oauth/:provider", app.OAuthHandler.HandleOAuthBegin)             // Redirect to google or github
+	auth.GET("/oauth/:provider/callback", app.OAuthHandler.HandleOAuthCallback) // Tokens, or a signup token for new accounts
+	auth.POST("/oauth/signup", app.OAuthHandler.HandleOAuthSignup)              // Create the account with a chosen username
+
+	r.GET("/search", app.AuthMiddleware.Autheticate(), app.AuthMiddleware.RequireScope(middleware.ScopeRepoRead), app.SearchHandler.HandleSearch) // Code search over ?q= with ?regex=, ?case_sensitive=, ?repo=owner/name, ?path= and ?ext=
+
+	settings := r.Group("/settings", app.AuthMiddleware.Autheticate(), app.AuthMiddleware.RequireScope(middleware.ScopeUser))
+	settings.GET("/tokens", app.AccessTokenHandler.HandleGetAllAccessTokens)                                                                                // List personal access tokens
+	settings.POST("/tokens", app.AuthMiddleware.RequireSignIn(), app.AuthMiddleware.RequireVerifiedEmail(), app.AccessTokenHandler.HandleCreateAccessToken) // Create a personal access token, shown once, not with another token
+	settings.DELETE("/tokens/:id", app.AccessTokenHandler.HandleRevokeAccessToken)                                                                          // Revoke a personal access token
+
+	settings.POST("/password", app.AuthHandler.HandleChangePassword) // Needs the current password, signs other sessions out
+
+	settings.GET("/sessions", app.SessionHandler.HandleGetSessions)                        // Signed in devices, the current one marked
+	settings.DELETE("/sessions/:id", app.SessionHandler.HandleRevokeSession)               // Sign one device out
+	settings.POST("/sessions/revoke-others", app.SessionHandler.HandleRevokeOtherSessions) // Sign out everywhere except the current session
+
+	settings.GET("/audit", app.AuditHandler.HandleGetUserAudit) // Security events of the account, ?action=, ?since=, ?until=, ?before=, ?limit= and ?format=jsonl
+
+	settings.GET("/invitations", app.InvitationHandler.HandleGetInvitations)                 // Pending invitations to repositories
+	settings.POST("/invitations/:id/accept", app.InvitationHandler.HandleAcceptInvitation)   // Become a collaborator with the invited role
+	settings.POST("/invitations/:id/decline", app.InvitationHandler.HandleDeclineInvitation) // Turn an invitation down
+
+	settings.GET("/transfers", app.TransferHandler.HandleGetTransfers)                 // Pending transfers to you or organizations you own
+	settings.POST("/transfers/:id/accept", app.TransferHandler.HandleAcceptTransfer)   // Move the repository, its old address redirects
+	settings.POST("/transfers/:id/decline", app.TransferHandler.HandleDeclineTransfer) // Turn a transfer down
+
+	settings.GET("/notifications", app.NotificationHandler.HandleGetNotifications)                   // Latest notifications, ?unread=true and ?limit=
+	settings.POST("/notifications/:id/read", app.NotificationHandler.HandleMarkNotificationRead)     // Mark one notification read
+	settings.POST("/notifications/read-all", app.NotificationHandler.HandleMarkAllNotificationsRead) // Mark every notification read
+
+	settings.GET("/ssh-keys", app.SSHKeyHandler.HandleGetSSHKeys)                                            // List registered SSH public keys
+	settings.POST("/ssh-keys", app.AuthMiddleware.RequireVerifiedEmail(), app.SSHKeyHandler.HandleAddSSHKey) // Register a public key in authorized_keys format
+	settings.DELETE("/ssh-keys/:id", app.SSHKeyHandler.HandleDeleteSSHKey)                                   // Remove a public key
+
+	settings.GET("/2fa", app.TwoFactorHandler.HandleGetTwoFactorStatus)                      // Whether two factor is on and how many recovery codes are left
+	settings.POST("/2fa", app.TwoFactorHandler.HandleEnrollTwoFactor)                        // New TOTP secret and otpauth URI
+	settings.POST("/2fa/enable", app.TwoFactorHandler.HandleEnableTwoFactor)                 // Confirm a code, returns recovery codes once
+	settings.POST("/2fa/disable", app.TwoFactorHandler.HandleDisableTwoFactor)               // Needs a code, refused while owning private repos
+	settings.POST("/2fa/recovery-codes", app.TwoFactorHandler.HandleRegenerateRecoveryCodes) // Needs a code, replaces every recovery code
+
+	admin := r.Group("/admin", app.AuthMiddleware.Autheticate(), app.AuthMiddleware.RequireScope(middleware.ScopeUser), app.AuthMiddleware.RequireAdmin())
+	admin.GET("/audit", app.AuditHandler.HandleGetAllAudit)        // Every security event, also by ?actor= and ?repo=owner/name
+	admin.GET("/audit/verify", app.AuditHandler.HandleVerifyAudit) // Recompute the hash chain of the audit log
+
+	admin.GET("/users", app.AdminHandler.HandleGetUsers)                                     // Accounts matching ?q=, with ?limit= and ?offset=
+	admin.POST("/users/:username/suspend", app.AdminHandler.HandleSuspendUser)               // Block sign in and end every session, needs a reason
+	admin.POST("/users/:username/unsuspend", app.AdminHandler.HandleUnsuspendUser)           // Lift a suspension
+	admin.POST("/users/:username/reset-password", app.AdminHandler.HandleForcePasswordReset) // Clear the password, sign out everywhere and mail a reset link
+	admin.GET("/repos", app.AdminHandler.HandleGetRepos)                                     // Every repository matching ?q= with its size
+	admin.DELETE("/repos/:owner/:reponame", app.AdminHandler.HandleDeleteRepo)               // Delete an abusive repository, needs a reason
+
+	orgs := r.Group("/orgs", app.AuthMiddleware.Autheticate(), app.AuthMiddleware.RequireScope(middleware.ScopeUser))
+	orgs.POST("/", app.AuthMiddleware.RequireVerifiedEmail(), app.OrgHandler.HandleCreateOrg) // Create an organization owned by the current user, its repositories live under /:org/repo
+
+	// Members of an organization can look, its owners manage members, teams and the roles teams hold
+	org := orgs.Group("/:org")
+	member, owner := app.AuthMiddleware.RequireOrgRole(false), app.AuthMiddleware.RequireOrgRole(true)
+	org.GET("/members", member, app.OrgHandler.HandleGetMembers)                               // Members with their role
+	org.PUT("/members/:username", owner, app.OrgHandler.HandleSetMember)                       // Add a member or change their role to member or owner
+	org.DELETE("/members/:username", owner, app.OrgHandler.HandleRemoveMember)                 // Remove a member from the organization and its teams
+	org.GET("/teams", member, app.OrgHandler.HandleGetTeams)                                   // Teams of the organization
+	org.POST("/teams", owner, app.OrgHandler.HandleCreateTeam)                                 // Create a team
+	org.DELETE("/teams/:team", owner, app.OrgHandler.HandleDeleteTeam)                         // Delete a team and the roles it granted
+	org.GET("/teams/:team/members", member, app.OrgHandler.HandleGetTeamMembers)               // Members of a team
+	org.PUT("/teams/:team/members/:username", owner, app.OrgHandler.HandleAddTeamMember)       // Add a member of the organization to the team
+	org.DELETE("/teams/:team/members/:username", owner, app.OrgHandler.HandleRemoveTeamMember) // Take a member off the team
+	org.GET("/teams/:team/repos", member, app.OrgHandler.HandleGetTeamRepos)                   // Repositories the team holds a role on
+	org.PUT("/teams/:team/repos/:reponame", owner, app.OrgHandler.HandleSetTeamRepo)           // Grant the team a role on a repository of the organization
+	org.DELETE("/teams/:team/repos/:reponame", owner, app.OrgHandler.HandleRemoveTeamRepo)     // Take the role of the team away
 
 	user := r.Group("/:username", app.AuthMiddleware.Autheticate())
 	user.GET("/", app.UserHandler.HandleGetProfile) // Get Profile
 
 	repo := user.Group("/repo")
-	repo.GET("/", app.RepoHandler.HandleGetAllRepos) // Get All user repos
-	repo.POST("/", app.RepoHandler.HandleCreateRepo) // Create Repository
+	repo.GET("/", app.RepoHandler.HandleGetAllRepos)                                            // Get All user repos
+	repo.POST("/", app.AuthMiddleware.RequireVerifiedEmail(), app.RepoHandler.HandleCreateRepo) // Create Repository, for yourself or an organization you own
 
+	// Every repository route names the least role it needs, see database.Role. Everyone reads public repositories.
+	// Old addresses of transferred and renamed repositories redirect to the new ones for users with a role there, remotes are served in place.
 	reponame := repo.Group("/:reponame", app.AuthMiddleware.AuthorizePrivacy())
-	reponame.GET("/", app.AuthMiddleware.AuthorizeEditAccess(), app.RepoHandler.HandleGetRepo) // Get Repo Details if public
+	role := app.AuthMiddleware.RequireRole
+	reponame.GET("/", role(database.RoleRead), app.RepoHandler.HandleGetRepo) // Get Repo Details
+
+	reponame.GET("/remote", role(database.RoleWrite), app.RepoHandler.HandleAddRemoteRepo) // Secret push and pull are signed with, readers pull with their token alone
 
-	reponame.GET("/remote", app.AuthMiddleware.AuthorizeEditAccess(), app.RepoHandler.HandleAddRemoteRepo) // Add remote if have access
+	reponame.GET("/collaborators", role(database.RoleTriage), app.RepoHandler.HandleGetCollaborators)                                          // Everyone with access and their role
+	reponame.POST("/grant", role(database.RoleAdmin), app.AuthMiddleware.RequireVerifiedEmail(), app.RepoHandler.HandleGrantAccessOnRepo)      // Invite a user with a role, or change the role of a collaborator
+	reponame.GET("/invitations", role(database.RoleAdmin), app.InvitationHandler.HandleGetRepoInvitations)                                     // Invitations waiting to be accepted
+	reponame.DELETE("/invitations/:id", role(database.RoleAdmin), app.InvitationHandler.HandleCancelInvitation)                                // Withdraw an invitation
+	reponame.POST("/revoke", role(database.RoleAdmin), app.RepoHandler.HandleRevokeAccessOnRepo)                                               // Revoke the access of a user
+	reponame.POST("/transfer", role(database.RoleAdmin), app.AuthMiddleware.RequireVerifiedEmail(), app.TransferHandler.HandleRequestTransfer) // Offer the repository to another user or organization, owners only
+	reponame.DELETE("/transfer", role(database.RoleAdmin), app.TransferHandler.HandleCancelTransfer)                                           // Withdraw a pending transfer
+	reponame.POST("/rename", role(database.RoleAdmin), app.TransferHandler.HandleRenameRepo)                                                   // Rename the repository, the old name redirects until reused
+	reponame.POST("/secret", role(database.RoleMaintain), app.RepoHandler.HandleRotateRepoSecret)                                              // Rotate the secret remote requests are signed with
+	reponame.GET("/audit", role(database.RoleAdmin), app.AuditHandler.HandleGetRepoAudit)                                                      // Security events of the repository
+	reponame.PUT("/2fa", role(database.RoleAdmin), app.TwoFactorHandler.HandleRequireTwoFactor)                                                // Require two factor from every contributor
 
-	reponame.POST("/grant", app.AuthMiddleware.AuthorizeOwnership(), app.RepoHandler.HandleGrantAccessOnRepo)   // Grant Access to a user if you are owner --> Authorization
-	reponame.POST("/revoke", app.AuthMiddleware.AuthorizeOwnership(), app.RepoHandler.HandleRevokeAccessOnRepo) // Revoke Access from a user if you are owner --> Authorization
+	transfer := middleware.Deadline(middleware.TransferTimeout)
+	reponame.POST("/push", transfer, role(database.RoleWrite), app.AuthMiddleware.RequireVerifiedEmail(), app.AuthMiddleware.VerifyRemoteSignature(), app.RepoHandler.HandlePush) // Push stream of updates and objects signed with the repository secret, also served over SSH
+	reponame.GET("/pull", transfer, role(database.RoleRead), app.AuthMiddleware.VerifyRemoteSignatureOrReader(), app.RepoHandler.HandlePull)                                      // Objects for ?branch= heads missing from ?have= commits, also served over SSH
 
-	reponame.POST("/push", app.AuthMiddleware.AuthorizeEditAccess(), app.RepoHandler.HandlePush) // Push if have access
-	reponame.GET("/pull", app.AuthMiddleware.AuthorizeEditAccess(), app.RepoHandler.HandlePull)  // Pull if have access
+	reponame.GET("/branches", role(database.RoleRead), app.RepoHandler.HandleGetBranches)           // Branches with ahead/behind counts against the default branch
+	reponame.GET("/compare", role(database.RoleRead), app.RepoHandler.HandleCompare)                // Compare ?base= with ?head=, base defaults to the default branch
+	reponame.GET("/blame/*path", role(database.RoleRead), app.RepoHandler.HandleBlame)              // Blame a file at ?rev= limited to ?start=&end= lines
+	reponame.GET("/archive/*ref", transfer, role(database.RoleRead), app.RepoHandler.HandleArchive) // Download <ref>.tar.gz or <ref>.zip, optional ?prefix= and ?path=
 
 	r.NoRoute(app.NotFound)
 
@@ -47,5 +151,5 @@ func SetupRoutes(app *app.Application) *gin.Engine {
 2- Testing using Insomnia
 3- C++ Add commands
 5- Push/Pull Service with AWS S3
-6- Add redis for caching and logout 
+6- Add redis for caching and logout -- Done
 */
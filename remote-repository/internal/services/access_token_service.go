@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+)
+
+var (
+	ErrInvalidScope         = errors.New("Invalid Scope")
+	ErrInvalidTokenName     = errors.New("Invalid Token Name")
+	ErrInvalidTokenExpiry   = errors.New("Token expiry must be between 1 and 365 days")
+	ErrTokenNameAlreadyUsed = errors.New("Token Name Already Used")
+)
+
+const (
+	defaultAccessTokenDays = 30
+	maxAccessTokenDays     = 365
+)
+
+type AccessTokenService struct {
+	AccessTokenStore database.AccessTokenStore
+	Authentication   *middleware.AuthenticationMiddleware
+	Audit            *AuditService
+}
+
+// Create issues a personal access token, the plaintext is returned once and never stored
+func (as *AccessTokenService) Create(username, name string, scopes []string, expiresInDays int, ip string) (*database.AccessToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, "", ErrInvalidTokenName
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !middleware.IsValidScope(scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	if expiresInDays == 0 {
+		expiresInDays = defaultAccessTokenDays
+	}
+	if expiresInDays < 1 || expiresInDays > maxAccessTokenDays {
+		return nil, "", ErrInvalidTokenExpiry
+	}
+
+	secret, err := as.Authentication.GenerateAccessToken(username)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := middleware.PersonalAccessTokenPrefix + strings.TrimRight(secret, "=")
+
+	now := time.Now()
+	token, err := as.AccessTokenStore.CreateAccessToken(&database.AccessToken{
+		Username:  username,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, expiresInDays),
+	}, plaintext)
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, "", ErrTokenNameAlreadyUsed
+		}
+		return nil, "", err
+	}
+
+	as.Audit.Record(database.AuditEvent{
+		Action:    AuditAccessTokenCreate,
+		Actor:     username,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"id": strconv.Itoa(token.ID), "name": name, "scopes": strings.Join(scopes, " ")},
+	})
+	return token, plaintext, nil
+}
+
+func (as *AccessTokenService) GetAll(username string) ([]database.AccessToken, error) {
+	return as.AccessTokenStore.GetAllAccessTokens(username)
+}
+
+func (as *AccessTokenService) Revoke(username string, id int, ip string) error {
+	if err := as.AccessTokenStore.RevokeAccessToken(username, id); err != nil {
+		return err
+	}
+
+	as.Audit.Record(database.AuditEvent{Action: AuditAccessTokenRevoke, Actor: username, Target: username, IPAddress: ip, Details: map[string]string{"id": strconv.Itoa(id)}})
+	return nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+)
+
+var ErrSuspendSelf = errors.New("Administrators cannot suspend themselves")
+
+const (
+	defaultAdminPage = 50
+	maxAdminPage     = 200
+)
+
+// Page of an admin listing, Limit is clamped to a sane page size
+type AdminPage struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+func (p *AdminPage) clamp() {
+	if p.Limit <= 0 {
+		p.Limit = defaultAdminPage
+	}
+	if p.Limit > maxAdminPage {
+		p.Limit = maxAdminPage
+	}
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+}
+
+// AdminService holds what site administrators can do to any account or repository, every call is audited
+type AdminService struct {
+	UserStore      database.UserStore
+	RepoStore      database.RepoStore
+	TokenStore     database.TokenStore
+	SSHKeyStore    database.SSHKeyStore
+	Objects        objects.Store
+	Email          *EmailService
+	Audit          *AuditService
+	SearchService  *SearchService
+	Authentication *middleware.AuthenticationMiddleware
+	Logger         *slog.Logger
+	// A push still writing objects would recreate the directory of a deleted repository
+	Locks *RepoLocks
+}
+
+func (as *AdminService) Users(admin, ip string, page AdminPage) ([]database.User, error) {
+	page.clamp()
+
+	users, err := as.UserStore.SearchUsers(page.Query, page.Limit, page.Offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	as.Audit.Record(database.AuditEvent{Action: AuditAdminUserSearch, Actor: admin, IPAddress: ip, Details: map[string]string{"query": page.Query}})
+	return users, nil
+}
+
+// Suspend blocks every way of signing in as username and ends their sessions at once
+func (as *AdminService) Suspend(admin, username, reason, ip string) error {
+	if admin == username {
+		return ErrSuspendSelf
+	}
+
+	if err := as.UserStore.SetSuspended(username, true, reason); err != nil {
+		return err
+	}
+
+	if err := as.signOut(username); err != nil {
+		return err
+	}
+
+	as.Audit.Record(database.AuditEvent{
+		Action:    AuditAdminSuspend,
+		Actor:     admin,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"reason": reason},
+	})
+	return nil
+}
+
+// Unsuspend lets username sign in again, personal access tokens that did not expire work again
+func (as *AdminService) Unsuspend(admin, username, ip string) error {
+	if err := as.UserStore.SetSuspended(username, false, ""); err != nil {
+		return err
+	}
+
+	as.Audit.Record(database.AuditEvent{Action: AuditAdminUnsuspend, Actor: admin, Target: username, IPAddress: ip})
+	return nil
+}
+
+// ForcePasswordReset clears the password of username, signs every session out, revokes their personal access tokens,
+// deletes their SSH keys and mails a reset link. Until the link is used the account can only be signed in to through OAuth.
+func (as *AdminService) ForcePasswordReset(admin, username, ip string) error {
+	user, err := as.UserStore.GetUserbyUsername(username)
+
+	if err != nil {
+		return err
+	}
+
+	if err = as.UserStore.UpdatePassword(username, ""); err != nil {
+		return err
+	}
+
+	if err = as.signOut(username); err != nil {
+		return err
+	}
+
+	// Whoever took the password over may have added credentials that outlive it
+	if err = as.Authentication.AccessTokenStore.RevokeAllAccessTokens(username); err != nil {
+		return err
+	}
+
+	if err = as.SSHKeyStore.DeleteAllSSHKeys(username); err != nil {
+		return err
+	}
+
+	as.Audit.Record(database.AuditEvent{Action: AuditAdminPasswordReset, Actor: admin, Target: username, IPAddress: ip})
+
+	// The password is already cleared, the user can still ask for a link from /auth/forgot-password
+	if err = as.Email.SendForcedPasswordReset(user); err != nil {
+		as.Logger.Error("Error sending forced password reset email", "username", username, "error", err)
+	}
+	return nil
+}
+
+// Repos lists every repository, private ones included, with the size of its stored objects
+func (as *AdminService) Repos(admin, ip string, page AdminPage) ([]database.Repository, error) {
+	page.clamp()
+
+	repos, err := as.RepoStore.SearchRepos(page.Query, page.Limit, page.Offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range repos {
+		if repos[i].SizeBytes, err = as.Objects.Size(repos[i].RepoOwner, repos[i].RepoName); err != nil {
+			return nil, err
+		}
+	}
+
+	as.Audit.Record(database.AuditEvent{Action: AuditAdminRepoList, Actor: admin, IPAddress: ip, Details: map[string]string{"query": page.Query}})
+	return repos, nil
+}
+
+// DeleteRepo removes an abusive repository together with its objects
+func (as *AdminService) DeleteRepo(admin, owner, name, reason, ip string) error {
+	unlock := as.Locks.Lock(owner, name)
+	defer unlock()
+
+	if err := as.RepoStore.DeleteRepo(owner, name); err != nil {
+		return err
+	}
+
+	as.Audit.Record(database.AuditEvent{
+		Action:    AuditAdminRepoDelete,
+		Actor:     admin,
+		Target:    owner,
+		RepoOwner: owner,
+		RepoName:  name,
+		IPAddress: ip,
+		Details:   map[string]string{"reason": reason},
+	})
+
+	// Without branches left reindexing drops the repository from the search index
+	if err := as.SearchService.IndexRepo(owner, name); err != nil {
+		as.Logger.Error("Error dropping deleted repository from the search index", "repo", owner+"/"+name, "error", err)
+	}
+
+	// The rows are gone, objects left behind are unreachable and only take space
+	if err := as.Objects.RemoveRepo(owner, name); err != nil {
+		as.Logger.Error("Error removing objects of deleted repository", "repo", owner+"/"+name, "error", err)
+	}
+	return nil
+}
+
+// signOut stops the access tokens of every session of username and revokes their refresh tokens
+func (as *AdminService) signOut(username string) error {
+	if err := denySessions(as.Authentication, as.TokenStore, username, ""); err != nil {
+		return err
+	}
+
+	if err := as.TokenStore.RevokeAllTokens(username); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
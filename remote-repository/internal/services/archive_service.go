@@ -0,0 +1,210 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+)
+
+var (
+	ErrUnsupportedArchiveFormat = errors.New("Unsupported Archive Format")
+	ErrInvalidArchivePrefix     = errors.New("Invalid Archive Prefix")
+	ErrDirectoryNotFound        = errors.New("Directory not found")
+)
+
+const (
+	ArchiveTarGz = ".tar.gz"
+	ArchiveZip   = ".zip"
+
+	archiveFileMode = 0o644
+	archiveDirMode  = 0o755
+)
+
+type ArchiveService struct {
+	RepoStore database.RepoStore
+	Objects   objects.Store
+}
+
+// Archive is a resolved snapshot ready to be streamed
+type Archive struct {
+	Format   string
+	Commit   *objects.Commit
+	repo     *objects.Repo
+	treeHash string
+	prefix   string
+	modTime  time.Time
+}
+
+// SplitArchiveName splits "main.tar.gz" into the ref and the archive format
+func SplitArchiveName(name string) (string, string, error) {
+	for _, format := range []string{ArchiveTarGz, ArchiveZip} {
+		if ref, ok := strings.CutSuffix(name, format); ok && ref != "" {
+			return ref, format, nil
+		}
+	}
+	return "", "", ErrUnsupportedArchiveFormat
+}
+
+// Prepare resolves ref and the optional subdirectory before anything is written,
+// so lookup failures can still be reported to the client
+func (as *ArchiveService) Prepare(username, reponame, ref, format, prefix, subdir string) (*Archive, error) {
+	if format != ArchiveTarGz && format != ArchiveZip {
+		return nil, ErrUnsupportedArchiveFormat
+	}
+
+	if prefix != "" {
+		prefix = path.Clean(prefix)
+		if path.IsAbs(prefix) || prefix == ".." || strings.HasPrefix(prefix, "../") {
+			return nil, ErrInvalidArchivePrefix
+		}
+		if prefix == "." {
+			prefix = ""
+		} else {
+			prefix += "/"
+		}
+	}
+
+	repo := &objects.Repo{Store: as.Objects, Owner: username, Name: reponame}
+
+	commit, err := ResolveRef(as.RepoStore, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := repo.FindTree(commit.TreeHash, subdir)
+	if err != nil {
+		if errors.Is(err, objects.ErrPathNotFound) {
+			return nil, ErrDirectoryNotFound
+		}
+		return nil, err
+	}
+
+	return &Archive{
+		Format:   format,
+		Commit:   commit,
+		repo:     repo,
+		treeHash: tree.Hash,
+		prefix:   prefix,
+		modTime:  commit.Time(),
+	}, nil
+}
+
+func (a *Archive) Write(w io.Writer) error {
+	if a.Format == ArchiveZip {
+		return a.writeZip(w)
+	}
+	return a.writeTarGz(w)
+}
+
+func (a *Archive) writeTarGz(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	gz.ModTime = a.modTime
+	tw := tar.NewWriter(gz)
+
+	if a.prefix != "" {
+		err := tw.WriteHeader(a.tarHeader(a.prefix, tar.TypeDir, 0))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := a.repo.Walk(a.treeHash, func(filePath string, entry objects.TreeEntry) error {
+		if entry.Type == "tree" {
+			return tw.WriteHeader(a.tarHeader(a.prefix+filePath+"/", tar.TypeDir, 0))
+		}
+
+		blob, err := a.repo.ReadBlob(entry.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err = tw.WriteHeader(a.tarHeader(a.prefix+filePath, tar.TypeReg, int64(len(blob.Content)))); err != nil {
+			return err
+		}
+		_, err = tw.Write(blob.Content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (a *Archive) tarHeader(name string, typeflag byte, size int64) *tar.Header {
+	mode := int64(archiveFileMode)
+	if typeflag == tar.TypeDir {
+		mode = archiveDirMode
+	}
+
+	return &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Size:     size,
+		Mode:     mode,
+		ModTime:  a.modTime,
+		Format:   tar.FormatPAX,
+	}
+}
+
+func (a *Archive) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	if a.prefix != "" {
+		if _, err := zw.CreateHeader(a.zipHeader(a.prefix, true)); err != nil {
+			return err
+		}
+	}
+
+	err := a.repo.Walk(a.treeHash, func(filePath string, entry objects.TreeEntry) error {
+		if entry.Type == "tree" {
+			_, err := zw.CreateHeader(a.zipHeader(a.prefix+filePath+"/", true))
+			return err
+		}
+
+		blob, err := a.repo.ReadBlob(entry.Hash)
+		if err != nil {
+			return err
+		}
+
+		fw, err := zw.CreateHeader(a.zipHeader(a.prefix+filePath, false))
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(blob.Content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func (a *Archive) zipHeader(name string, dir bool) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: a.modTime,
+	}
+
+	if dir {
+		header.Method = zip.Store
+		header.SetMode(fs.ModeDir | archiveDirMode)
+	} else {
+		header.SetMode(archiveFileMode)
+	}
+	return header
+}
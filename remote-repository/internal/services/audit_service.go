@@ -0,0 +1,123 @@
+package services
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+)
+
+// Audited actions
+const (
+	AuditLogin              = "user.login"
+	AuditLoginFailed        = "user.login_failed"
+	AuditLoginLocked        = "user.login_locked"
+	AuditTwoFactorFailed    = "user.two_factor_failed"
+	AuditTokenRefresh       = "user.token_refresh"
+	AuditRefreshTokenReused = "user.refresh_token_reused"
+	AuditLogout             = "user.logout"
+	AuditSessionRevoke      = "user.session_revoke"
+	AuditPasswordChange     = "user.password_change"
+	AuditPasswordReset      = "user.password_reset"
+	AuditTwoFactorEnable    = "user.two_factor_enable"
+	AuditTwoFactorDisable   = "user.two_factor_disable"
+	AuditRecoveryCodesReset = "user.recovery_codes_regenerate"
+	AuditAccessTokenCreate  = "user.access_token_create"
+	AuditAccessTokenRevoke  = "user.access_token_revoke"
+	AuditSSHKeyAdd          = "user.ssh_key_add"
+	AuditSSHKeyDelete       = "user.ssh_key_delete"
+
+	AuditRepoCreate        = "repo.create"
+	AuditRepoAccessGrant   = "repo.access_grant"
+	AuditRepoAccessRevoke  = "repo.access_revoke"
+	AuditRepoSecretFetch   = "repo.secret_fetch"
+	AuditRepoSecretRotate  = "repo.secret_rotate"
+	AuditRepoForcePush     = "repo.force_push"
+	AuditRepoTwoFactorRule = "repo.two_factor_requirement"
+	AuditRepoInvite        = "repo.invite"
+	AuditRepoInviteCancel  = "repo.invite_cancel"
+	AuditRepoInviteAccept  = "repo.invite_accept"
+	AuditRepoInviteDecline = "repo.invite_decline"
+
+	AuditRepoTransferRequest = "repo.transfer_request"
+	AuditRepoTransferCancel  = "repo.transfer_cancel"
+	AuditRepoTransferDecline = "repo.transfer_decline"
+	AuditRepoTransfer        = "repo.transfer"
+	AuditRepoRename          = "repo.rename"
+
+	AuditOrgCreate           = "org.create"
+	AuditOrgMemberSet        = "org.member_set"
+	AuditOrgMemberRemove     = "org.member_remove"
+	AuditOrgTeamCreate       = "org.team_create"
+	AuditOrgTeamDelete       = "org.team_delete"
+	AuditOrgTeamMemberAdd    = "org.team_member_add"
+	AuditOrgTeamMemberRemove = "org.team_member_remove"
+	AuditOrgTeamRepoGrant    = "org.team_repo_grant"
+	AuditOrgTeamRepoRevoke   = "org.team_repo_revoke"
+
+	AuditAdminUserSearch    = "admin.user_search"
+	AuditAdminSuspend       = "admin.suspend"
+	AuditAdminUnsuspend     = "admin.unsuspend"
+	AuditAdminPasswordReset = "admin.force_password_reset"
+	AuditAdminRepoList      = "admin.repo_list"
+	AuditAdminRepoDelete    = "admin.repo_delete"
+	AuditAdminAuditRead     = "admin.audit_read"
+)
+
+const (
+	defaultAuditPage = 100
+	maxAuditPage     = 1000
+)
+
+type AuditService struct {
+	AuditStore database.AuditStore
+	Logger     *slog.Logger
+}
+
+// Record appends an event, a failure is logged rather than failing the action being audited
+func (as *AuditService) Record(event database.AuditEvent) {
+	event.CreatedAt = time.Now()
+
+	if err := as.AuditStore.AppendAuditEvent(&event); err != nil {
+		as.Logger.Error("SECURITY: failed to write audit event", "action", event.Action, "actor", event.Actor, "error", err)
+	}
+}
+
+// Events returns one page of events, the limit is clamped to a sane page size
+func (as *AuditService) Events(filter database.AuditFilter) ([]database.AuditEvent, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditPage
+	}
+	if filter.Limit > maxAuditPage {
+		filter.Limit = maxAuditPage
+	}
+
+	return as.AuditStore.GetAuditEvents(filter)
+}
+
+// Export calls write with every matching event, newest first, one page at a time
+func (as *AuditService) Export(filter database.AuditFilter, write func(database.AuditEvent) error) error {
+	filter.Limit = maxAuditPage
+
+	for {
+		events, err := as.AuditStore.GetAuditEvents(filter)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err = write(event); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < filter.Limit {
+			return nil
+		}
+		filter.BeforeID = events[len(events)-1].ID
+	}
+}
+
+func (as *AuditService) Verify() (*database.AuditVerification, error) {
+	return as.AuditStore.VerifyAuditChain()
+}
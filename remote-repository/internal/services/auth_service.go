@@ -4,10 +4,13 @@ import (
 	"database/sql"
 	"errors"
 	"regexp"
+	"sync"
+	"time"
 
 	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/cache"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/utils"
 )
@@ -23,60 +26,333 @@ var (
 	ErrEmailAlreadyExists  = errors.New("Email Already Exists")
 	ErrUserNotFound        = errors.New("User not found")
 	ErrIncorrectPassword   = errors.New("Password is not correct")
+	ErrRevokedToken        = errors.New("Revoked Token")
+	ErrRefreshTokenReused  = errors.New("Refresh Token Reused")
+	ErrSessionExpired      = errors.New("Session expired, please log in again")
+	ErrInvalidChallenge    = errors.New("Invalid Two Factor Challenge")
+	ErrNoPasswordSet       = errors.New("Account has no password, set one with forgot password")
 )
 
+const (
+	twoFactorChallengeTimeout = 5 * time.Minute
+	// Wrong codes a challenge survives before the password step has to be repeated
+	maxTwoFactorAttempts = 5
+)
+
+// Usernames that would shadow a top level route
+var reservedUsernames = map[string]bool{
+	"admin":    true,
+	"orgs":     true,
+	"search":   true,
+	"settings": true,
+}
+
+// OAuth sign in lives in OAuthService
 type AuthService struct {
 	UserStore  database.UserStore
 	TokenStore database.TokenStore
+	TwoFactor  *TwoFactorService
+	Email      *EmailService
+	Limiter    *LoginLimiter
+	Audit      *AuditService
 	// Middleware
 	Authentication *middleware.AuthenticationMiddleware
-	// OAuth
-	googleClientID string
-	googleSecret   string
-	githubClientID string
-	githubSecret   string
+	// Holds open two factor challenges, shared by every instance with Redis
+	Cache cache.Cache
 }
 
-func NewAuthService(userstore database.UserStore, tokenstore database.TokenStore, authentication *middleware.AuthenticationMiddleware) *AuthService {
+// Cache keys of a challenge: the user it signs in, wrong codes tried and whether it was used
+const (
+	challengePrefix         = "login:challenge:"
+	challengeAttemptsPrefix = "login:challenge_attempts:"
+	challengeUsedPrefix     = "login:challenge_used:"
+)
+
+func NewAuthService(userstore database.UserStore, tokenstore database.TokenStore, twoFactor *TwoFactorService, email *EmailService, audit *AuditService, shared cache.Cache, authentication *middleware.AuthenticationMiddleware) *AuthService {
 	return &AuthService{
+		Audit:          audit,
 		Authentication: authentication,
-		UserStore:  userstore,
-		TokenStore: tokenstore,
-		// googleClientID: os.Getenv("GOOGLE_KEY"),
-		// googleSecret:   os.Getenv("GOOGLE_SECRET"),
-		// githubClientID: os.Getenv("GITHUB_KEY"),
-		// githubSecret:   os.Getenv("GITHUB_SECRET"),
+		UserStore:      userstore,
+		TokenStore:     tokenstore,
+		TwoFactor:      twoFactor,
+		Email:          email,
+		Limiter:        NewLoginLimiter(authentication.Logger, audit, shared),
+		Cache:          shared,
+	}
+}
+
+// Login checks the password, users with two factor enabled get a challenge to complete with CompleteLogin instead of tokens.
+// Unknown users and wrong passwords both fail with ErrInvalidCredentials after the same work.
+// Failures of the account are only cleared once tokens are issued, a correct password alone does not clear them.
+func (ah *AuthService) Login(username, password string, device database.Device) (*models.TokenResponse, *models.TwoFactorChallenge, error) {
+	ip := device.IPAddress
+	if err := ah.Limiter.Check(username, ip); err != nil {
+		if errors.Is(err, ErrTooManyAttempts) {
+			ah.auditLoginFailure(username, ip, "rate_limited")
+		}
+		return nil, nil, err
+	}
+
+	user, err := ah.UserStore.GetUserbyUsername(username)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	var pass hashing.Password
+	pass.Hash = dummyPasswordHash()
+	if user != nil && user.PasswordHash != "" {
+		pass.Hash = []byte(user.PasswordHash)
+	}
+
+	if ok, _ := pass.MatchPassword([]byte(password)); !ok || user == nil || user.PasswordHash == "" {
+		ah.Limiter.Failure(username, ip)
+		ah.auditLoginFailure(username, ip, "invalid_credentials")
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	ah.upgradeHash(user.Username, &pass, password)
+
+	tokens, challenge, err := ah.SignIn(user.Username, device)
+	if tokens != nil {
+		ah.Limiter.Success(user.Username)
+	}
+	return tokens, challenge, err
+}
+
+// upgradeHash re-hashes a verified password made with an outdated algorithm or cost, failures only delay the upgrade
+func (ah *AuthService) upgradeHash(username string, pass *hashing.Password, password string) {
+	if !pass.NeedsRehash() {
+		return
+	}
+
+	var upgraded hashing.Password
+	if err := upgraded.Set(password); err != nil {
+		ah.Authentication.Logger.Error("Error upgrading password hash", "username", username, "error", err)
+		return
+	}
+
+	if err := ah.UserStore.UpdatePassword(username, string(upgraded.Hash)); err != nil {
+		ah.Authentication.Logger.Error("Error upgrading password hash", "username", username, "error", err)
 	}
 }
 
-func (ah *AuthService) Login(username, password string) (*models.TokenResponse, error) {
+// ChangePassword replaces the password of a signed in user, signs every other session out and revokes
+// every personal access token, a password changed because it leaked must not leave tokens made with it working.
+// Wrong current passwords count against the login rate limits.
+func (ah *AuthService) ChangePassword(username, currentPassword, newPassword, session, ip string) error {
+	if err := ah.Limiter.Check(username, ip); err != nil {
+		if errors.Is(err, ErrTooManyAttempts) {
+			ah.auditLoginFailure(username, ip, "rate_limited")
+		}
+		return err
+	}
+
 	user, err := ah.UserStore.GetUserbyUsername(username)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, ErrUserNotFound
+		return err
+	}
+
+	if user.PasswordHash == "" {
+		return ErrNoPasswordSet
+	}
+
+	pass := hashing.Password{Hash: []byte(user.PasswordHash)}
+	if ok, _ := pass.MatchPassword([]byte(currentPassword)); !ok {
+		ah.Limiter.Failure(username, ip)
+		ah.auditLoginFailure(username, ip, "wrong_current_password")
+		return ErrIncorrectPassword
+	}
+
+	if !utils.IsValidPassword(newPassword) {
+		return ErrInvalidPassword
+	}
+
+	if err = pass.Set(newPassword); err != nil {
+		return ErrInvalidPassword
+	}
+
+	if err = ah.UserStore.UpdatePassword(username, string(pass.Hash)); err != nil {
+		return err
+	}
+
+	if err = denySessions(ah.Authentication, ah.TokenStore, username, session); err != nil {
+		return err
+	}
+
+	if err = ah.TokenStore.RevokeOtherSessions(username, session); err != nil {
+		return err
+	}
+
+	if err = ah.Authentication.AccessTokenStore.RevokeAllAccessTokens(username); err != nil {
+		return err
+	}
+
+	ah.Audit.Record(database.AuditEvent{Action: AuditPasswordChange, Actor: username, Target: username, IPAddress: ip})
+	return nil
+}
+
+// Failed logins are recorded under the username that was tried, it may not exist
+func (ah *AuthService) auditLoginFailure(username, ip, reason string) {
+	ah.Audit.Record(database.AuditEvent{
+		Action:    AuditLoginFailed,
+		Actor:     username,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"reason": reason},
+	})
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash is compared against when there is no real hash so that
+// unknown users take as long to reject as wrong passwords
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		var pass hashing.Password
+		pass.Set("unused dummy password")
+		dummyHash = pass.Hash
+	})
+	return dummyHash
+}
+
+// SignIn finishes a first factor, password or OAuth, with tokens or a two factor challenge
+func (ah *AuthService) SignIn(username string, device database.Device) (*models.TokenResponse, *models.TwoFactorChallenge, error) {
+	if err := ah.Authentication.CheckSuspended(username); err != nil {
+		if err == middleware.ErrAccountSuspended {
+			ah.auditLoginFailure(username, device.IPAddress, "suspended")
+		}
+		return nil, nil, err
+	}
+
+	enabled, err := ah.TwoFactor.IsEnabled(username)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if enabled {
+		challenge, err := ah.startChallenge(username)
+		return nil, challenge, err
+	}
+
+	tokens, err := ah.issueTokens(username, device)
+	return tokens, nil, err
+}
+
+// CompleteLogin exchanges a challenge and a TOTP or recovery code for tokens.
+// Wrong codes count against the login limits of the account and the address like wrong passwords,
+// so opening new challenges does not give more guesses.
+func (ah *AuthService) CompleteLogin(challengeToken, code string, device database.Device) (*models.TokenResponse, error) {
+	username, err := ah.Cache.Get(challengePrefix + challengeToken)
+
+	if err != nil {
+		if errors.Is(err, cache.ErrMiss) {
+			return nil, ErrInvalidChallenge
 		}
 		return nil, err
 	}
 
-	if user.Username == "" || string(user.PasswordHash) == "" || user.EmailAddress == "" {
-		return nil, ErrInvalidCredentials
+	ip := device.IPAddress
+	if err = ah.Limiter.Check(username, ip); err != nil {
+		if errors.Is(err, ErrTooManyAttempts) {
+			ah.dropChallenge(challengeToken)
+			ah.auditLoginFailure(username, ip, "rate_limited")
+		}
+		return nil, err
 	}
 
-	var pass hashing.Password
-	pass.Hash = []byte(user.PasswordHash)
+	err = ah.TwoFactor.Verify(username, code)
+
+	if err != nil {
+		if err == ErrInvalidTwoFactorCode {
+			ah.Limiter.Failure(username, ip)
+			ah.Audit.Record(database.AuditEvent{Action: AuditTwoFactorFailed, Actor: username, Target: username, IPAddress: ip})
+
+			attempts, cacheErr := ah.Cache.Increment(challengeAttemptsPrefix+challengeToken, twoFactorChallengeTimeout)
+			if cacheErr != nil || attempts >= maxTwoFactorAttempts {
+				ah.dropChallenge(challengeToken)
+			}
+		}
+		return nil, err
+	}
+
+	// Completed concurrently with another request, possibly on another instance
+	first, err := ah.Cache.Add(challengeUsedPrefix+challengeToken, "1", twoFactorChallengeTimeout)
+
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, ErrInvalidChallenge
+	}
+	ah.dropChallenge(challengeToken)
+
+	tokens, err := ah.issueTokens(username, device)
+	if err != nil {
+		return nil, err
+	}
+
+	ah.Limiter.Success(username)
+	return tokens, nil
+}
+
+func (ah *AuthService) dropChallenge(challengeToken string) {
+	if err := ah.Cache.Delete(challengePrefix + challengeToken); err != nil {
+		ah.Authentication.Logger.Error("Error dropping two factor challenge", "error", err)
+	}
+}
+
+func (ah *AuthService) startChallenge(username string) (*models.TwoFactorChallenge, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ah.Cache.Set(challengePrefix+token, username, twoFactorChallengeTimeout); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorChallenge{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(twoFactorChallengeTimeout.Seconds()),
+	}, nil
+}
 
-	if ok,_ := pass.MatchPassword([]byte(password)); !ok {
-		return nil, ErrIncorrectPassword
+// issueTokens starts a new session for device
+func (ah *AuthService) issueTokens(username string, device database.Device) (*models.TokenResponse, error) {
+	// Suspended while a two factor challenge was open
+	if err := ah.Authentication.CheckSuspended(username); err != nil {
+		return nil, err
+	}
+
+	family, err := ah.Authentication.GenerateAccessToken(username)
+	if err != nil {
+		return nil, err
 	}
 
-	tokens, err := ah.GenerateAccessTokens(user.Username)
+	tokens, err := ah.GenerateAccessTokens(username, family)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = ah.TokenStore.StoreRefreshToken(username, tokens.RefreshToken)
+	err = ah.TokenStore.StoreRefreshToken(username, tokens.RefreshToken, family, device)
+
+	if err == nil {
+		ah.Audit.Record(database.AuditEvent{
+			Action:    AuditLogin,
+			Actor:     username,
+			Target:    username,
+			IPAddress: device.IPAddress,
+			Details:   map[string]string{"user_agent": device.UserAgent},
+		})
+	}
 
 	if err != nil {
 		return nil, err
@@ -88,34 +364,48 @@ func (ah *AuthService) Login(username, password string) (*models.TokenResponse,
 	}, nil
 }
 
-func (ah *AuthService) Register(username, password, fullname, email string) (*models.TokenResponse, error) {
+// ValidateNewUser checks that username and email are well formed and not taken
+func (ah *AuthService) ValidateNewUser(username, email string) error {
 	_, err := ah.UserStore.GetUserbyUsername(username)
 
 	if err != sql.ErrNoRows {
 		if err != nil {
-			return nil, err
+			return err
 		}
-		return nil, ErrUserAlreadyExists
+		return ErrUserAlreadyExists
 	}
 
 	_, err = ah.UserStore.GetUserbyEmailAddress(email)
 
 	if err != sql.ErrNoRows {
 		if err != nil {
-			return nil, err
+			return err
 		}
-		return nil, ErrEmailAlreadyExists
+		return ErrEmailAlreadyExists
 	}
 
 	// Check Credentials with Regular Expressions -> Done at client side ,too
 	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 	if !emailRegex.MatchString(email) {
-		return nil, ErrInvalidEmailAddress
+		return ErrInvalidEmailAddress
+	}
+
+	if !isValidUsername(username) {
+		return ErrInvalidUsername
 	}
 
+	return nil
+}
+
+// isValidUsername holds for organization names too, they share the namespace
+func isValidUsername(username string) bool {
 	usernameRegex := regexp.MustCompile(`^[a-z0-9_-]{5,20}$`)
-	if !usernameRegex.MatchString(username) {
-		return nil, ErrInvalidUsername
+	return usernameRegex.MatchString(username) && !reservedUsernames[username]
+}
+
+func (ah *AuthService) Register(username, password, fullname, email string, device database.Device) (*models.TokenResponse, error) {
+	if err := ah.ValidateNewUser(username, email); err != nil {
+		return nil, err
 	}
 
 	if !utils.IsValidPassword(password) {
@@ -124,7 +414,9 @@ func (ah *AuthService) Register(username, password, fullname, email string) (*mo
 
 	// Create User
 	var pass hashing.Password
-	pass.Set(password)
+	if err := pass.Set(password); err != nil {
+		return nil, ErrInvalidPassword
+	}
 
 	registeredUser := &database.User{
 		Username:     username,
@@ -133,23 +425,22 @@ func (ah *AuthService) Register(username, password, fullname, email string) (*mo
 		EmailAddress: email,
 	}
 
-	// Generate Tokens - Store Refresh Token
-	tokens, err := ah.GenerateAccessTokens(registeredUser.Username)
+	_, err := ah.UserStore.CreateUser(registeredUser)
 
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = ah.UserStore.CreateUser(registeredUser)
+	// Generate Tokens - Store Refresh Token
+	tokens, err := ah.issueTokens(username, device)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = ah.TokenStore.StoreRefreshToken(username, tokens.RefreshToken)
-
-	if err != nil {
-		return nil, err
+	// The account exists either way, the link can be requested again
+	if err = ah.Email.SendVerification(username); err != nil {
+		ah.Authentication.Logger.Error("Error sending verification email", "username", username, "error", err)
 	}
 	//Return Tokens
 	return &models.TokenResponse{
@@ -159,9 +450,9 @@ func (ah *AuthService) Register(username, password, fullname, email string) (*mo
 	}, nil
 }
 
-func (ah *AuthService) GenerateAccessTokens(username string) (*models.TokenResponse, error) {
+func (ah *AuthService) GenerateAccessTokens(username, session string) (*models.TokenResponse, error) {
 	// Generate Access Tokens JWT
-	accessToken, err := ah.Authentication.GenerateJWTToken(username)
+	accessToken, err := ah.Authentication.GenerateJWTToken(username, session)
 	if err != nil {
 		return nil, err
 	}
@@ -175,4 +466,174 @@ func (ah *AuthService) GenerateAccessTokens(username string) (*models.TokenRespo
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 	}, nil
-}
\ No newline at end of file
+}
+
+// Refresh rotates a refresh token: the presented token is revoked and its successor issued in the same family.
+// Presenting a token that was already rotated means it leaked, so the whole family is revoked.
+// A family older than MaxSession is not rotated anymore, however recently it was used.
+func (ah *AuthService) Refresh(refreshToken string, device database.Device) (*models.TokenResponse, error) {
+	token, err := ah.TokenStore.GetRefreshToken(refreshToken)
+
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if token.Revoked {
+		if token.ReplacedBy != "" {
+			return nil, ah.revokeReusedFamily(token, device)
+		}
+		return nil, ErrRevokedToken
+	}
+
+	if time.Now().After(token.CreatedAt.Add(ah.Authentication.MaxRefresh)) {
+		return nil, ErrExpiredToken
+	}
+
+	if time.Now().After(token.FamilyCreatedAt.Add(ah.Authentication.MaxSession)) {
+		if err = ah.TokenStore.RevokeTokenFamily(token.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionExpired
+	}
+
+	if err = ah.Authentication.CheckSuspended(token.Username); err != nil {
+		return nil, err
+	}
+
+	tokens, err := ah.GenerateAccessTokens(token.Username, token.Family)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ah.TokenStore.RotateRefreshToken(refreshToken, tokens.RefreshToken, device)
+
+	if err != nil {
+		// Lost a race with another refresh of the same token
+		if err == database.ErrTokenAlreadyRotated {
+			return nil, ah.revokeReusedFamily(token, device)
+		}
+		return nil, err
+	}
+
+	ah.Audit.Record(database.AuditEvent{Action: AuditTokenRefresh, Actor: token.Username, Target: token.Username, IPAddress: device.IPAddress})
+	return tokens, nil
+}
+
+func (ah *AuthService) revokeReusedFamily(token *database.RefreshToken, device database.Device) error {
+	ah.Authentication.Logger.Warn("SECURITY: rotated refresh token reused, revoking token family",
+		"username", token.Username, "family", token.Family)
+	ah.Audit.Record(database.AuditEvent{Action: AuditRefreshTokenReused, Actor: token.Username, Target: token.Username, IPAddress: device.IPAddress})
+
+	if err := ah.Authentication.DenySession(token.Family); err != nil {
+		return err
+	}
+
+	if err := ah.TokenStore.RevokeTokenFamily(token.Family); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// Sessions lists the signed in devices of username, current is the session of the request
+func (ah *AuthService) Sessions(username, current string) ([]database.Session, error) {
+	sessions, err := ah.TokenStore.GetSessions(username, time.Now().Add(-ah.Authentication.MaxRefresh))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Sessions past MaxSession cannot be refreshed anymore, they end with their last access token
+	live := sessions[:0]
+	for _, session := range sessions {
+		if time.Now().After(session.CreatedAt.Add(ah.Authentication.MaxSession)) {
+			continue
+		}
+		session.Current = session.ID == current
+		live = append(live, session)
+	}
+	return live, nil
+}
+
+// Logout ends the session the request was made with, tokenID and expires name its access token
+func (ah *AuthService) Logout(username, session, tokenID string, expires time.Time, ip string) error {
+	// Denied first so the access token stops working even when the session was already revoked
+	if err := ah.Authentication.DenyAccessToken(tokenID, expires); err != nil {
+		return err
+	}
+
+	if err := ah.Authentication.DenySession(session); err != nil {
+		return err
+	}
+
+	if err := ah.TokenStore.RevokeSession(username, session); err != nil {
+		return err
+	}
+
+	ah.Audit.Record(database.AuditEvent{Action: AuditLogout, Actor: username, Target: username, IPAddress: ip})
+	return nil
+}
+
+func (ah *AuthService) RevokeSession(username, session, ip string) error {
+	sessions, err := ah.TokenStore.GetSessions(username, time.Now().Add(-ah.Authentication.MaxRefresh))
+
+	if err != nil {
+		return err
+	}
+
+	// Only the user's own sessions may be denied, an unknown id falls through to sql.ErrNoRows below
+	for _, s := range sessions {
+		if s.ID == session {
+			if err = ah.Authentication.DenySession(session); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	if err := ah.TokenStore.RevokeSession(username, session); err != nil {
+		return err
+	}
+
+	ah.Audit.Record(database.AuditEvent{Action: AuditSessionRevoke, Actor: username, Target: username, IPAddress: ip})
+	return nil
+}
+
+// RevokeOtherSessions signs out every device but the current one, all of them when current is empty
+func (ah *AuthService) RevokeOtherSessions(username, current, ip string) error {
+	if err := denySessions(ah.Authentication, ah.TokenStore, username, current); err != nil {
+		return err
+	}
+
+	if err := ah.TokenStore.RevokeOtherSessions(username, current); err != nil {
+		return err
+	}
+
+	ah.Audit.Record(database.AuditEvent{
+		Action:    AuditSessionRevoke,
+		Actor:     username,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"scope": "others"},
+	})
+	return nil
+}
+
+// denySessions stops the access tokens of every session of username but except at once, before the sessions are revoked
+func denySessions(authentication *middleware.AuthenticationMiddleware, tokens database.TokenStore, username, except string) error {
+	sessions, err := tokens.GetSessions(username, time.Now().Add(-authentication.MaxRefresh))
+
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == except {
+			continue
+		}
+		if err = authentication.DenySession(session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
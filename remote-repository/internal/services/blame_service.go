@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/diff"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+)
+
+var (
+	ErrFileNotFound     = errors.New("File not found")
+	ErrInvalidLineRange = errors.New("Invalid Line Range")
+)
+
+type BlameService struct {
+	RepoStore database.RepoStore
+	Objects   objects.Store
+}
+
+type BlameRange struct {
+	StartLine  int       `json:"start_line"`
+	EndLine    int       `json:"end_line"`
+	CommitHash string    `json:"commit_hash"`
+	Author     string    `json:"author"`
+	Timestamp  time.Time `json:"timestamp"`
+	Message    string    `json:"message"`
+	Lines      []string  `json:"lines"`
+}
+
+// Lines of one version of the file that are still looking for the commit that introduced them,
+// keyed by their index in that version and mapped to their index in the blamed version
+type blameCandidate struct {
+	commit  *objects.Commit
+	lines   []string
+	pending map[int]int
+}
+
+// Blame attributes every line of filePath at rev, or only lines start to end (1-based, inclusive) when given,
+// to the commit that last changed it by walking history backwards and diffing each version against its parents
+func (bs *BlameService) Blame(username, reponame, rev, filePath string, start, end int) ([]BlameRange, error) {
+	repo := &objects.Repo{Store: bs.Objects, Owner: username, Name: reponame}
+
+	head, err := ResolveRef(bs.RepoStore, repo, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := readLines(repo, head, filePath)
+	if err != nil {
+		if errors.Is(err, objects.ErrPathNotFound) {
+			return nil, ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	if len(lines) == 0 {
+		return []BlameRange{}, nil
+	}
+
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start < 1 || start > end {
+		return nil, ErrInvalidLineRange
+	}
+
+	pending := make(map[int]int, end-start+1)
+	for i := start - 1; i < end; i++ {
+		pending[i] = i
+	}
+
+	origins := make([]*objects.Commit, len(lines))
+	queue := map[string]*blameCandidate{
+		head.Hash: {commit: head, lines: lines, pending: pending},
+	}
+
+	for len(queue) > 0 {
+		current := newestCandidate(queue)
+		delete(queue, current.commit.Hash)
+
+		for _, parentHash := range current.commit.Parents {
+			if len(current.pending) == 0 {
+				break
+			}
+
+			parent, err := repo.ReadCommit(parentHash)
+			if err != nil {
+				return nil, err
+			}
+
+			parentLines, err := readLines(repo, parent, filePath)
+			if err != nil {
+				if errors.Is(err, objects.ErrPathNotFound) {
+					continue
+				}
+				return nil, err
+			}
+
+			candidate, ok := queue[parent.Hash]
+			if !ok {
+				candidate = &blameCandidate{commit: parent, lines: parentLines, pending: map[int]int{}}
+			}
+
+			// Lines kept unchanged from the parent are passed on to it
+			parentIdx, currentIdx := 0, 0
+			for _, line := range diff.Diff(parentLines, current.lines) {
+				switch line[0] {
+				case ' ':
+					if blamed, ok := current.pending[currentIdx]; ok {
+						candidate.pending[parentIdx] = blamed
+						delete(current.pending, currentIdx)
+					}
+					parentIdx++
+					currentIdx++
+				case '-':
+					parentIdx++
+				case '+':
+					currentIdx++
+				}
+			}
+
+			if len(candidate.pending) > 0 {
+				queue[parent.Hash] = candidate
+			}
+		}
+
+		for _, blamed := range current.pending {
+			origins[blamed] = current.commit
+		}
+	}
+
+	var ranges []BlameRange
+	for i := start - 1; i < end; i++ {
+		commit := origins[i]
+		if n := len(ranges); n > 0 && ranges[n-1].CommitHash == commit.Hash && ranges[n-1].EndLine == i {
+			ranges[n-1].EndLine = i + 1
+			ranges[n-1].Lines = append(ranges[n-1].Lines, lines[i])
+			continue
+		}
+
+		ranges = append(ranges, BlameRange{
+			StartLine:  i + 1,
+			EndLine:    i + 1,
+			CommitHash: commit.Hash,
+			Author:     commit.Author,
+			Timestamp:  commit.Time(),
+			Message:    commit.Message,
+			Lines:      []string{lines[i]},
+		})
+	}
+
+	return ranges, nil
+}
+
+func newestCandidate(queue map[string]*blameCandidate) *blameCandidate {
+	var newest *blameCandidate
+	for _, candidate := range queue {
+		if newest == nil || candidate.commit.Time().After(newest.commit.Time()) ||
+			(candidate.commit.Time().Equal(newest.commit.Time()) && candidate.commit.Hash < newest.commit.Hash) {
+			newest = candidate
+		}
+	}
+	return newest
+}
+
+func readLines(repo *objects.Repo, commit *objects.Commit, filePath string) ([]string, error) {
+	blob, err := repo.FindBlob(commit.TreeHash, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	content := strings.TrimSuffix(string(blob.Content), "\n")
+	if content == "" {
+		return []string{}, nil
+	}
+	return strings.Split(content, "\n"), nil
+}
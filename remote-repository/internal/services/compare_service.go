@@ -0,0 +1,232 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+)
+
+// Upper bounds on cached commit pairs and branch listings before either cache is dropped
+const (
+	maxCachedComparisons = 4096
+	maxCachedBranches    = 256
+)
+
+type CompareService struct {
+	RepoStore database.RepoStore
+	Objects   objects.Store
+
+	mu          sync.Mutex
+	comparisons map[string]*Comparison
+	branches    map[string]*BranchComparison
+}
+
+type Comparison struct {
+	Base       string `json:"base"`
+	Head       string `json:"head"`
+	BaseCommit string `json:"base_commit"`
+	HeadCommit string `json:"head_commit"`
+	MergeBase  string `json:"merge_base,omitempty"`
+	Ahead      int    `json:"ahead"`
+	Behind     int    `json:"behind"`
+}
+
+type BranchComparison struct {
+	DefaultBranch string       `json:"default_branch"`
+	Branches      []Comparison `json:"branches"`
+}
+
+// Compare counts the commits reachable from head but not base (ahead) and the other way around (behind)
+func (cs *CompareService) Compare(username, reponame, base, head string) (*Comparison, error) {
+	repo := &objects.Repo{Store: cs.Objects, Owner: username, Name: reponame}
+
+	baseCommit, err := ResolveRef(cs.RepoStore, repo, base)
+	if err != nil {
+		return nil, err
+	}
+
+	headCommit, err := ResolveRef(cs.RepoStore, repo, head)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison, err := cs.compareCommits(repo, baseCommit.Hash, headCommit.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	result := *comparison
+	result.Base = base
+	result.Head = head
+	return &result, nil
+}
+
+// CompareBranches compares every branch of the repository with its default branch.
+// Results are cached by the branch heads they were computed from, a push on any instance moves a head and misses the cache.
+func (cs *CompareService) CompareBranches(username, reponame string) (*BranchComparison, error) {
+	defaultBranch, err := cs.RepoStore.GetDefaultBranch(username, reponame)
+	if err != nil {
+		return nil, err
+	}
+
+	heads, err := cs.RepoStore.GetBranchHeads(username, reponame)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(heads))
+	for name := range heads {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	key := branchesKey(username, reponame, defaultBranch, names, heads)
+
+	cs.mu.Lock()
+	cached, ok := cs.branches[key]
+	cs.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	result := &BranchComparison{DefaultBranch: defaultBranch, Branches: []Comparison{}}
+	repo := &objects.Repo{Store: cs.Objects, Owner: username, Name: reponame}
+
+	base, ok := heads[defaultBranch]
+	for _, name := range names {
+		if !ok {
+			break
+		}
+
+		comparison, err := cs.compareCommits(repo, base, heads[name])
+		if err != nil {
+			if errors.Is(err, objects.ErrObjectNotFound) || errors.Is(err, objects.ErrNotACommit) {
+				continue
+			}
+			return nil, err
+		}
+
+		branch := *comparison
+		branch.Base = defaultBranch
+		branch.Head = name
+		result.Branches = append(result.Branches, branch)
+	}
+
+	cs.mu.Lock()
+	if cs.branches == nil || len(cs.branches) >= maxCachedBranches {
+		cs.branches = map[string]*BranchComparison{}
+	}
+	cs.branches[key] = result
+	cs.mu.Unlock()
+
+	return result, nil
+}
+
+// branchesKey names a branch listing by everything it was computed from
+func branchesKey(username, reponame, defaultBranch string, names []string, heads map[string]string) string {
+	hash := sha256.New()
+	hash.Write([]byte(username + "/" + reponame + "\x00" + defaultBranch))
+	for _, name := range names {
+		hash.Write([]byte("\x00" + name + "=" + heads[name]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Commits are immutable, so comparisons are cached by commit hashes
+func (cs *CompareService) compareCommits(repo *objects.Repo, base, head string) (*Comparison, error) {
+	key := repo.Owner + "/" + repo.Name + "/" + base + "..." + head
+
+	cs.mu.Lock()
+	cached, ok := cs.comparisons[key]
+	cs.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	commits := map[string]*objects.Commit{}
+
+	baseAncestors, err := ancestors(repo, base, commits)
+	if err != nil {
+		return nil, err
+	}
+
+	headAncestors, err := ancestors(repo, head, commits)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &Comparison{BaseCommit: base, HeadCommit: head}
+	common := map[string]bool{}
+	for hash := range headAncestors {
+		if baseAncestors[hash] {
+			common[hash] = true
+		} else {
+			comparison.Ahead++
+		}
+	}
+	comparison.Behind = len(baseAncestors) - len(common)
+
+	// Merge bases are the common ancestors that are not a parent of another common ancestor
+	redundant := map[string]bool{}
+	for hash := range common {
+		for _, parent := range commits[hash].Parents {
+			redundant[parent] = true
+		}
+	}
+
+	var mergeBase *objects.Commit
+	for hash := range common {
+		if redundant[hash] {
+			continue
+		}
+		candidate := commits[hash]
+		if mergeBase == nil || candidate.Time().After(mergeBase.Time()) ||
+			(candidate.Time().Equal(mergeBase.Time()) && candidate.Hash < mergeBase.Hash) {
+			mergeBase = candidate
+		}
+	}
+	if mergeBase != nil {
+		comparison.MergeBase = mergeBase.Hash
+	}
+
+	cs.mu.Lock()
+	if cs.comparisons == nil || len(cs.comparisons) >= maxCachedComparisons {
+		cs.comparisons = map[string]*Comparison{}
+	}
+	cs.comparisons[key] = comparison
+	cs.mu.Unlock()
+
+	return comparison, nil
+}
+
+func ancestors(repo *objects.Repo, hash string, commits map[string]*objects.Commit) (map[string]bool, error) {
+	visited := map[string]bool{}
+	stack := []string{hash}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+
+		commit, ok := commits[current]
+		if !ok {
+			var err error
+			commit, err = repo.ReadCommit(current)
+			if err != nil {
+				return nil, err
+			}
+			commits[current] = commit
+		}
+
+		stack = append(stack, commit.Parents...)
+	}
+	return visited, nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/mailer"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/utils"
+)
+
+var (
+	ErrInvalidEmailToken    = errors.New("Invalid or Expired Token")
+	ErrEmailAlreadyVerified = errors.New("Email Already Verified")
+)
+
+const (
+	verifyEmailTimeout   = 24 * time.Hour
+	resetPasswordTimeout = time.Hour
+)
+
+type EmailService struct {
+	UserStore       database.UserStore
+	EmailTokenStore database.EmailTokenStore
+	TokenStore      database.TokenStore
+	Mailer          mailer.Mailer
+	Audit           *AuditService
+	// Denies the access tokens of sessions signed out by a password reset
+	Authentication *middleware.AuthenticationMiddleware
+	// Links in emails point there
+	BaseURL string
+}
+
+// SendVerification mails a new verification link, earlier links stop working
+func (es *EmailService) SendVerification(username string) error {
+	user, err := es.UserStore.GetUserbyUsername(username)
+	if err != nil {
+		return err
+	}
+
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := es.createToken(user, database.VerifyEmail, verifyEmailTimeout)
+	if err != nil {
+		return err
+	}
+
+	link := es.BaseURL + "/auth/verify-email?token=" + url.QueryEscape(token)
+
+	return es.Mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm this address for your account by opening:\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not sign up, ignore this email.\n",
+			user.Username, link, int(verifyEmailTimeout.Hours())),
+	})
+}
+
+func (es *EmailService) VerifyEmail(token string) error {
+	emailToken, err := es.EmailTokenStore.ConsumeEmailToken(database.VerifyEmail, token)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidEmailToken
+		}
+		return err
+	}
+
+	err = es.UserStore.SetEmailVerified(emailToken.Username, emailToken.EmailAddress)
+
+	// The address changed after the link was sent
+	if err == sql.ErrNoRows {
+		return ErrInvalidEmailToken
+	}
+	return err
+}
+
+// ForgotPassword mails a reset link when email belongs to an account,
+// it reports success either way so it cannot be used to find out which addresses are registered
+func (es *EmailService) ForgotPassword(email string) error {
+	user, err := es.UserStore.GetUserbyEmailAddress(email)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	token, err := es.createToken(user, database.ResetPassword, resetPasswordTimeout)
+	if err != nil {
+		return err
+	}
+
+	return es.Mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+			"Send this token with your new password to %s/auth/reset-password:\n\n%s\n\n"+
+			"The token expires in %d minutes. If it was not you, ignore this email, your password is unchanged.\n",
+			user.Username, es.BaseURL, token, int(resetPasswordTimeout.Minutes())),
+	})
+}
+
+// SendForcedPasswordReset mails a reset link to an account whose password an administrator cleared
+func (es *EmailService) SendForcedPasswordReset(user *database.User) error {
+	token, err := es.createToken(user, database.ResetPassword, resetPasswordTimeout)
+	if err != nil {
+		return err
+	}
+
+	return es.Mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: "Your password has to be reset",
+		Body: fmt.Sprintf("Hi %s,\n\nAn administrator reset the password of your account and signed every session out. "+
+			"Choose a new password by sending this token with it to %s/auth/reset-password:\n\n%s\n\n"+
+			"The token expires in %d minutes, a new one can be requested from /auth/forgot-password.\n",
+			user.Username, es.BaseURL, token, int(resetPasswordTimeout.Minutes())),
+	})
+}
+
+// ResetPassword sets a new password, signs every session out and revokes every personal access token.
+// Receiving the token proves control of the address, so the email becomes verified too.
+func (es *EmailService) ResetPassword(token, password string) error {
+	if !utils.IsValidPassword(password) {
+		return ErrInvalidPassword
+	}
+
+	var pass hashing.Password
+	if err := pass.Set(password); err != nil {
+		return ErrInvalidPassword
+	}
+
+	emailToken, err := es.EmailTokenStore.ConsumeEmailToken(database.ResetPassword, token)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidEmailToken
+		}
+		return err
+	}
+
+	if err = es.UserStore.UpdatePassword(emailToken.Username, string(pass.Hash)); err != nil {
+		return err
+	}
+
+	if err = denySessions(es.Authentication, es.TokenStore, emailToken.Username, ""); err != nil {
+		return err
+	}
+
+	if err = es.TokenStore.RevokeAllTokens(emailToken.Username); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// Like ChangePassword, tokens made while the old password was known stop working
+	if err = es.Authentication.AccessTokenStore.RevokeAllAccessTokens(emailToken.Username); err != nil {
+		return err
+	}
+
+	if err = es.UserStore.SetEmailVerified(emailToken.Username, emailToken.EmailAddress); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	es.Audit.Record(database.AuditEvent{Action: AuditPasswordReset, Actor: emailToken.Username, Target: emailToken.Username})
+	return nil
+}
+
+func (es *EmailService) createToken(user *database.User, purpose database.EmailTokenPurpose, timeout time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = es.EmailTokenStore.CreateEmailToken(&database.EmailToken{
+		Username:     user.Username,
+		Purpose:      purpose,
+		EmailAddress: user.EmailAddress,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(timeout),
+	}, token)
+
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// SendNotification mails a notification to username, accounts without an email address are skipped
+func (es *EmailService) SendNotification(username, subject, message string) error {
+	user, err := es.UserStore.GetUserbyUsername(username)
+	if err != nil {
+		return err
+	}
+
+	if user.EmailAddress == "" {
+		return nil
+	}
+
+	return es.Mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: subject,
+		Body:    fmt.Sprintf("Hi %s,\n\n%s\n\nYour notifications are listed under %s/settings/notifications.\n", user.Username, message, es.BaseURL),
+	})
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+)
+
+var ErrInviteeNotUser = errors.New("Organizations cannot be invited, grant a team of the organization instead")
+
+// How long an invitation can be accepted, granting again sends a fresh one
+const invitationTimeout = 7 * 24 * time.Hour
+
+// InvitationService adds collaborators only with their consent, a grant to anyone else is a pending invitation
+type InvitationService struct {
+	InvitationStore database.InvitationStore
+	RepoStore       database.RepoStore
+	UserStore       database.UserStore
+	TwoFactor       *TwoFactorService
+	Notifications   *NotificationService
+	Audit           *AuditService
+}
+
+// Grant changes the role of an existing collaborator at once and returns no invitation.
+// Anyone else is invited and notified, the role applies once they accept.
+func (is *InvitationService) Grant(actor, username, reponame, target string, role database.Role, ip string) (*database.Invitation, error) {
+	user, err := is.UserStore.GetUserbyUsername(target)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if user.AccountType != database.AccountUser {
+		return nil, ErrInviteeNotUser
+	}
+
+	if err = is.TwoFactor.CheckContributor(username, reponame, target); err != nil {
+		return nil, err
+	}
+
+	err = is.RepoStore.GrantAccessOnRepo(username, reponame, target, role)
+
+	if err == nil {
+		is.Audit.Record(database.AuditEvent{
+			Action:    AuditRepoAccessGrant,
+			Actor:     actor,
+			Target:    target,
+			RepoOwner: username,
+			RepoName:  reponame,
+			IPAddress: ip,
+			Details:   map[string]string{"role": string(role)},
+		})
+		return nil, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	now := time.Now()
+	invitation, err := is.InvitationStore.CreateInvitation(&database.Invitation{
+		RepoOwner: username,
+		RepoName:  reponame,
+		Invitee:   target,
+		Inviter:   actor,
+		Role:      role,
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationTimeout),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	is.Audit.Record(database.AuditEvent{
+		Action:    AuditRepoInvite,
+		Actor:     actor,
+		Target:    target,
+		RepoOwner: username,
+		RepoName:  reponame,
+		IPAddress: ip,
+		Details:   map[string]string{"role": string(role)},
+	})
+
+	is.Notifications.Notify(database.Notification{
+		Username:  target,
+		Kind:      NotifyRepoInvitation,
+		RepoOwner: username,
+		RepoName:  reponame,
+		Message: fmt.Sprintf("%s invited you to %s/%s with the %s role. Accept or decline it under /settings/invitations before %s.",
+			actor, username, reponame, role, invitation.ExpiresAt.UTC().Format(time.RFC1123)),
+	}, fmt.Sprintf("Invitation to %s/%s", username, reponame))
+
+	return invitation, nil
+}
+
+// RepoInvitations lists the invitations to a repository that can still be accepted
+func (is *InvitationService) RepoInvitations(username, reponame string) ([]database.Invitation, error) {
+	return is.InvitationStore.GetRepoInvitations(username, reponame)
+}
+
+func (is *InvitationService) Cancel(actor, username, reponame string, id int, ip string) error {
+	invitation, err := is.InvitationStore.CancelInvitation(username, reponame, id)
+
+	if err != nil {
+		return err
+	}
+
+	is.Audit.Record(database.AuditEvent{Action: AuditRepoInviteCancel, Actor: actor, Target: invitation.Invitee, RepoOwner: username, RepoName: reponame, IPAddress: ip})
+	return nil
+}
+
+// Invitations lists what username was invited to and can still accept
+func (is *InvitationService) Invitations(username string) ([]database.Invitation, error) {
+	return is.InvitationStore.GetUserInvitations(username)
+}
+
+// Accept makes username a collaborator with the invited role, sql.ErrNoRows once the invitation expired
+func (is *InvitationService) Accept(username string, id int, ip string) (*database.Invitation, error) {
+	invitation, err := is.InvitationStore.GetInvitation(username, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The repository may have started requiring two factor since the invitation was sent
+	if err = is.TwoFactor.CheckContributor(invitation.RepoOwner, invitation.RepoName, username); err != nil {
+		return nil, err
+	}
+
+	if invitation, err = is.InvitationStore.AcceptInvitation(username, id); err != nil {
+		return nil, err
+	}
+
+	is.Audit.Record(database.AuditEvent{
+		Action:    AuditRepoInviteAccept,
+		Actor:     username,
+		Target:    username,
+		RepoOwner: invitation.RepoOwner,
+		RepoName:  invitation.RepoName,
+		IPAddress: ip,
+		Details:   map[string]string{"role": string(invitation.Role), "inviter": invitation.Inviter},
+	})
+	return invitation, nil
+}
+
+func (is *InvitationService) Decline(username string, id int, ip string) error {
+	invitation, err := is.InvitationStore.DeclineInvitation(username, id)
+
+	if err != nil {
+		return err
+	}
+
+	is.Audit.Record(database.AuditEvent{Action: AuditRepoInviteDecline, Actor: username, Target: username, RepoOwner: invitation.RepoOwner, RepoName: invitation.RepoName, IPAddress: ip})
+	return nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/cache"
+)
+
+var ErrTooManyAttempts = errors.New("Too Many Login Attempts")
+
+const (
+	loginBackoffBase = time.Second
+	loginLockout     = 15 * time.Minute
+	// Failures are forgotten once there was none for this long
+	loginAttemptWindow = time.Hour
+)
+
+// Failures allowed before backoff starts and before the key is locked out.
+// Addresses get more room since several people may share one.
+type loginPolicy struct {
+	kind    string
+	free    int
+	lockout int
+}
+
+var (
+	accountLoginPolicy = loginPolicy{kind: "account", free: 3, lockout: 10}
+	ipLoginPolicy      = loginPolicy{kind: "ip", free: 10, lockout: 50}
+)
+
+// LoginLockedError carries how long the client has to wait before trying again
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string { return ErrTooManyAttempts.Error() }
+
+func (e *LoginLockedError) Unwrap() error { return ErrTooManyAttempts }
+
+// LoginLimiter tracks failed logins per account and per client address in the shared cache,
+// so attempts spread over several instances still add up. Every failure past the free ones
+// doubles the wait until the key is locked out.
+type LoginLimiter struct {
+	Logger *slog.Logger
+	Audit  *AuditService
+	Cache  cache.Cache
+}
+
+// Cache keys, failures live for loginAttemptWindow after the last one and blocks until they end
+const (
+	loginFailuresPrefix = "login:failures:"
+	loginBlockedPrefix  = "login:blocked:"
+)
+
+func NewLoginLimiter(logger *slog.Logger, audit *AuditService, shared cache.Cache) *LoginLimiter {
+	return &LoginLimiter{Logger: logger, Audit: audit, Cache: shared}
+}
+
+// Check refuses the attempt while the account or the address has to wait
+func (ll *LoginLimiter) Check(username, ip string) error {
+	var wait time.Duration
+
+	for _, key := range []string{accountLoginPolicy.key(username), ipLoginPolicy.key(ip)} {
+		value, err := ll.Cache.Get(loginBlockedPrefix + key)
+
+		if errors.Is(err, cache.ErrMiss) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if until, err := strconv.ParseInt(value, 10, 64); err == nil {
+			wait = max(wait, time.Until(time.Unix(0, until)))
+		}
+	}
+
+	if wait > 0 {
+		return &LoginLockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Failure counts a failed attempt against the account and the address, lockouts it causes are audited
+func (ll *LoginLimiter) Failure(username, ip string) {
+	now := time.Now()
+
+	var locked []string
+	for _, attempt := range []struct {
+		policy loginPolicy
+		key    string
+	}{{accountLoginPolicy, username}, {ipLoginPolicy, ip}} {
+		lockedOut, err := ll.record(attempt.policy, attempt.key, now)
+
+		if err != nil {
+			ll.Logger.Error("Error recording failed login", attempt.policy.kind, attempt.key, "error", err)
+			continue
+		}
+		if lockedOut {
+			locked = append(locked, attempt.policy.kind)
+		}
+	}
+
+	for _, kind := range locked {
+		ll.Audit.Record(database.AuditEvent{
+			Action:    AuditLoginLocked,
+			Actor:     username,
+			Target:    username,
+			IPAddress: ip,
+			Details:   map[string]string{"scope": kind, "until": now.Add(loginLockout).UTC().Format(time.RFC3339)},
+		})
+	}
+}
+
+// Success clears the account, the address keeps its failures so one valid login cannot reset it
+func (ll *LoginLimiter) Success(username string) {
+	key := accountLoginPolicy.key(username)
+
+	for _, prefix := range []string{loginFailuresPrefix, loginBlockedPrefix} {
+		if err := ll.Cache.Delete(prefix + key); err != nil {
+			ll.Logger.Error("Error clearing failed logins", "username", username, "error", err)
+		}
+	}
+}
+
+// record counts a failure for key and reports whether it locked key out
+func (ll *LoginLimiter) record(policy loginPolicy, key string, now time.Time) (bool, error) {
+	key = policy.key(key)
+	failures, err := ll.Cache.Increment(loginFailuresPrefix+key, loginAttemptWindow)
+
+	if err != nil {
+		return false, err
+	}
+
+	if failures < int64(policy.free) {
+		return false, nil
+	}
+
+	delay := loginLockout
+	if shift := failures - int64(policy.free); failures < int64(policy.lockout) && shift < 30 {
+		delay = min(loginBackoffBase<<shift, loginLockout)
+	}
+	until := now.Add(delay)
+
+	if err = ll.Cache.Set(loginBlockedPrefix+key, strconv.FormatInt(until.UnixNano(), 10), delay); err != nil {
+		return false, err
+	}
+
+	if failures >= int64(policy.lockout) {
+		ll.Logger.Warn("SECURITY: locking out login after repeated failures", "key", key, "failures", failures, "until", until)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p loginPolicy) key(value string) string {
+	return p.kind + ":" + value
+}
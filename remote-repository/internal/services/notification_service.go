@@ -0,0 +1,59 @@
+package services
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+)
+
+// Kinds of notifications
+const (
+	NotifyRepoInvitation = "repo.invitation"
+	NotifyRepoTransfer   = "repo.transfer"
+)
+
+const (
+	defaultNotificationPage = 50
+	maxNotificationPage     = 200
+)
+
+type NotificationService struct {
+	NotificationStore database.NotificationStore
+	Email             *EmailService
+	Logger            *slog.Logger
+}
+
+// Notify records a notification and mails it with subject.
+// Failures are only logged, they never undo what the notification is about.
+func (ns *NotificationService) Notify(notification database.Notification, subject string) {
+	notification.CreatedAt = time.Now()
+
+	if err := ns.NotificationStore.CreateNotification(&notification); err != nil {
+		ns.Logger.Error("Error recording notification", "username", notification.Username, "kind", notification.Kind, "error", err)
+	}
+
+	if err := ns.Email.SendNotification(notification.Username, subject, notification.Message); err != nil {
+		ns.Logger.Error("Error mailing notification", "username", notification.Username, "kind", notification.Kind, "error", err)
+	}
+}
+
+// Notifications lists the latest notifications of username, limit is clamped to a sane page size
+func (ns *NotificationService) Notifications(username string, unreadOnly bool, limit int) ([]database.Notification, error) {
+	if limit <= 0 {
+		limit = defaultNotificationPage
+	}
+	if limit > maxNotificationPage {
+		limit = maxNotificationPage
+	}
+
+	return ns.NotificationStore.GetNotifications(username, unreadOnly, limit)
+}
+
+func (ns *NotificationService) MarkRead(username string, id int64) error {
+	return ns.NotificationStore.MarkNotificationRead(username, id)
+}
+
+func (ns *NotificationService) MarkAllRead(username string) error {
+	return ns.NotificationStore.MarkAllNotificationsRead(username)
+}
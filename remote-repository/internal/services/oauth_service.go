@@ -0,0 +1,462 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/models"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/cache"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/hashing"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/utils"
+)
+
+var (
+	ErrUnknownProvider    = errors.New("Unknown OAuth Provider")
+	ErrInvalidOAuthState  = errors.New("Invalid OAuth State")
+	ErrOAuthExchange      = errors.New("OAuth Code Exchange Failed")
+	ErrUnverifiedEmail    = errors.New("Provider did not return a verified email address")
+	ErrInvalidSignupToken = errors.New("Invalid Signup Token")
+)
+
+const oauthStateTimeout = 10 * time.Minute
+
+// Cache keys of pending flows, the provider and verifier of a state, whether it was used,
+// and the identity waiting for a username
+const (
+	oauthStatePrefix     = "oauth:state:"
+	oauthStateUsedPrefix = "oauth:state_used:"
+	oauthSignupPrefix    = "oauth:signup:"
+)
+
+// OAuthProvider endpoints default to the real providers and can be pointed at a stand-in through the environment
+type OAuthProvider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	// GitHub only returns the public email on the user endpoint
+	EmailsURL   string
+	RedirectURL string
+	Scopes      []string
+}
+
+type OAuthIdentity struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Login         string `json:"login"`
+}
+
+type oauthState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+}
+
+// OAuthResult holds tokens when the identity belongs to an account,
+// otherwise a signup token the client exchanges together with a chosen username
+type OAuthResult struct {
+	Tokens            *models.TokenResponse      `json:"tokens,omitempty"`
+	Challenge         *models.TwoFactorChallenge `json:"two_factor,omitempty"`
+	SignupToken       string                     `json:"signup_token,omitempty"`
+	Email             string                     `json:"email,omitempty"`
+	SuggestedUsername string                     `json:"suggested_username,omitempty"`
+}
+
+type OAuthService struct {
+	Providers  map[string]*OAuthProvider
+	UserStore  database.UserStore
+	Auth       *AuthService
+	HTTPClient *http.Client
+	// Holds pending flows, shared by every instance with Redis
+	Cache cache.Cache
+	// Signs the cookie tying a state to the browser that started the flow
+	Key []byte
+}
+
+func NewOAuthService(userStore database.UserStore, auth *AuthService, shared cache.Cache, key []byte) *OAuthService {
+	redirectBase := strings.TrimSuffix(utils.GetEnv("OAUTH_REDIRECT_BASE_URL", "http://localhost:8080"), "/")
+	providers := map[string]*OAuthProvider{}
+
+	if clientID := utils.GetEnv("GOOGLE_KEY", ""); clientID != "" {
+		providers["google"] = &OAuthProvider{
+			Name:         "google",
+			ClientID:     clientID,
+			ClientSecret: utils.GetEnv("GOOGLE_SECRET", ""),
+			AuthURL:      utils.GetEnv("GOOGLE_AUTH_URL", "https://accounts.google.com/o/oauth2/v2/auth"),
+			TokenURL:     utils.GetEnv("GOOGLE_TOKEN_URL", "https://oauth2.googleapis.com/token"),
+			UserInfoURL:  utils.GetEnv("GOOGLE_USERINFO_URL", "https://openidconnect.googleapis.com/v1/userinfo"),
+			RedirectURL:  redirectBase + "/auth/oauth/google/callback",
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+	}
+
+	if clientID := utils.GetEnv("GITHUB_KEY", ""); clientID != "" {
+		providers["github"] = &OAuthProvider{
+			Name:         "github",
+			ClientID:     clientID,
+			ClientSecret: utils.GetEnv("GITHUB_SECRET", ""),
+			AuthURL:      utils.GetEnv("GITHUB_AUTH_URL", "https://github.com/login/oauth/authorize"),
+			TokenURL:     utils.GetEnv("GITHUB_TOKEN_URL", "https://github.com/login/oauth/access_token"),
+			UserInfoURL:  utils.GetEnv("GITHUB_USERINFO_URL", "https://api.github.com/user"),
+			EmailsURL:    utils.GetEnv("GITHUB_EMAILS_URL", "https://api.github.com/user/emails"),
+			RedirectURL:  redirectBase + "/auth/oauth/github/callback",
+			Scopes:       []string{"read:user", "user:email"},
+		}
+	}
+
+	return &OAuthService{
+		Providers:  providers,
+		UserStore:  userStore,
+		Auth:       auth,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Cache:      shared,
+		Key:        key,
+	}
+}
+
+// Begin starts the authorization code flow and returns the provider URL to send the user to,
+// with the binding the browser has to present on the callback
+func (oas *OAuthService) Begin(providerName string) (string, string, error) {
+	provider, ok := oas.Providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	pending, err := json.Marshal(oauthState{Provider: providerName, Verifier: verifier})
+	if err != nil {
+		return "", "", err
+	}
+	if err = oas.Cache.Set(oauthStatePrefix+state, string(pending), oauthStateTimeout); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.ClientID)
+	params.Set("redirect_uri", provider.RedirectURL)
+	params.Set("scope", strings.Join(provider.Scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	return provider.AuthURL + "?" + params.Encode(), oas.binding(state), nil
+}
+
+// Complete validates the state and the browser binding, exchanges the code and signs the matching account in.
+// Identities are linked to an existing account when both sides verified the email address.
+func (oas *OAuthService) Complete(providerName, state, binding, code string, device database.Device) (*OAuthResult, error) {
+	provider, ok := oas.Providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	// A callback opened in a browser that did not start the flow would sign it into someone else's account
+	if state == "" || code == "" || !hmac.Equal([]byte(binding), []byte(oas.binding(state))) {
+		return nil, ErrInvalidOAuthState
+	}
+
+	pending, err := oas.takeState(state)
+	if err != nil {
+		return nil, err
+	}
+	if pending.Provider != providerName {
+		return nil, ErrInvalidOAuthState
+	}
+
+	accessToken, err := oas.exchange(provider, code, pending.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := oas.fetchIdentity(provider, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := oas.UserStore.GetUsernameByIdentity(identity.Provider, identity.Subject)
+
+	if err == nil {
+		return oas.signIn(username, device)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, ErrUnverifiedEmail
+	}
+
+	user, err := oas.UserStore.GetUserbyEmailAddress(identity.Email)
+
+	if err == nil {
+		// Someone may have registered the address without owning it
+		if !user.EmailVerified {
+			return nil, ErrEmailAlreadyExists
+		}
+		if err = oas.UserStore.LinkIdentity(identity.Provider, identity.Subject, user.Username, identity.Email); err != nil {
+			return nil, err
+		}
+		return oas.signIn(user.Username, device)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	signupToken, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	signup, err := json.Marshal(identity)
+	if err != nil {
+		return nil, err
+	}
+	if err = oas.Cache.Set(oauthSignupPrefix+signupToken, string(signup), oauthStateTimeout); err != nil {
+		return nil, err
+	}
+
+	return &OAuthResult{
+		SignupToken:       signupToken,
+		Email:             identity.Email,
+		SuggestedUsername: strings.ToLower(identity.Login),
+	}, nil
+}
+
+// Signup creates an account without a password for an identity that matched no existing account
+func (oas *OAuthService) Signup(signupToken, username string, device database.Device) (*models.TokenResponse, error) {
+	signup, err := oas.Cache.Get(oauthSignupPrefix + signupToken)
+
+	if err != nil {
+		if errors.Is(err, cache.ErrMiss) {
+			return nil, ErrInvalidSignupToken
+		}
+		return nil, err
+	}
+
+	var identity OAuthIdentity
+	if err = json.Unmarshal([]byte(signup), &identity); err != nil {
+		return nil, ErrInvalidSignupToken
+	}
+
+	if err = oas.Auth.ValidateNewUser(username, identity.Email); err != nil {
+		return nil, err
+	}
+
+	fullname := identity.Name
+	if fullname == "" {
+		fullname = username
+	}
+
+	_, err = oas.UserStore.CreateUser(&database.User{
+		Username:     username,
+		FullName:     fullname,
+		EmailAddress: identity.Email,
+		// The provider verified it
+		EmailVerified: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = oas.Cache.Delete(oauthSignupPrefix + signupToken); err != nil {
+		return nil, err
+	}
+
+	if err = oas.UserStore.LinkIdentity(identity.Provider, identity.Subject, username, identity.Email); err != nil {
+		return nil, err
+	}
+
+	result, err := oas.signIn(username, device)
+	if err != nil {
+		return nil, err
+	}
+	result.Tokens.Status = true
+	return result.Tokens, nil
+}
+
+func (oas *OAuthService) signIn(username string, device database.Device) (*OAuthResult, error) {
+	tokens, challenge, err := oas.Auth.SignIn(username, device)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthResult{Tokens: tokens, Challenge: challenge}, nil
+}
+
+func (oas *OAuthService) exchange(provider *OAuthProvider, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("client_id", provider.ClientID)
+	form.Set("client_secret", provider.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err = oas.doJSON(req, &token); err != nil {
+		return "", err
+	}
+
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("%w: %s", ErrOAuthExchange, token.Error)
+	}
+	return token.AccessToken, nil
+}
+
+func (oas *OAuthService) fetchIdentity(provider *OAuthProvider, accessToken string) (*OAuthIdentity, error) {
+	var user struct {
+		Sub           string `json:"sub"`
+		ID            int64  `json:"id"`
+		Login         string `json:"login"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := oas.getJSON(provider.UserInfoURL, accessToken, &user); err != nil {
+		return nil, err
+	}
+
+	identity := &OAuthIdentity{
+		Provider:      provider.Name,
+		Subject:       user.Sub,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Name:          user.Name,
+		Login:         user.Login,
+	}
+
+	if provider.EmailsURL != "" {
+		identity.Subject = strconv.FormatInt(user.ID, 10)
+		identity.Email, identity.EmailVerified = "", false
+
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := oas.getJSON(provider.EmailsURL, accessToken, &emails); err != nil {
+			return nil, err
+		}
+
+		for _, email := range emails {
+			if email.Primary && email.Verified {
+				identity.Email, identity.EmailVerified = email.Email, true
+			}
+		}
+	}
+
+	if identity.Login == "" {
+		identity.Login, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	if identity.Subject == "" || identity.Subject == "0" {
+		return nil, ErrOAuthExchange
+	}
+	return identity, nil
+}
+
+func (oas *OAuthService) getJSON(endpoint, accessToken string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	return oas.doJSON(req, v)
+}
+
+func (oas *OAuthService) doJSON(req *http.Request, v any) error {
+	res, err := oas.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOAuthExchange, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrOAuthExchange, req.URL.Host, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// takeState returns a pending flow once, a replayed or concurrent callback for it fails
+func (oas *OAuthService) takeState(state string) (*oauthState, error) {
+	value, err := oas.Cache.Get(oauthStatePrefix + state)
+
+	if err != nil {
+		if errors.Is(err, cache.ErrMiss) {
+			return nil, ErrInvalidOAuthState
+		}
+		return nil, err
+	}
+
+	first, err := oas.Cache.Add(oauthStateUsedPrefix+state, "1", oauthStateTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, ErrInvalidOAuthState
+	}
+
+	if err = oas.Cache.Delete(oauthStatePrefix + state); err != nil {
+		return nil, err
+	}
+
+	var pending oauthState
+	if err = json.Unmarshal([]byte(value), &pending); err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+	return &pending, nil
+}
+
+// binding is the cookie value proving a callback comes from the browser that began the flow
+func (oas *OAuthService) binding(state string) string {
+	return hashing.HashToken(oas.Key, "oauth_state:"+state)
+}
+
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+)
+
+var (
+	ErrInvalidOrgName      = errors.New("Invalid Organization Name")
+	ErrInvalidTeamName     = errors.New("Team names are lowercase letters, digits, - and _")
+	ErrInvalidOrgRole      = errors.New("Role must be member or owner")
+	ErrLastOrgOwner        = database.ErrLastOrgOwner
+	ErrNotOrgMember        = errors.New("User is not a member of the organization")
+	ErrOrgMemberNotUser    = errors.New("Organizations cannot be members of an organization")
+	ErrTeamAlreadyExists   = errors.New("Team Already Exists")
+	ErrTeamOrRepoNotFound  = errors.New("Team or Repository Not Found")
+	ErrInvalidRepoRole     = errors.New("Role must be one of read, triage, write, maintain or admin")
+	ErrOrgNameAlreadyTaken = errors.New("Name is already taken by a user or organization")
+)
+
+var teamNameRegex = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// OrgService manages organizations and their teams, callers are checked by middleware.RequireOrgRole
+type OrgService struct {
+	OrgStore  database.OrgStore
+	UserStore database.UserStore
+	Audit     *AuditService
+}
+
+// Create makes an organization owned by owner, its name shares the namespace of usernames
+func (orgs *OrgService) Create(owner, name, fullname, bio, ip string) error {
+	if !isValidUsername(name) {
+		return ErrInvalidOrgName
+	}
+
+	_, err := orgs.UserStore.GetUserbyUsername(name)
+
+	if err != sql.ErrNoRows {
+		if err != nil {
+			return err
+		}
+		return ErrOrgNameAlreadyTaken
+	}
+
+	if fullname == "" {
+		fullname = name
+	}
+
+	if err = orgs.OrgStore.CreateOrganization(&database.User{Username: name, FullName: fullname, Bio: bio}, owner); err != nil {
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{Action: AuditOrgCreate, Actor: owner, Target: name, IPAddress: ip})
+	return nil
+}
+
+func (orgs *OrgService) Members(org string) ([]database.OrgMembership, error) {
+	return orgs.OrgStore.GetOrgMembers(org)
+}
+
+// SetMember adds a user to org or changes their role, the last owner cannot step down
+func (orgs *OrgService) SetMember(actor, org, username string, role database.OrgRole, ip string) error {
+	if role != database.OrgMember && role != database.OrgOwner {
+		return ErrInvalidOrgRole
+	}
+
+	user, err := orgs.UserStore.GetUserbyUsername(username)
+
+	if err != nil {
+		return err
+	}
+
+	if user.AccountType != database.AccountUser {
+		return ErrOrgMemberNotUser
+	}
+
+	if err = orgs.OrgStore.SetOrgMember(org, username, role); err != nil {
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{
+		Action:    AuditOrgMemberSet,
+		Actor:     actor,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"org": org, "role": string(role)},
+	})
+	return nil
+}
+
+// RemoveMember takes username out of org and every team in it, the last owner cannot leave
+func (orgs *OrgService) RemoveMember(actor, org, username, ip string) error {
+	if err := orgs.OrgStore.RemoveOrgMember(org, username); err != nil {
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{Action: AuditOrgMemberRemove, Actor: actor, Target: username, IPAddress: ip, Details: map[string]string{"org": org}})
+	return nil
+}
+
+func (orgs *OrgService) Teams(org string) ([]database.Team, error) {
+	return orgs.OrgStore.GetTeams(org)
+}
+
+func (orgs *OrgService) CreateTeam(actor string, team *database.Team, ip string) error {
+	if !teamNameRegex.MatchString(team.Name) {
+		return ErrInvalidTeamName
+	}
+
+	if err := orgs.OrgStore.CreateTeam(team); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrTeamAlreadyExists
+		}
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{Action: AuditOrgTeamCreate, Actor: actor, Target: team.Org, IPAddress: ip, Details: map[string]string{"team": team.Name}})
+	return nil
+}
+
+func (orgs *OrgService) DeleteTeam(actor, org, team, ip string) error {
+	if err := orgs.OrgStore.DeleteTeam(org, team); err != nil {
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{Action: AuditOrgTeamDelete, Actor: actor, Target: org, IPAddress: ip, Details: map[string]string{"team": team}})
+	return nil
+}
+
+func (orgs *OrgService) TeamMembers(org, team string) ([]string, error) {
+	return orgs.OrgStore.GetTeamMembers(org, team)
+}
+
+// AddTeamMember only takes members of the organization, sql.ErrNoRows when the team does not exist
+func (orgs *OrgService) AddTeamMember(actor, org, team, username, ip string) error {
+	role, err := orgs.OrgStore.GetOrgRole(org, username)
+
+	if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return ErrNotOrgMember
+	}
+
+	if err = orgs.OrgStore.AddTeamMember(org, team, username); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return sql.ErrNoRows
+		}
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{
+		Action:    AuditOrgTeamMemberAdd,
+		Actor:     actor,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"org": org, "team": team},
+	})
+	return nil
+}
+
+func (orgs *OrgService) RemoveTeamMember(actor, org, team, username, ip string) error {
+	if err := orgs.OrgStore.RemoveTeamMember(org, team, username); err != nil {
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{
+		Action:    AuditOrgTeamMemberRemove,
+		Actor:     actor,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"org": org, "team": team},
+	})
+	return nil
+}
+
+func (orgs *OrgService) TeamRepos(org, team string) ([]database.TeamRepo, error) {
+	return orgs.OrgStore.GetTeamRepos(org, team)
+}
+
+// SetTeamRepo grants every member of team role on the reponame repository of org
+func (orgs *OrgService) SetTeamRepo(actor, org, team, reponame string, role database.Role, ip string) error {
+	if !database.IsValidRole(role) {
+		return ErrInvalidRepoRole
+	}
+
+	if err := orgs.OrgStore.SetTeamRepo(org, team, reponame, role); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return ErrTeamOrRepoNotFound
+		}
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{
+		Action:    AuditOrgTeamRepoGrant,
+		Actor:     actor,
+		RepoOwner: org,
+		RepoName:  reponame,
+		IPAddress: ip,
+		Details:   map[string]string{"team": team, "role": string(role)},
+	})
+	return nil
+}
+
+func (orgs *OrgService) RemoveTeamRepo(actor, org, team, reponame, ip string) error {
+	if err := orgs.OrgStore.RemoveTeamRepo(org, team, reponame); err != nil {
+		return err
+	}
+
+	orgs.Audit.Record(database.AuditEvent{
+		Action:    AuditOrgTeamRepoRevoke,
+		Actor:     actor,
+		RepoOwner: org,
+		RepoName:  reponame,
+		IPAddress: ip,
+		Details:   map[string]string{"team": team},
+	})
+	return nil
+}
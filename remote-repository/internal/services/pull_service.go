@@ -1,4 +1,165 @@
 package services
 
+import (
+	"io"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/transfer"
+)
+
 type PullService struct {
-}
\ No newline at end of file
+	RepoStore database.RepoStore
+	Objects   objects.Store
+}
+
+// Pull is resolved before anything is written so failures can still be reported properly
+type Pull struct {
+	repo  *objects.Repo
+	refs  [][2]string
+	skip  map[string]bool
+	heads []string
+}
+
+// Prepare resolves the wanted branches, every branch when none is named.
+// Commits the client has, and their history, are left out of the stream.
+func (ps *PullService) Prepare(username, reponame string, wants, haves []string) (*Pull, error) {
+	repo := &objects.Repo{Store: ps.Objects, Owner: username, Name: reponame}
+
+	if len(wants) == 0 {
+		names, err := ps.RepoStore.GetBranchNames(username, reponame)
+		if err != nil {
+			return nil, err
+		}
+		wants = names
+	}
+
+	pull := &Pull{repo: repo, skip: map[string]bool{}}
+
+	for _, branch := range wants {
+		commit, err := ResolveRef(ps.RepoStore, repo, branch)
+		if err != nil {
+			return nil, err
+		}
+		pull.refs = append(pull.refs, [2]string{branch, commit.Hash})
+		pull.heads = append(pull.heads, commit.Hash)
+	}
+
+	commits := map[string]*objects.Commit{}
+	for _, have := range haves {
+		if !objects.IsHash(have) || !ps.Objects.Exists(username, reponame, have) {
+			continue
+		}
+
+		history, err := ancestors(repo, have, commits)
+		if err != nil {
+			continue
+		}
+
+		for hash := range history {
+			if pull.skip[hash] {
+				continue
+			}
+			pull.skip[hash] = true
+			if err = markTree(repo, commits[hash].TreeHash, pull.skip); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return pull, nil
+}
+
+// Write sends the refs and then every object the client is missing
+func (p *Pull) Write(w io.Writer) error {
+	writer := transfer.NewWriter(w)
+
+	for _, ref := range p.refs {
+		if err := writer.Line(transfer.Ref, ref[0], ref[1]); err != nil {
+			return err
+		}
+	}
+
+	sent := p.skip
+	commits := map[string]*objects.Commit{}
+
+	for _, head := range p.heads {
+		history, err := ancestors(p.repo, head, commits)
+		if err != nil {
+			return err
+		}
+
+		for hash := range history {
+			if sent[hash] {
+				continue
+			}
+
+			if err = p.send(writer, hash, sent); err != nil {
+				return err
+			}
+			if err = p.sendTree(writer, commits[hash].TreeHash, sent); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writer.Done()
+}
+
+func (p *Pull) sendTree(writer *transfer.Writer, treeHash string, sent map[string]bool) error {
+	if sent[treeHash] {
+		return nil
+	}
+
+	tree, err := p.repo.ReadTree(treeHash)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range tree.Entries {
+		if entry.Type == "tree" {
+			err = p.sendTree(writer, entry.Hash, sent)
+		} else if !sent[entry.Hash] {
+			err = p.send(writer, entry.Hash, sent)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.send(writer, treeHash, sent)
+}
+
+func (p *Pull) send(writer *transfer.Writer, hash string, sent map[string]bool) error {
+	data, err := p.repo.Store.Get(p.repo.Owner, p.repo.Name, hash)
+	if err != nil {
+		return err
+	}
+
+	sent[hash] = true
+	return writer.Object(hash, data)
+}
+
+// markTree adds a tree and everything below it to seen
+func markTree(repo *objects.Repo, treeHash string, seen map[string]bool) error {
+	if seen[treeHash] {
+		return nil
+	}
+
+	tree, err := repo.ReadTree(treeHash)
+	if err != nil {
+		return err
+	}
+
+	seen[treeHash] = true
+	for _, entry := range tree.Entries {
+		if entry.Type == "tree" {
+			if err = markTree(repo, entry.Hash, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		seen[entry.Hash] = true
+	}
+	return nil
+}
@@ -1,4 +1,319 @@
 package services
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/transfer"
+)
+
+var (
+	ErrInvalidPush       = errors.New("Invalid Push")
+	ErrHashMismatch      = errors.New("Object does not match its hash")
+	ErrPushTooLarge      = errors.New("Push Too Large")
+	ErrInvalidBranchName = errors.New("Invalid Branch Name")
+	ErrMissingObject     = errors.New("Pushed commit references a missing object")
+	ErrNonFastForward    = errors.New("Update is not a fast forward")
+	ErrForcePushDenied   = errors.New("Forced updates need the maintain role")
+	ErrRepoMoved         = errors.New("Repository was moved while the push waited, push to its new address")
+)
+
+const (
+	MaxPushObjectSize = 100 << 20
+	MaxPushSize       = 1 << 30
+	// Column size of Commit.commitMsg
+	maxCommitMessageLength = 100
+	// Least role allowed to rewrite the history of a branch
+	forcePushRole = database.RoleMaintain
+)
+
+var branchNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
 type PushService struct {
-}
\ No newline at end of file
+	RepoStore database.RepoStore
+	Objects   objects.Store
+	Logger    *slog.Logger
+
+	// Refreshed once refs move
+	SearchService *SearchService
+	Audit         *AuditService
+	// Shared with TransferService and AdminService, a repository is not moved or deleted while it is pushed to
+	Locks *RepoLocks
+}
+
+type RefUpdate struct {
+	Branch string `json:"branch"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+	Force  bool   `json:"force,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type PushResult struct {
+	Objects int         `json:"objects"`
+	Updates []RefUpdate `json:"updates"`
+}
+
+// Push stores the objects of a push stream and then applies its branch updates one by one.
+// Stream errors fail the whole push, a rejected update only fails its own branch.
+// role is what pusher holds on the repository, forced updates need forcePushRole.
+func (ps *PushService) Push(pusher string, role database.Role, ip, username, reponame string, r io.Reader) (*PushResult, error) {
+	unlock := ps.Locks.Lock(username, reponame)
+	defer unlock()
+
+	exists, err := ps.RepoStore.RepoExists(username, reponame)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrRepoMoved
+	}
+
+	result := &PushResult{Updates: []RefUpdate{}}
+	reader := transfer.NewReader(r, MaxPushObjectSize)
+	total := 0
+
+	for {
+		packet, err := reader.Next()
+		if err != nil {
+			if errors.Is(err, transfer.ErrObjectTooLarge) {
+				return nil, fmt.Errorf("%w: %v", ErrPushTooLarge, err)
+			}
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPush, err)
+		}
+
+		if packet.Kind == transfer.Done {
+			break
+		}
+
+		switch packet.Kind {
+		case transfer.Update:
+			update, err := parseUpdate(packet.Fields)
+			if err != nil {
+				return nil, err
+			}
+			result.Updates = append(result.Updates, update)
+
+		case transfer.Object:
+			total += len(packet.Data)
+			if total > MaxPushSize {
+				return nil, ErrPushTooLarge
+			}
+
+			if err = ps.storeObject(username, reponame, packet.Fields[0], packet.Data); err != nil {
+				return nil, err
+			}
+			result.Objects++
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected %s packet", ErrInvalidPush, packet.Kind)
+		}
+	}
+
+	updated := false
+	for i := range result.Updates {
+		err := ps.applyUpdate(pusher, role, username, reponame, &result.Updates[i])
+
+		if err != nil {
+			if !isRejection(err) {
+				return nil, err
+			}
+			result.Updates[i].Error = err.Error()
+			continue
+		}
+		updated = true
+
+		if update := result.Updates[i]; update.Force {
+			ps.Audit.Record(database.AuditEvent{
+				Action:    AuditRepoForcePush,
+				Actor:     pusher,
+				RepoOwner: username,
+				RepoName:  reponame,
+				IPAddress: ip,
+				Details:   map[string]string{"branch": update.Branch, "old": update.Old, "new": update.New},
+			})
+		}
+	}
+
+	if updated {
+		ps.refsMoved(username, reponame)
+	}
+
+	return result, nil
+}
+
+func parseUpdate(fields []string) (RefUpdate, error) {
+	if len(fields) != 3 && (len(fields) != 4 || fields[3] != transfer.Force) {
+		return RefUpdate{}, fmt.Errorf("%w: update needs a branch, old and new commit", ErrInvalidPush)
+	}
+
+	update := RefUpdate{Branch: fields[0], Old: fields[1], New: fields[2], Force: len(fields) == 4}
+
+	if !objects.IsHash(update.Old) || !objects.IsHash(update.New) || update.New == transfer.ZeroHash {
+		return RefUpdate{}, fmt.Errorf("%w: update of %s names an invalid commit", ErrInvalidPush, update.Branch)
+	}
+	return update, nil
+}
+
+// Objects are checked against their name and parsed before they are stored
+func (ps *PushService) storeObject(username, reponame, hash string, data []byte) error {
+	if !objects.IsHash(hash) || objects.Hash(data) != hash {
+		return fmt.Errorf("%w: %s", ErrHashMismatch, hash)
+	}
+
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("blob ")):
+		_, err = objects.ParseBlob(hash, data)
+	case bytes.HasPrefix(data, []byte("tree ")):
+		var tree *objects.Tree
+		if tree, err = objects.ParseTree(hash, data); err == nil {
+			err = checkEntryNames(tree)
+		}
+	case bytes.HasPrefix(data, []byte("commit ")):
+		_, err = objects.ParseCommit(hash, data)
+	default:
+		err = objects.ErrInvalidObject
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPush, hash, err)
+	}
+
+	if ps.Objects.Exists(username, reponame, hash) {
+		return nil
+	}
+	return ps.Objects.Put(username, reponame, hash, data)
+}
+
+// applyUpdate clears Force on updates that turn out to be fast forwards
+func (ps *PushService) applyUpdate(pusher string, role database.Role, username, reponame string, update *RefUpdate) error {
+	if len(update.Branch) > 50 || !branchNameRegex.MatchString(update.Branch) || strings.Contains(update.Branch, "..") {
+		return ErrInvalidBranchName
+	}
+
+	repo := &objects.Repo{Store: ps.Objects, Owner: username, Name: reponame}
+
+	known, err := ps.RepoStore.GetCommitHashes(username, reponame)
+	if err != nil {
+		return err
+	}
+
+	history := map[string]*objects.Commit{}
+	reachable, err := ancestors(repo, update.New, history)
+	if err != nil {
+		if errors.Is(err, objects.ErrObjectNotFound) || errors.Is(err, objects.ErrNotACommit) {
+			return ErrMissingObject
+		}
+		return err
+	}
+
+	// Forced updates still have to name the current head, so concurrent pushes are not lost silently
+	oldHead := ""
+	if update.Old != transfer.ZeroHash {
+		oldHead = update.Old
+		if !reachable[oldHead] && !update.Force {
+			return ErrNonFastForward
+		}
+		if !reachable[oldHead] && !role.AtLeast(forcePushRole) {
+			return ErrForcePushDenied
+		}
+	}
+	if oldHead == "" || reachable[oldHead] {
+		update.Force = false
+	}
+
+	// Commits already recorded were complete when they were pushed, only the new ones are checked
+	checked := map[string]bool{}
+	var commits []database.Commit
+	for hash := range reachable {
+		if known[hash] {
+			continue
+		}
+
+		commit := history[hash]
+		if err = checkTree(repo, commit.TreeHash, checked); err != nil {
+			return err
+		}
+
+		message := commit.Message
+		if len([]rune(message)) > maxCommitMessageLength {
+			message = string([]rune(message)[:maxCommitMessageLength])
+		}
+
+		commits = append(commits, database.Commit{
+			CommitHash:     commit.Hash,
+			AuthorUsername: commit.Author,
+			CommitMsg:      message,
+			CommitTime:     commit.Time(),
+			TreeHash:       commit.TreeHash,
+		})
+	}
+
+	return ps.RepoStore.UpdateBranch(username, reponame, update.Branch, oldHead, update.New, pusher, commits)
+}
+
+// checkTree makes sure a tree and everything below it was stored
+func checkTree(repo *objects.Repo, treeHash string, checked map[string]bool) error {
+	if checked[treeHash] {
+		return nil
+	}
+
+	tree, err := repo.ReadTree(treeHash)
+	if err != nil {
+		if errors.Is(err, objects.ErrObjectNotFound) || errors.Is(err, objects.ErrNotATree) {
+			return ErrMissingObject
+		}
+		return err
+	}
+
+	for _, entry := range tree.Entries {
+		switch entry.Type {
+		case "tree":
+			err = checkTree(repo, entry.Hash, checked)
+		case "blob":
+			if !repo.Store.Exists(repo.Owner, repo.Name, entry.Hash) {
+				err = ErrMissingObject
+			}
+		default:
+			err = ErrMissingObject
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	checked[treeHash] = true
+	return nil
+}
+
+// Rejections are reported per branch, anything else is a server error
+// checkEntryNames keeps names that leave their directory, like .. or a/b, out of stored trees
+func checkEntryNames(tree *objects.Tree) error {
+	for _, entry := range tree.Entries {
+		if !objects.ValidEntryName(entry.Name) {
+			return fmt.Errorf("%w: %q", objects.ErrInvalidName, entry.Name)
+		}
+	}
+	return nil
+}
+
+func isRejection(err error) bool {
+	return err == ErrInvalidBranchName || err == ErrMissingObject || err == ErrNonFastForward || err == ErrForcePushDenied || err == database.ErrStaleBranch
+}
+
+func (ps *PushService) refsMoved(username, reponame string) {
+	go func() {
+		if err := ps.SearchService.IndexRepo(username, reponame); err != nil {
+			ps.Logger.Error("Error indexing repository", "repo", username+"/"+reponame, "error", err)
+		}
+	}()
+}
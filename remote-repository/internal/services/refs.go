@@ -0,0 +1,43 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+)
+
+var ErrRefNotFound = errors.New("Ref not found")
+
+// ResolveRef turns a branch name or a commit hash into the commit it points to,
+// an empty ref is the repository's default branch
+func ResolveRef(repoStore database.RepoStore, repo *objects.Repo, ref string) (*objects.Commit, error) {
+	var err error
+	if ref == "" {
+		ref, err = repoStore.GetDefaultBranch(repo.Owner, repo.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := repoStore.GetBranchHead(repo.Owner, repo.Name, ref)
+
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+		hash = ref
+	}
+
+	commit, err := repo.ReadCommit(hash)
+
+	if err != nil {
+		if errors.Is(err, objects.ErrObjectNotFound) || errors.Is(err, objects.ErrNotACommit) {
+			return nil, ErrRefNotFound
+		}
+		return nil, err
+	}
+
+	return commit, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import "sync"
+
+// RepoLocks keeps pushes, moves and deletions of the same repository from running at once within this instance,
+// a push must not write objects into a directory a transfer, rename or deletion is taking away
+type RepoLocks struct {
+	mu    sync.Mutex
+	locks map[string]*repoLock
+}
+
+type repoLock struct {
+	mu sync.Mutex
+	// Holders and waiters, the entry is dropped when the last one leaves
+	refs int
+}
+
+// Lock blocks until username/reponame is free and returns the function releasing it
+func (rl *RepoLocks) Lock(username, reponame string) func() {
+	key := username + "/" + reponame
+
+	rl.mu.Lock()
+	if rl.locks == nil {
+		rl.locks = map[string]*repoLock{}
+	}
+	lock, ok := rl.locks[key]
+	if !ok {
+		lock = &repoLock{}
+		rl.locks[key] = lock
+	}
+	lock.refs++
+	rl.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		rl.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(rl.locks, key)
+		}
+		rl.mu.Unlock()
+	}
+}
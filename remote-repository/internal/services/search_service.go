@@ -0,0 +1,356 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/trigram"
+)
+
+var ErrInvalidSearchQuery = errors.New("Invalid Search Query")
+
+const (
+	maxSearchFiles        = 50
+	maxMatchesPerFile     = 10
+	maxSnippetLength      = 200
+	maxIndexedBlobSize    = 1 << 20
+	searchResultsPerQuery = maxSearchFiles * maxMatchesPerFile
+)
+
+type SearchService struct {
+	RepoStore database.RepoStore
+	Objects   objects.Store
+	Logger    *slog.Logger
+
+	index *trigram.Index
+	// One IndexRepo per repository at a time, searches do not wait for them
+	indexLocks RepoLocks
+	mu         sync.Mutex
+	// Files at the tip of every indexed branch, keyed by owner/repo then branch name
+	tips map[string]map[string]*indexedTip
+	// Repositories a search started indexing in the background
+	pending map[string]bool
+}
+
+type indexedTip struct {
+	commit string
+	files  []indexedFile
+}
+
+// Only the blob hash is kept, content is read from the object store to confirm a match
+type indexedFile struct {
+	path  string
+	docID string
+	hash  string
+}
+
+type SearchQuery struct {
+	Query         string
+	Regex         bool
+	CaseSensitive bool
+	Repo          string
+	Path          string
+	Extension     string
+}
+
+type SearchMatch struct {
+	LineNumber int    `json:"line_number"`
+	Line       string `json:"line"`
+}
+
+type SearchResult struct {
+	RepoOwner string        `json:"repo_owner"`
+	RepoName  string        `json:"repo_name"`
+	Branch    string        `json:"branch"`
+	Path      string        `json:"path"`
+	Matches   []SearchMatch `json:"matches"`
+}
+
+func NewSearchService(repoStore database.RepoStore, objectStore objects.Store, logger *slog.Logger) *SearchService {
+	return &SearchService{
+		RepoStore: repoStore,
+		Objects:   objectStore,
+		Logger:    logger,
+		index:     trigram.NewIndex(),
+		tips:      map[string]map[string]*indexedTip{},
+		pending:   map[string]bool{},
+	}
+}
+
+// IndexRepo brings the index up to date with the tip of every branch,
+// only blobs that were not indexed before are read
+func (ss *SearchService) IndexRepo(username, reponame string) error {
+	unlock := ss.indexLocks.Lock(username, reponame)
+	defer unlock()
+
+	names, err := ss.RepoStore.GetBranchNames(username, reponame)
+	if err != nil {
+		return err
+	}
+
+	repo := &objects.Repo{Store: ss.Objects, Owner: username, Name: reponame}
+	key := username + "/" + reponame
+
+	ss.mu.Lock()
+	previous := map[string]*indexedTip{}
+	for name, tip := range ss.tips[key] {
+		previous[name] = tip
+	}
+	ss.mu.Unlock()
+
+	// Blobs of the previous tips were indexable, others are read again to find out
+	known := map[string]bool{}
+	for _, tip := range previous {
+		for _, file := range tip.files {
+			known[file.hash] = true
+		}
+	}
+
+	current := map[string]*indexedTip{}
+	// Tips this run indexed, let go of when it fails so the previous ones stay
+	var added []*indexedTip
+	complete := false
+	defer func() {
+		if !complete {
+			for _, tip := range added {
+				ss.removeFiles(tip)
+			}
+		}
+	}()
+
+	for _, name := range names {
+		commit, err := ResolveRef(ss.RepoStore, repo, name)
+		if err != nil {
+			if err == ErrRefNotFound {
+				continue
+			}
+			return err
+		}
+
+		if tip, ok := previous[name]; ok && tip.commit == commit.Hash {
+			current[name] = tip
+			delete(previous, name)
+			continue
+		}
+
+		tip := &indexedTip{commit: commit.Hash}
+		added = append(added, tip)
+		err = repo.Walk(commit.TreeHash, func(filePath string, entry objects.TreeEntry) error {
+			if entry.Type != "blob" {
+				return nil
+			}
+
+			docID := key + "/" + entry.Hash
+			if known[entry.Hash] {
+				ss.index.Add(docID, nil)
+			} else {
+				blob, err := repo.ReadBlob(entry.Hash)
+				if err != nil {
+					return err
+				}
+
+				if len(blob.Content) > maxIndexedBlobSize || bytes.IndexByte(blob.Content, 0) >= 0 {
+					return nil
+				}
+				ss.index.Add(docID, blob.Content)
+				known[entry.Hash] = true
+			}
+
+			tip.files = append(tip.files, indexedFile{path: filePath, docID: docID, hash: entry.Hash})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		current[name] = tip
+	}
+
+	ss.mu.Lock()
+	ss.tips[key] = current
+	ss.mu.Unlock()
+	complete = true
+
+	// Drop what the moved and deleted branches referenced
+	for _, tip := range previous {
+		ss.removeFiles(tip)
+	}
+	return nil
+}
+
+func (ss *SearchService) removeFiles(tip *indexedTip) {
+	for _, file := range tip.files {
+		ss.index.Remove(file.docID)
+	}
+}
+
+// indexInBackground indexes a repository a search found unindexed, once at a time
+func (ss *SearchService) indexInBackground(username, reponame string) {
+	key := username + "/" + reponame
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.pending[key] {
+		return
+	}
+	ss.pending[key] = true
+
+	go func() {
+		if err := ss.IndexRepo(username, reponame); err != nil {
+			ss.Logger.Error("Error indexing repository", "repo", key, "error", err)
+		}
+
+		ss.mu.Lock()
+		delete(ss.pending, key)
+		ss.mu.Unlock()
+	}()
+}
+
+// Search looks for query in every repository username is allowed to read.
+// Repositories that were not indexed yet are indexed in the background and named in the second result,
+// the results leave them out until then.
+func (ss *SearchService) Search(username string, query SearchQuery) ([]SearchResult, []string, error) {
+	pattern := query.Query
+	if !query.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if !query.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil || query.Query == "" {
+		return nil, nil, ErrInvalidSearchQuery
+	}
+
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, nil, ErrInvalidSearchQuery
+	}
+
+	repos, err := ss.RepoStore.GetReadableRepos(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type indexedRepo struct {
+		database.Repository
+		tips map[string]*indexedTip
+	}
+
+	var visible []indexedRepo
+	indexing := []string{}
+	for _, repo := range repos {
+		key := repo.RepoOwner + "/" + repo.RepoName
+		if query.Repo != "" && query.Repo != key {
+			continue
+		}
+
+		// IndexRepo replaces the map of a repository instead of changing it, so it can be read unlocked
+		ss.mu.Lock()
+		tips, indexed := ss.tips[key]
+		ss.mu.Unlock()
+
+		if !indexed {
+			ss.indexInBackground(repo.RepoOwner, repo.RepoName)
+			indexing = append(indexing, key)
+			continue
+		}
+		visible = append(visible, indexedRepo{Repository: repo, tips: tips})
+	}
+
+	candidates, filtered := ss.index.Candidates(parsed)
+	results := []SearchResult{}
+	matchCount := 0
+
+	for _, repo := range visible {
+		tips := repo.tips
+		store := &objects.Repo{Store: ss.Objects, Owner: repo.RepoOwner, Name: repo.RepoName}
+
+		branches := make([]string, 0, len(tips))
+		for name := range tips {
+			branches = append(branches, name)
+		}
+		sort.Strings(branches)
+
+		for _, branch := range branches {
+			for _, file := range tips[branch].files {
+				if !matchesFilters(file.path, query) {
+					continue
+				}
+				if _, ok := candidates[file.docID]; filtered && !ok {
+					continue
+				}
+
+				blob, err := store.ReadBlob(file.hash)
+				if err != nil {
+					// Moved or deleted since it was indexed
+					if errors.Is(err, objects.ErrObjectNotFound) {
+						continue
+					}
+					return nil, nil, err
+				}
+
+				matches := matchLines(re, blob.Content)
+				if len(matches) == 0 {
+					continue
+				}
+
+				results = append(results, SearchResult{
+					RepoOwner: repo.RepoOwner,
+					RepoName:  repo.RepoName,
+					Branch:    branch,
+					Path:      file.path,
+					Matches:   matches,
+				})
+
+				matchCount += len(matches)
+				if len(results) >= maxSearchFiles || matchCount >= searchResultsPerQuery {
+					return results, indexing, nil
+				}
+			}
+		}
+	}
+
+	return results, indexing, nil
+}
+
+func matchesFilters(filePath string, query SearchQuery) bool {
+	if query.Path != "" && !strings.Contains(filePath, query.Path) {
+		return false
+	}
+	if query.Extension != "" && path.Ext(filePath) != "."+strings.TrimPrefix(query.Extension, ".") {
+		return false
+	}
+	return true
+}
+
+func matchLines(re *regexp.Regexp, content []byte) []SearchMatch {
+	var matches []SearchMatch
+	for i, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		if !re.MatchString(line) {
+			continue
+		}
+
+		if len(line) > maxSnippetLength {
+			loc := re.FindStringIndex(line)
+			start := max(0, loc[0]-maxSnippetLength/4)
+			line = strings.ToValidUTF8(line[start:min(len(line), start+maxSnippetLength)], "")
+		}
+
+		matches = append(matches, SearchMatch{LineNumber: i + 1, Line: line})
+		if len(matches) >= maxMatchesPerFile {
+			break
+		}
+	}
+	return matches
+}
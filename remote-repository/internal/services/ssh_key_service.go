@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrInvalidSSHKey      = errors.New("Invalid SSH Public Key")
+	ErrWeakSSHKey         = errors.New("SSH keys must be ed25519, ecdsa or RSA of at least 2048 bits")
+	ErrInvalidSSHKeyName  = errors.New("Invalid SSH Key Name")
+	ErrSSHKeyAlreadyInUse = errors.New("SSH Key or Name Already In Use")
+)
+
+const minRSAKeyBits = 2048
+
+type SSHKeyService struct {
+	SSHKeyStore database.SSHKeyStore
+	Audit       *AuditService
+}
+
+// Add registers a public key in authorized_keys format, the key comment is used when no name is given
+func (ss *SSHKeyService) Add(username, name, publicKey, ip string) (*database.SSHKey, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(publicKey)))
+	if err != nil {
+		return nil, ErrInvalidSSHKey
+	}
+
+	if err = checkKeyStrength(key); err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = comment
+	}
+	if name == "" || len(name) > 100 {
+		return nil, ErrInvalidSSHKeyName
+	}
+
+	created, err := ss.SSHKeyStore.CreateSSHKey(&database.SSHKey{
+		Username:    username,
+		Name:        name,
+		Fingerprint: ssh.FingerprintSHA256(key),
+		// Stored without the comment, which is only a label
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		CreatedAt: time.Now(),
+	})
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrSSHKeyAlreadyInUse
+		}
+		return nil, err
+	}
+
+	ss.Audit.Record(database.AuditEvent{
+		Action:    AuditSSHKeyAdd,
+		Actor:     username,
+		Target:    username,
+		IPAddress: ip,
+		Details:   map[string]string{"id": strconv.Itoa(created.ID), "name": name, "fingerprint": created.Fingerprint},
+	})
+	return created, nil
+}
+
+func checkKeyStrength(key ssh.PublicKey) error {
+	switch key.Type() {
+	case ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+		return nil
+	case ssh.KeyAlgoRSA:
+		cryptoKey, ok := key.(ssh.CryptoPublicKey)
+		if !ok {
+			return ErrInvalidSSHKey
+		}
+		rsaKey, ok := cryptoKey.CryptoPublicKey().(interface{ Size() int })
+		if !ok || rsaKey.Size()*8 < minRSAKeyBits {
+			return ErrWeakSSHKey
+		}
+		return nil
+	default:
+		return ErrWeakSSHKey
+	}
+}
+
+func (ss *SSHKeyService) GetAll(username string) ([]database.SSHKey, error) {
+	return ss.SSHKeyStore.GetSSHKeys(username)
+}
+
+func (ss *SSHKeyService) Delete(username string, id int, ip string) error {
+	if err := ss.SSHKeyStore.DeleteSSHKey(username, id); err != nil {
+		return err
+	}
+
+	ss.Audit.Record(database.AuditEvent{Action: AuditSSHKeyDelete, Actor: username, Target: username, IPAddress: ip, Details: map[string]string{"id": strconv.Itoa(id)}})
+	return nil
+}
+
+// Authenticate maps a key offered to the SSH server to the user who registered it
+func (ss *SSHKeyService) Authenticate(key ssh.PublicKey) (*database.SSHKey, error) {
+	registered, err := ss.SSHKeyStore.GetSSHKeyByFingerprint(ssh.FingerprintSHA256(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ss.SSHKeyStore.TouchSSHKey(registered.ID); err != nil {
+		return nil, err
+	}
+
+	return registered, nil
+}
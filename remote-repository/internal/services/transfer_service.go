@@ -0,0 +1,328 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/objects"
+)
+
+var (
+	ErrNotRepoOwner    = errors.New("Only the owner of the repository can do this")
+	ErrTransferToOwner = errors.New("Repository already belongs to this account")
+	ErrInvalidRepoName = errors.New("Repository names are up to 50 letters, digits, ., - and _")
+	ErrSameRepoName    = errors.New("Repository already has this name")
+	// Same rule as creating a private repository
+	ErrTransferNeedsTwoFactor = errors.New("Enable two factor authentication before accepting private repositories")
+)
+
+var repoNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,50}$`)
+
+// How long a transfer can be accepted
+const transferTimeout = 7 * 24 * time.Hour
+
+// TransferService moves repositories between users and organizations once the recipient accepts
+type TransferService struct {
+	RepoStore     database.RepoStore
+	TransferStore database.TransferStore
+	OrgStore      database.OrgStore
+	UserStore     database.UserStore
+	Objects       objects.Store
+	SearchService *SearchService
+	Notifications *NotificationService
+	TwoFactor     *TwoFactorService
+	Audit         *AuditService
+	Logger        *slog.Logger
+	// Shared with PushService, a repository is not moved while it is pushed to
+	Locks *RepoLocks
+}
+
+// Request offers a repository to recipient, a user or an organization, and notifies whoever can accept it
+func (ts *TransferService) Request(actor, username, reponame, recipient, ip string) (*database.Transfer, error) {
+	if err := ts.checkOwner(actor, username); err != nil {
+		return nil, err
+	}
+
+	if recipient == username {
+		return nil, ErrTransferToOwner
+	}
+
+	user, err := ts.UserStore.GetUserbyUsername(recipient)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	taken, err := ts.RepoStore.RepoExists(recipient, reponame)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if taken {
+		return nil, database.ErrRepoNameTaken
+	}
+
+	now := time.Now()
+	transfer, err := ts.TransferStore.CreateTransfer(&database.Transfer{
+		RepoOwner:   username,
+		RepoName:    reponame,
+		Recipient:   recipient,
+		RequestedBy: actor,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(transferTimeout),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	ts.Audit.Record(database.AuditEvent{Action: AuditRepoTransferRequest, Actor: actor, Target: recipient, RepoOwner: username, RepoName: reponame, IPAddress: ip})
+
+	message := fmt.Sprintf("%s wants to transfer %s/%s to %s. Accept or decline it under /settings/transfers before %s.",
+		actor, username, reponame, recipient, transfer.ExpiresAt.UTC().Format(time.RFC1123))
+
+	for _, accepter := range ts.accepters(user) {
+		ts.Notifications.Notify(database.Notification{
+			Username:  accepter,
+			Kind:      NotifyRepoTransfer,
+			RepoOwner: username,
+			RepoName:  reponame,
+			Message:   message,
+		}, fmt.Sprintf("Transfer of %s/%s", username, reponame))
+	}
+
+	return transfer, nil
+}
+
+func (ts *TransferService) Cancel(actor, username, reponame, ip string) error {
+	if err := ts.checkOwner(actor, username); err != nil {
+		return err
+	}
+
+	transfer, err := ts.TransferStore.CancelTransfer(username, reponame)
+
+	if err != nil {
+		return err
+	}
+
+	ts.Audit.Record(database.AuditEvent{Action: AuditRepoTransferCancel, Actor: actor, Target: transfer.Recipient, RepoOwner: username, RepoName: reponame, IPAddress: ip})
+	return nil
+}
+
+// Incoming lists the transfers username can accept, to themselves or to organizations they own
+func (ts *TransferService) Incoming(username string) ([]database.Transfer, error) {
+	return ts.TransferStore.GetIncomingTransfers(username)
+}
+
+// Accept moves the repository to the recipient, its old location redirects to the new one.
+// Private repositories are only accepted with two factor authentication enabled.
+func (ts *TransferService) Accept(username string, id int, ip string) (*database.Transfer, error) {
+	transfer, err := ts.incoming(username, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	privacy, err := ts.RepoStore.GetRepoPrivacy(transfer.RepoOwner, transfer.RepoName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if privacy == "PRIVATE" {
+		enabled, err := ts.TwoFactor.IsEnabled(username)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !enabled {
+			return nil, ErrTransferNeedsTwoFactor
+		}
+	}
+
+	// The transfer is consumed with the move, one cancelled or answered meanwhile stops it
+	err = ts.move(transfer.RepoOwner, transfer.RepoName, transfer.Recipient, transfer.RepoName, func() error {
+		return ts.RepoStore.AcceptTransfer(transfer)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	ts.Audit.Record(database.AuditEvent{
+		Action:    AuditRepoTransfer,
+		Actor:     username,
+		Target:    transfer.Recipient,
+		RepoOwner: transfer.Recipient,
+		RepoName:  transfer.RepoName,
+		IPAddress: ip,
+		Details:   map[string]string{"from": transfer.RepoOwner + "/" + transfer.RepoName, "requested_by": transfer.RequestedBy},
+	})
+
+	ts.Notifications.Notify(database.Notification{
+		Username:  transfer.RequestedBy,
+		Kind:      NotifyRepoTransfer,
+		RepoOwner: transfer.Recipient,
+		RepoName:  transfer.RepoName,
+		Message:   fmt.Sprintf("%s/%s now belongs to %s, the old address redirects there.", transfer.RepoOwner, transfer.RepoName, transfer.Recipient),
+	}, fmt.Sprintf("Transfer of %s/%s accepted", transfer.RepoOwner, transfer.RepoName))
+
+	return transfer, nil
+}
+
+func (ts *TransferService) Decline(username string, id int, ip string) error {
+	transfer, err := ts.incoming(username, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err = ts.TransferStore.DeclineTransfer(id); err != nil {
+		return err
+	}
+
+	ts.Audit.Record(database.AuditEvent{Action: AuditRepoTransferDecline, Actor: username, Target: transfer.Recipient, RepoOwner: transfer.RepoOwner, RepoName: transfer.RepoName, IPAddress: ip})
+	return nil
+}
+
+// Rename moves a repository to newName under the same owner, the old name redirects until it is reused
+func (ts *TransferService) Rename(actor, username, reponame, newName, ip string) error {
+	if !repoNameRegex.MatchString(newName) || newName == "." || newName == ".." {
+		return ErrInvalidRepoName
+	}
+
+	if newName == reponame {
+		return ErrSameRepoName
+	}
+
+	taken, err := ts.RepoStore.RepoExists(username, newName)
+
+	if err != nil {
+		return err
+	}
+
+	if taken {
+		return database.ErrRepoNameTaken
+	}
+
+	if err = ts.Move(username, reponame, username, newName); err != nil {
+		return err
+	}
+
+	ts.Audit.Record(database.AuditEvent{
+		Action:    AuditRepoRename,
+		Actor:     actor,
+		RepoOwner: username,
+		RepoName:  newName,
+		IPAddress: ip,
+		Details:   map[string]string{"from": reponame},
+	})
+	return nil
+}
+
+// Move gives a repository a new owner and name, with its objects and search index following it.
+// Objects are moved first and moved back when the database refuses the move, pushes wait for both.
+func (ts *TransferService) Move(username, reponame, newOwner, newName string) error {
+	return ts.move(username, reponame, newOwner, newName, func() error {
+		return ts.RepoStore.MoveRepo(username, reponame, newOwner, newName)
+	})
+}
+
+// move runs storeMove, the database side of the move, between moving the objects and reindexing
+func (ts *TransferService) move(username, reponame, newOwner, newName string, storeMove func() error) error {
+	unlock := ts.Locks.Lock(username, reponame)
+	defer unlock()
+
+	if err := ts.Objects.MoveRepo(username, reponame, newOwner, newName); err != nil {
+		return err
+	}
+
+	if err := storeMove(); err != nil {
+		if undo := ts.Objects.MoveRepo(newOwner, newName, username, reponame); undo != nil {
+			ts.Logger.Error("Error moving objects back after a failed move", "repo", username+"/"+reponame, "error", undo)
+		}
+		return err
+	}
+
+	// Without branches left reindexing drops the old location from the search index
+	for _, location := range [][2]string{{username, reponame}, {newOwner, newName}} {
+		if err := ts.SearchService.IndexRepo(location[0], location[1]); err != nil {
+			ts.Logger.Error("Error reindexing moved repository", "repo", location[0]+"/"+location[1], "error", err)
+		}
+	}
+	return nil
+}
+
+// incoming is a pending transfer username can answer, sql.ErrNoRows for everyone else
+func (ts *TransferService) incoming(username string, id int) (*database.Transfer, error) {
+	transfer, err := ts.TransferStore.GetTransfer(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transfer.Recipient == username {
+		return transfer, nil
+	}
+
+	role, err := ts.OrgStore.GetOrgRole(transfer.Recipient, username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if role != database.OrgOwner {
+		return nil, sql.ErrNoRows
+	}
+	return transfer, nil
+}
+
+// checkOwner lets the owner of a repository through, or an owner of the organization owning it
+func (ts *TransferService) checkOwner(actor, username string) error {
+	if actor == username {
+		return nil
+	}
+
+	role, err := ts.OrgStore.GetOrgRole(username, actor)
+
+	if err != nil {
+		return err
+	}
+
+	if role != database.OrgOwner {
+		return ErrNotRepoOwner
+	}
+	return nil
+}
+
+// accepters are who can accept a transfer to recipient, the owners of an organization
+func (ts *TransferService) accepters(recipient *database.User) []string {
+	if recipient.AccountType != database.AccountOrganization {
+		return []string{recipient.Username}
+	}
+
+	members, err := ts.OrgStore.GetOrgMembers(recipient.Username)
+
+	if err != nil {
+		ts.Logger.Error("Error listing organization owners to notify", "org", recipient.Username, "error", err)
+		return nil
+	}
+
+	var owners []string
+	for _, member := range members {
+		if member.Role == database.OrgOwner {
+			owners = append(owners, member.Username)
+		}
+	}
+	return owners
+}
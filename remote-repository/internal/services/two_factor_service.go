@@ -0,0 +1,275 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/totp"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled      = errors.New("Two Factor Authentication Already Enabled")
+	ErrTwoFactorNotEnrolled         = errors.New("Two Factor Authentication Not Enrolled")
+	ErrTwoFactorNotEnabled          = errors.New("Two Factor Authentication Not Enabled")
+	ErrInvalidTwoFactorCode         = errors.New("Invalid Two Factor Code")
+	ErrTwoFactorRequired            = errors.New("Two Factor Authentication Required")
+	ErrPrivateReposNeedTwoFactor    = errors.New("Owners of private repositories must keep two factor authentication enabled")
+	ErrContributorsWithoutTwoFactor = errors.New("Some contributors do not have two factor authentication enabled")
+)
+
+const (
+	twoFactorIssuer   = "JitHub"
+	recoveryCodeCount = 10
+)
+
+type TwoFactorService struct {
+	TwoFactorStore database.TwoFactorStore
+	RepoStore      database.RepoStore
+	Audit          *AuditService
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	// Set while an owner of private repositories still has to enroll
+	RequiredBy *time.Time `json:"required_by,omitempty"`
+}
+
+func (tfs *TwoFactorService) Status(username string) (*TwoFactorStatus, error) {
+	twoFactor, err := tfs.TwoFactorStore.GetTwoFactor(username)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &TwoFactorStatus{Enabled: twoFactor.Enabled, RecoveryCodesLeft: twoFactor.RecoveryCodesLeft}
+	if !twoFactor.Enabled && !twoFactor.GraceUntil.IsZero() {
+		status.RequiredBy = &twoFactor.GraceUntil
+	}
+	return status, nil
+}
+
+func (tfs *TwoFactorService) IsEnabled(username string) (bool, error) {
+	twoFactor, err := tfs.TwoFactorStore.GetTwoFactor(username)
+	if err != nil {
+		return false, err
+	}
+	return twoFactor.Enabled, nil
+}
+
+// Enroll generates a new secret, two factor stays off until Enable confirms a code from it
+func (tfs *TwoFactorService) Enroll(username string) (*TwoFactorEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = tfs.TwoFactorStore.SetPendingSecret(username, secret)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTwoFactorAlreadyEnabled
+		}
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{Secret: secret, URI: totp.URI(twoFactorIssuer, username, secret)}, nil
+}
+
+// Enable turns two factor on once code proves the authenticator app holds the secret,
+// the returned recovery codes are not stored in plain text and cannot be shown again
+func (tfs *TwoFactorService) Enable(username, code, ip string) ([]string, error) {
+	twoFactor, err := tfs.TwoFactorStore.GetTwoFactor(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if twoFactor.Secret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	if err = tfs.verifyTOTP(username, twoFactor, code); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tfs.TwoFactorStore.EnableTwoFactor(username, normalizeRecoveryCodes(recoveryCodes)); err != nil {
+		return nil, err
+	}
+
+	tfs.Audit.Record(database.AuditEvent{Action: AuditTwoFactorEnable, Actor: username, Target: username, IPAddress: ip})
+	return recoveryCodes, nil
+}
+
+func (tfs *TwoFactorService) Disable(username, code, ip string) error {
+	privateRepos, err := tfs.RepoStore.CountPrivateRepos(username)
+	if err != nil {
+		return err
+	}
+
+	if privateRepos > 0 {
+		return ErrPrivateReposNeedTwoFactor
+	}
+
+	if err = tfs.Verify(username, code); err != nil {
+		return err
+	}
+
+	if err = tfs.TwoFactorStore.DisableTwoFactor(username); err != nil {
+		return err
+	}
+
+	tfs.Audit.Record(database.AuditEvent{Action: AuditTwoFactorDisable, Actor: username, Target: username, IPAddress: ip})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not
+func (tfs *TwoFactorService) RegenerateRecoveryCodes(username, code, ip string) ([]string, error) {
+	if err := tfs.Verify(username, code); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tfs.TwoFactorStore.ReplaceRecoveryCodes(username, normalizeRecoveryCodes(recoveryCodes)); err != nil {
+		return nil, err
+	}
+
+	tfs.Audit.Record(database.AuditEvent{Action: AuditRecoveryCodesReset, Actor: username, Target: username, IPAddress: ip})
+	return recoveryCodes, nil
+}
+
+// Verify accepts a code from the authenticator app or an unused recovery code, either works once
+func (tfs *TwoFactorService) Verify(username, code string) error {
+	twoFactor, err := tfs.TwoFactorStore.GetTwoFactor(username)
+	if err != nil {
+		return err
+	}
+
+	if !twoFactor.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return tfs.verifyTOTP(username, twoFactor, code)
+	}
+
+	ok, err := tfs.TwoFactorStore.ConsumeRecoveryCode(username, normalizeRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// SetRepoRequirement makes two factor mandatory for everyone with push access to a repository.
+// It is refused while contributors without two factor remain, they are returned so the owner can follow up.
+func (tfs *TwoFactorService) SetRepoRequirement(username, reponame string, required bool) ([]string, error) {
+	if required {
+		enabled, err := tfs.IsEnabled(username)
+		if err != nil {
+			return nil, err
+		}
+
+		if !enabled {
+			return nil, ErrTwoFactorRequired
+		}
+
+		missing, err := tfs.RepoStore.GetContributorsWithoutTwoFactor(username, reponame)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(missing) > 0 {
+			return missing, ErrContributorsWithoutTwoFactor
+		}
+	}
+
+	return nil, tfs.RepoStore.SetRequireTwoFactor(username, reponame, required)
+}
+
+// CheckContributor refuses adding a contributor without two factor to a repository that requires it
+func (tfs *TwoFactorService) CheckContributor(username, reponame, contributor string) error {
+	required, err := tfs.RepoStore.GetRequireTwoFactor(username, reponame)
+	if err != nil || !required {
+		return err
+	}
+
+	enabled, err := tfs.IsEnabled(contributor)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		return ErrTwoFactorRequired
+	}
+	return nil
+}
+
+func (tfs *TwoFactorService) verifyTOTP(username string, twoFactor *database.TwoFactor, code string) error {
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !ok || step <= twoFactor.LastStep {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// Guards against the same code being replayed concurrently
+	ok, err := tfs.TwoFactorStore.ConsumeStep(username, step)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// Recovery codes look like xxxxx-xxxxx and carry 50 random bits
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 7)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func normalizeRecoveryCodes(codes []string) []string {
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = normalizeRecoveryCode(code)
+	}
+	return normalized
+}
@@ -0,0 +1,354 @@
+// Package sshserver serves push and pull over SSH for users authenticating with a registered public key.
+//
+// Clients run one of two commands on a session:
+//
+//	jit-receive-pack <owner>/<repo>   push, answered with an ok or error packet per update
+//	jit-upload-pack <owner>/<repo>    pull, want and have packets answered with refs and objects
+package sshserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ziad-eliwa/jit-version-control-system/internal/database"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/middleware"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/transfer"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/services"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	receivePack = "jit-receive-pack"
+	uploadPack  = "jit-upload-pack"
+
+	handshakeTimeout = 30 * time.Second
+	// Longest wait before accepting again after Accept failed, e.g. when out of file descriptors
+	maxAcceptDelay = time.Second
+	// Permissions extension carrying the authenticated user
+	usernameExtension = "username"
+	// Most want and have lines a pull may send, every have walks history when the pull is prepared
+	maxPullLines = 1024
+)
+
+var ErrInvalidCommand = errors.New("Invalid Command")
+
+type Server struct {
+	// Generated on first start when missing
+	HostKeyPath string
+
+	SSHKeyService *services.SSHKeyService
+	Authorizer    *middleware.AuthenticationMiddleware
+	PushService   *services.PushService
+	PullService   *services.PullService
+	Logger        *slog.Logger
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	config, err := s.serverConfig()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			// Failures like running out of file descriptors pass, keep serving with a growing pause like net/http does
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else {
+				delay = min(2*delay, maxAcceptDelay)
+			}
+			s.Logger.Error("SSH accept failed, retrying", "delay", delay, "error", err)
+			time.Sleep(delay)
+			continue
+		}
+
+		delay = 0
+		go s.handleConn(conn, config)
+	}
+}
+
+func (s *Server) serverConfig() (*ssh.ServerConfig, error) {
+	hostKey, err := loadHostKey(s.HostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			registered, err := s.SSHKeyService.Authenticate(key)
+			if err != nil {
+				return nil, errors.New("unknown public key")
+			}
+
+			if err = s.Authorizer.CheckSuspended(registered.Username); err != nil {
+				return nil, err
+			}
+
+			return &ssh.Permissions{Extensions: map[string]string{usernameExtension: registered.Username}}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	return config, nil
+}
+
+// loadHostKey reads the host key or creates an ed25519 one so restarts keep the same identity
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(private)
+}
+
+func (s *Server) handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(requests)
+
+	username := serverConn.Permissions.Extensions[usernameExtension]
+	ip, _, _ := net.SplitHostPort(serverConn.RemoteAddr().String())
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			s.Logger.Error("Error accepting ssh channel", "error", err)
+			continue
+		}
+
+		go s.handleSession(username, ip, channel, requests)
+	}
+}
+
+func (s *Server) handleSession(username, ip string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			status := s.runCommand(username, ip, payload.Command, channel)
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+
+		case "shell":
+			req.Reply(true, nil)
+			fmt.Fprintf(channel.Stderr(), "Hi %s! You have authenticated, but JitHub does not provide shell access.\n", username)
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+			return
+
+		case "env", "pty-req":
+			req.Reply(true, nil)
+
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// runCommand authorizes and serves one command, the returned exit status is 0 on success
+func (s *Server) runCommand(username, ip, command string, channel ssh.Channel) uint32 {
+	// A connection outlives the suspension of its user, the key was only checked at the handshake
+	if err := s.Authorizer.CheckSuspended(username); err != nil {
+		if err != middleware.ErrAccountSuspended {
+			s.Logger.Error("Error checking suspension for ssh command", "error", err)
+			err = errors.New("internal server error")
+		}
+		fmt.Fprintln(channel.Stderr(), err)
+		return 1
+	}
+
+	name, owner, repo, err := parseCommand(command)
+	if err != nil {
+		fmt.Fprintf(channel.Stderr(), "%v: %q\n", err, command)
+		return 1
+	}
+
+	// Remotes set up before a transfer or rename keep working from the old address,
+	// the new one is only resolved for users with a role there
+	oldOwner, oldRepo := owner, repo
+	owner, repo = s.Authorizer.ResolveRepo(owner, repo, username)
+
+	write := name == receivePack
+	min := database.RoleRead
+	if write {
+		min = database.RoleWrite
+	}
+
+	role, err := s.Authorizer.CheckRepoAccess(owner, repo, username, min)
+	if err != nil {
+		if !isAccessError(err) {
+			s.Logger.Error("Error authorizing ssh command", "error", err)
+			err = errors.New("internal server error")
+		}
+		fmt.Fprintln(channel.Stderr(), err)
+		return 1
+	}
+
+	if owner != oldOwner || repo != oldRepo {
+		fmt.Fprintf(channel.Stderr(), "%s/%s moved to %s/%s, update your remote\n", oldOwner, oldRepo, owner, repo)
+	}
+
+	if write {
+		err = s.receivePack(username, role, ip, owner, repo, channel)
+	} else {
+		err = s.uploadPack(owner, repo, channel)
+	}
+
+	if err != nil {
+		fmt.Fprintln(channel.Stderr(), err)
+		return 1
+	}
+	return 0
+}
+
+func parseCommand(command string) (name, owner, repo string, err error) {
+	fields := strings.Fields(command)
+	if len(fields) != 2 || (fields[0] != receivePack && fields[0] != uploadPack) {
+		return "", "", "", ErrInvalidCommand
+	}
+
+	path := strings.Trim(fields[1], `'"/`)
+	owner, repo, ok := strings.Cut(path, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return "", "", "", ErrInvalidCommand
+	}
+
+	return fields[0], owner, repo, nil
+}
+
+func isAccessError(err error) bool {
+	return err == middleware.ErrRepoNotFound || err == middleware.ErrRepoAccessDenied ||
+		err == middleware.ErrTwoFactorRequired || err == middleware.ErrEmailNotVerified
+}
+
+func (s *Server) receivePack(username string, role database.Role, ip, owner, repo string, channel ssh.Channel) error {
+	result, err := s.PushService.Push(username, role, ip, owner, repo, channel)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPush) || errors.Is(err, services.ErrHashMismatch) || errors.Is(err, services.ErrPushTooLarge) || err == services.ErrRepoMoved {
+			return err
+		}
+		s.Logger.Error("Error receiving push", "error", err)
+		return errors.New("internal server error")
+	}
+
+	writer := transfer.NewWriter(channel)
+	for _, update := range result.Updates {
+		if update.Error != "" {
+			err = writer.Line(transfer.Error, update.Branch, update.Error)
+		} else {
+			err = writer.Line(transfer.OK, update.Branch)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Done()
+}
+
+func (s *Server) uploadPack(owner, repo string, channel ssh.Channel) error {
+	reader := transfer.NewReader(channel, 0)
+	var wants, haves []string
+
+	for {
+		packet, err := reader.Next()
+		if err != nil {
+			return err
+		}
+
+		if packet.Kind == transfer.Done {
+			break
+		}
+
+		if len(packet.Fields) != 1 || (packet.Kind != transfer.Want && packet.Kind != transfer.Have) {
+			return fmt.Errorf("%w: unexpected %s packet", transfer.ErrMalformedPacket, packet.Kind)
+		}
+
+		if len(wants)+len(haves) >= maxPullLines {
+			return fmt.Errorf("%w: more than %d want and have lines", transfer.ErrMalformedPacket, maxPullLines)
+		}
+
+		if packet.Kind == transfer.Want {
+			wants = append(wants, packet.Fields[0])
+		} else {
+			haves = append(haves, packet.Fields[0])
+		}
+	}
+
+	pull, err := s.PullService.Prepare(owner, repo, wants, haves)
+	if err != nil {
+		if err == services.ErrRefNotFound {
+			return err
+		}
+		s.Logger.Error("Error preparing pull", "error", err)
+		return errors.New("internal server error")
+	}
+
+	if err = pull.Write(channel); err != nil {
+		s.Logger.Error("Error streaming pull", "error", err)
+		return err
+	}
+	return nil
+}
@@ -1,10 +1,75 @@
 package utils
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
+// Shortest secret accepted at startup
+const minSecretLength = 32
+
 func GetConnectionString() string {
 	connectionString := os.Getenv("DATABASE_URL") 
 	return connectionString
-}
\ No newline at end of file
+}
+
+func GetObjectStorePath() string {
+	path := os.Getenv("OBJECT_STORE_PATH")
+	if path == "" {
+		return "objects"
+	}
+	return path
+}
+
+func GetRefreshTokenKey() string {
+	return os.Getenv("REFRESH_TOKEN_KEY")
+}
+
+// GetJWTKeyDir holds the PEM keys access tokens are signed with, see package signing
+func GetJWTKeyDir() string {
+	return GetEnv("JWT_KEY_DIR", "jwt-keys")
+}
+
+// CheckSecret refuses a secret that is unset or too short to resist guessing
+func CheckSecret(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s must be set", name)
+	}
+	if len(value) < minSecretLength {
+		return fmt.Errorf("%s must be at least %d characters long", name, minSecretLength)
+	}
+	return nil
+}
+
+// GetEnv returns the variable or fallback when it is unset
+func GetEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvInt returns the variable as a number, or fallback when it is unset or not a number
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetBaseURL is where the server is reached from outside, links in emails point there
+func GetBaseURL() string {
+	return GetEnv("BASE_URL", "http://localhost:8080")
+}
+
+// GetTrustedProxies lists the proxies whose X-Forwarded-For is believed, none by default
+func GetTrustedProxies() []string {
+	proxies := GetEnv("TRUSTED_PROXIES", "")
+	if proxies == "" {
+		return nil
+	}
+	return strings.Split(proxies, ",")
+}
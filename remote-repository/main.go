@@ -9,14 +9,27 @@ import (
 	"time"
 
 	"github.com/ziad-eliwa/jit-version-control-system/internal/app"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/pkg/signing"
 	"github.com/ziad-eliwa/jit-version-control-system/internal/routes"
+	"github.com/ziad-eliwa/jit-version-control-system/internal/utils"
 )
 
 func main() {
-	var port int
+	var port, sshPort int
 	flag.IntVar(&port, "port", 8080, "GO Backend Server Port")
+	flag.IntVar(&sshPort, "ssh-port", 2222, "SSH Push/Pull Port, 0 disables it")
+	generateJWTKey := flag.Bool("generate-jwt-key", false, "Add a new JWT signing key to JWT_KEY_DIR and exit, it signs from the next start")
 	flag.Parse()
 
+	if *generateJWTKey {
+		path, err := signing.Generate(utils.GetJWTKeyDir())
+		if err != nil {
+			log.Fatalf("Generating JWT signing key: %v", err)
+		}
+		fmt.Println("New JWT signing key written to", path)
+		return
+	}
+
 	logger := slog.Default()
 
 	app, err := app.NewApplication(logger)
@@ -25,15 +38,24 @@ func main() {
 		panic(err)
 	}
 	defer app.DB.Close()
+	defer app.Cache.Close()
 
 	r := route.SetupRoutes(app)
 
 	server := http.Server{
-		Addr:         fmt.Sprintf(":%v", port),
-		Handler:      r,
-		ReadTimeout:  time.Second * 30,
-		IdleTimeout:  time.Second * 30,
-		WriteTimeout: time.Second * 30,
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: r,
+		// Bodies and responses get per route deadlines, see middleware.Deadline
+		ReadHeaderTimeout: time.Second * 30,
+		IdleTimeout:       time.Second * 30,
+	}
+
+	if sshPort != 0 {
+		go func() {
+			app.Logger.Info("SSH server is running on port", "port", sshPort)
+			err := app.SSHServer.ListenAndServe(fmt.Sprintf(":%v", sshPort))
+			app.Logger.Error("SSH server stopped", "error", err)
+		}()
 	}
 
 	app.Logger.Info("Server is running on port", "port", port)
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE Repository ADD COLUMN IF NOT EXISTS defaultBranch VARCHAR(50) NOT NULL DEFAULT 'main';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Repository DROP COLUMN IF EXISTS defaultBranch;
+-- +goose StatementEnd
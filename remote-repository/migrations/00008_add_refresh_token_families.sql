@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE RefreshTokens
+    ADD COLUMN IF NOT EXISTS family_id VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS replaced_by VARCHAR(50);
+
+-- Tokens issued before rotation each start their own family
+UPDATE RefreshTokens SET family_id = refreshtoken WHERE family_id IS NULL;
+
+ALTER TABLE RefreshTokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS refreshtokens_family_id_idx ON RefreshTokens(family_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS refreshtokens_family_id_idx;
+ALTER TABLE RefreshTokens DROP COLUMN IF EXISTS family_id, DROP COLUMN IF EXISTS replaced_by;
+-- +goose StatementEnd
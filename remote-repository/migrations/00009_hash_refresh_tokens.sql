@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Plaintext tokens can not be hashed without the server key, every session has to log in again
+DELETE FROM RefreshTokens;
+
+ALTER TABLE RefreshTokens
+    ALTER COLUMN refreshtoken TYPE VARCHAR(64),
+    ALTER COLUMN replaced_by TYPE VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM RefreshTokens;
+
+ALTER TABLE RefreshTokens
+    ALTER COLUMN refreshtoken TYPE VARCHAR(50),
+    ALTER COLUMN replaced_by TYPE VARCHAR(50);
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS OAuthIdentities (
+    provider VARCHAR(20),
+    subject VARCHAR(255),
+    username VARCHAR(50) NOT NULL,
+    email_address VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY (username) REFERENCES Users(username) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS OAuthIdentities;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+    username VARCHAR(50),
+    code_hash VARCHAR(64),
+    used_at TIMESTAMP,
+    PRIMARY KEY (username, code_hash),
+    FOREIGN KEY (username) REFERENCES Users(username) ON DELETE CASCADE
+);
+
+ALTER TABLE Repository ADD COLUMN IF NOT EXISTS requireTwoFactor BOOLEAN NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Repository DROP COLUMN IF EXISTS requireTwoFactor;
+DROP TABLE IF EXISTS RecoveryCodes;
+ALTER TABLE Users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE Users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE Users DROP COLUMN IF EXISTS totp_secret;
+-- +goose StatementEnd
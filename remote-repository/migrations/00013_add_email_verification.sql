@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT false;
+-- Accounts created before verification existed keep working
+UPDATE Users SET email_verified = true;
+
+CREATE TABLE IF NOT EXISTS EmailTokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    username VARCHAR(50) NOT NULL,
+    purpose VARCHAR(20) NOT NULL CHECK (purpose IN ('VERIFY_EMAIL','RESET_PASSWORD')),
+    email_address VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES Users(username) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS EmailTokens;
+ALTER TABLE Users DROP COLUMN IF EXISTS email_verified;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS SSHKeys (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    fingerprint VARCHAR(100) UNIQUE NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    UNIQUE (username, name),
+    FOREIGN KEY (username) REFERENCES Users(username) ON DELETE CASCADE
+);
+
+-- Set by pushes, branches without it fall back to their latest commit
+ALTER TABLE Branch ADD COLUMN IF NOT EXISTS headCommit VARCHAR(10);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Branch DROP COLUMN IF EXISTS headCommit;
+DROP TABLE IF EXISTS SSHKeys;
+-- +goose StatementEnd
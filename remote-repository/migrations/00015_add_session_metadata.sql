@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A token family is one signed in device, its live token carries what was last seen of it
+ALTER TABLE RefreshTokens
+    ADD COLUMN IF NOT EXISTS user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+
+UPDATE RefreshTokens SET last_used_at = created_at WHERE last_used_at IS NULL;
+
+ALTER TABLE RefreshTokens ALTER COLUMN last_used_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS refreshtokens_username_idx ON RefreshTokens(username) WHERE revoked = false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS refreshtokens_username_idx;
+ALTER TABLE RefreshTokens DROP COLUMN IF EXISTS user_agent, DROP COLUMN IF EXISTS ip_address, DROP COLUMN IF EXISTS last_used_at;
+-- +goose StatementEnd
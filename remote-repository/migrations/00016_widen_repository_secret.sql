@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Secrets are 32 random bytes hex encoded and sign remote requests
+ALTER TABLE Repository ALTER COLUMN secret TYPE VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Repository ALTER COLUMN secret TYPE VARCHAR(32) USING LEFT(secret, 32);
+-- +goose StatementEnd
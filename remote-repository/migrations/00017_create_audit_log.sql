@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every entry hashes the previous entry's hash with its own content, editing or removing one breaks the chain after it
+CREATE TABLE IF NOT EXISTS AuditLog (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    -- No foreign keys, events outlive the accounts and repositories they name
+    actor VARCHAR(50) NOT NULL DEFAULT '',
+    target VARCHAR(50) NOT NULL DEFAULT '',
+    repo_owner VARCHAR(50) NOT NULL DEFAULT '',
+    repo_name VARCHAR(50) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '{}',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) UNIQUE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS auditlog_actor_idx ON AuditLog(actor, id);
+CREATE INDEX IF NOT EXISTS auditlog_target_idx ON AuditLog(target, id);
+CREATE INDEX IF NOT EXISTS auditlog_repo_idx ON AuditLog(repo_owner, repo_name, id);
+
+CREATE OR REPLACE FUNCTION auditlog_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'AuditLog is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER auditlog_append_only BEFORE UPDATE OR DELETE ON AuditLog
+    FOR EACH ROW EXECUTE FUNCTION auditlog_append_only();
+
+CREATE TRIGGER auditlog_no_truncate BEFORE TRUNCATE ON AuditLog
+    FOR EACH STATEMENT EXECUTE FUNCTION auditlog_append_only();
+
+-- Site administrators read every audit event, granted directly in the database
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Users DROP COLUMN IF EXISTS is_admin;
+DROP TABLE IF EXISTS AuditLog;
+DROP FUNCTION IF EXISTS auditlog_append_only();
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Suspended accounts fail authentication until an administrator lifts the suspension
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS suspended BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS suspended_reason TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Users DROP COLUMN IF EXISTS suspended_reason;
+ALTER TABLE Users DROP COLUMN IF EXISTS suspended;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Collaborators granted before roles existed could push, so they keep write
+ALTER TABLE RepositoryUsers ADD COLUMN IF NOT EXISTS role VARCHAR(8) NOT NULL DEFAULT 'write'
+    CHECK (role IN ('read','triage','write','maintain','admin'));
+ALTER TABLE RepositoryUsers ALTER COLUMN role DROP DEFAULT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE RepositoryUsers DROP COLUMN IF EXISTS role;
+-- +goose StatementEnd
@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Organizations share the namespace of users, they cannot sign in so they have no password or email address
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS account_type VARCHAR(12) NOT NULL DEFAULT 'user'
+    CHECK (account_type IN ('user','organization'));
+ALTER TABLE Users ALTER COLUMN email_address DROP NOT NULL;
+ALTER TABLE Users ADD CONSTRAINT users_email_required CHECK (account_type = 'organization' OR email_address IS NOT NULL);
+
+CREATE TABLE IF NOT EXISTS OrganizationMembers (
+    org VARCHAR(50) REFERENCES Users(username) ON DELETE CASCADE,
+    username VARCHAR(50) REFERENCES Users(username) ON DELETE CASCADE,
+    role VARCHAR(6) NOT NULL CHECK (role IN ('member','owner')),
+    PRIMARY KEY (org, username)
+);
+
+CREATE TABLE IF NOT EXISTS Teams (
+    org VARCHAR(50) REFERENCES Users(username) ON DELETE CASCADE,
+    name VARCHAR(50) CHECK (name ~ '^[a-z0-9_-]+$'),
+    description TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (org, name)
+);
+
+-- Leaving the organization drops every team membership in it
+CREATE TABLE IF NOT EXISTS TeamMembers (
+    org VARCHAR(50),
+    team VARCHAR(50),
+    username VARCHAR(50),
+    PRIMARY KEY (org, team, username),
+    FOREIGN KEY (org, team) REFERENCES Teams(org, name) ON DELETE CASCADE,
+    FOREIGN KEY (org, username) REFERENCES OrganizationMembers(org, username) ON DELETE CASCADE
+);
+
+-- Teams only hold roles on repositories of their organization
+CREATE TABLE IF NOT EXISTS TeamRepositories (
+    org VARCHAR(50),
+    team VARCHAR(50),
+    repoName VARCHAR(50),
+    role VARCHAR(8) NOT NULL CHECK (role IN ('read','triage','write','maintain','admin')),
+    PRIMARY KEY (org, team, repoName),
+    FOREIGN KEY (org, team) REFERENCES Teams(org, name) ON DELETE CASCADE,
+    FOREIGN KEY (repoName, org) REFERENCES Repository(repoName, repoOwner) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS team_members_username ON TeamMembers (username);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS TeamRepositories, TeamMembers, Teams, OrganizationMembers;
+DELETE FROM Users WHERE account_type = 'organization';
+ALTER TABLE Users DROP CONSTRAINT IF EXISTS users_email_required;
+ALTER TABLE Users ALTER COLUMN email_address SET NOT NULL;
+ALTER TABLE Users DROP COLUMN IF EXISTS account_type;
+-- +goose StatementEnd
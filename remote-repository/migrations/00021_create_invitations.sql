@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Granting a role to someone who is not a collaborator yet invites them, the role applies once they accept
+CREATE TABLE IF NOT EXISTS RepositoryInvitations (
+    id SERIAL PRIMARY KEY,
+    repoOwner VARCHAR(50) NOT NULL,
+    repoName VARCHAR(50) NOT NULL,
+    invitee VARCHAR(50) NOT NULL REFERENCES Users(username) ON DELETE CASCADE,
+    inviter VARCHAR(50) NOT NULL,
+    role VARCHAR(8) NOT NULL CHECK (role IN ('read','triage','write','maintain','admin')),
+    createdAt TIMESTAMP NOT NULL,
+    expiresAt TIMESTAMP NOT NULL,
+    UNIQUE (repoOwner, repoName, invitee),
+    FOREIGN KEY (repoName, repoOwner) REFERENCES Repository(repoName, repoOwner) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS repository_invitations_invitee ON RepositoryInvitations (invitee);
+
+CREATE TABLE IF NOT EXISTS Notifications (
+    id BIGSERIAL PRIMARY KEY,
+    username VARCHAR(50) NOT NULL REFERENCES Users(username) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    message TEXT NOT NULL,
+    -- Kept as plain text, notifications outlive the repositories they mention
+    repoOwner VARCHAR(50) NOT NULL DEFAULT '',
+    repoName VARCHAR(50) NOT NULL DEFAULT '',
+    createdAt TIMESTAMP NOT NULL,
+    readAt TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS notifications_username ON Notifications (username, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS Notifications;
+DROP TABLE IF EXISTS RepositoryInvitations;
+-- +goose StatementEnd
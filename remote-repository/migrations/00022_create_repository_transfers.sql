@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Transfers wait for the recipient, or an owner of the recipient organization, to accept
+CREATE TABLE IF NOT EXISTS RepositoryTransfers (
+    id SERIAL PRIMARY KEY,
+    repoOwner VARCHAR(50) NOT NULL,
+    repoName VARCHAR(50) NOT NULL,
+    recipient VARCHAR(50) NOT NULL REFERENCES Users(username) ON DELETE CASCADE,
+    requestedBy VARCHAR(50) NOT NULL,
+    createdAt TIMESTAMP NOT NULL,
+    expiresAt TIMESTAMP NOT NULL,
+    UNIQUE (repoOwner, repoName),
+    FOREIGN KEY (repoName, repoOwner) REFERENCES Repository(repoName, repoOwner) ON DELETE CASCADE
+);
+
+-- Old locations of moved repositories, dropped once a repository takes the old location again
+CREATE TABLE IF NOT EXISTS RepositoryRedirects (
+    oldOwner VARCHAR(50),
+    oldName VARCHAR(50),
+    newOwner VARCHAR(50) NOT NULL,
+    newName VARCHAR(50) NOT NULL,
+    createdAt TIMESTAMP NOT NULL,
+    PRIMARY KEY (oldOwner, oldName),
+    FOREIGN KEY (newName, newOwner) REFERENCES Repository(repoName, repoOwner) ON DELETE CASCADE
+);
+
+-- Moving a repository rewrites its key in every table, so references to it are checked at commit
+DO $$
+DECLARE
+    fk record;
+BEGIN
+    FOR fk IN
+        SELECT conrelid::regclass AS tbl, conname FROM pg_constraint
+        WHERE contype = 'f' AND confrelid IN ('repository'::regclass, 'branch'::regclass, 'commit'::regclass)
+    LOOP
+        EXECUTE format('ALTER TABLE %s ALTER CONSTRAINT %I DEFERRABLE INITIALLY IMMEDIATE', fk.tbl, fk.conname);
+    END LOOP;
+END $$;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DO $$
+DECLARE
+    fk record;
+BEGIN
+    FOR fk IN
+        SELECT conrelid::regclass AS tbl, conname FROM pg_constraint
+        WHERE contype = 'f' AND confrelid IN ('repository'::regclass, 'branch'::regclass, 'commit'::regclass)
+    LOOP
+        EXECUTE format('ALTER TABLE %s ALTER CONSTRAINT %I NOT DEFERRABLE', fk.tbl, fk.conname);
+    END LOOP;
+END $$;
+
+DROP TABLE IF EXISTS RepositoryRedirects;
+DROP TABLE IF EXISTS RepositoryTransfers;
+-- +goose StatementEnd
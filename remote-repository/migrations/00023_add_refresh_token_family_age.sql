@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every token of a family carries when the family signed in, rotation stops once it is too old
+ALTER TABLE RefreshTokens ADD COLUMN IF NOT EXISTS family_created_at TIMESTAMP;
+
+UPDATE RefreshTokens AS t SET family_created_at =
+    (SELECT MIN(f.created_at) FROM RefreshTokens AS f WHERE f.family_id = t.family_id)
+WHERE family_created_at IS NULL;
+
+ALTER TABLE RefreshTokens ALTER COLUMN family_created_at SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE RefreshTokens DROP COLUMN IF EXISTS family_created_at;
+-- +goose StatementEnd
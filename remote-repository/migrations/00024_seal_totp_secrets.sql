@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- TOTP secrets are sealed with AES-GCM, the server seals the plaintext ones on start
+ALTER TABLE Users ALTER COLUMN totp_secret TYPE VARCHAR(255);
+
+-- Owners of private repositories without two factor keep access while they enroll
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS two_factor_grace_until TIMESTAMP;
+
+UPDATE Users SET two_factor_grace_until = NOW() + INTERVAL '30 days'
+WHERE totp_enabled = false
+    AND username IN (SELECT repoOwner FROM Repository WHERE privacy = 'PRIVATE');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE Users DROP COLUMN IF EXISTS two_factor_grace_until;
+-- +goose StatementEnd